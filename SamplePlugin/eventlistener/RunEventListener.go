@@ -0,0 +1,87 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+//
+// Main package for the generic event listener. It runs the event consumers
+// chosen by type in the listener configuration file, among the ones
+// compiled in below.
+package main
+
+import (
+  "aplos/partners/WebhooksListener/config"
+  "aplos/partners/WebhooksListener/logger"
+  "aplos/partners/WebhooksListener/logger/glogger"
+  "aplos/partners/WebhooksListener/registry"
+  "aplos/partners/WebhooksListener/tracing"
+  "context"
+  "flag"
+  "fmt"
+  "os"
+  "strings"
+
+  // Event consumers compiled in, registered by type. Consumers of type
+  // "plugin" run out of process, see WebhooksListener/plugin.
+  _ "aplos/partners/WebhooksListener/consumers"
+  _ "aplos/partners/WebhooksListener/plugin"
+  _ "aplos/partners/f5eventconsumer/impl"
+  _ "aplos/partners/pafweventconsumer/impl"
+)
+
+// Listener configuration file.
+var configPath = flag.String("config",
+  "/opt/eventlistener/config/listener.yaml",
+  "Listener configuration file, YAML or JSON.")
+
+func usage() {
+  fmt.Fprintf(os.Stderr, "usage: eventlistener -config=[string] -stderrthreshold=[INFO|WARN|FATAL] -log_dir=[string]\n", )
+  fmt.Fprintf(os.Stderr, "consumer types: %s\n",
+              strings.Join(registry.Types(), ", "))
+  flag.PrintDefaults()
+  os.Exit(2)
+}
+
+func init() {
+  flag.Usage = usage
+  flag.Parse()
+  // Keep the glog file layout under -log_dir for the listener & consumers.
+  logger.SetLogger(glogger.New())
+}
+
+func main() {
+  // Set up tracing as per the OTEL_* environment variables.
+  shutdownTracing, err := tracing.Init(tracing.ConfigFromEnv())
+  if (err != nil) {
+    logger.Error("Failed to set up tracing.", logger.ErrorKey, err)
+    return
+  }
+  defer shutdownTracing(context.Background())
+
+  // Load the listener configuration file.
+  listenerConfig, err := config.Load(*configPath)
+  if (err != nil) {
+    logger.Error("Failed to load config. Cannot proceed.", logger.ErrorKey, err)
+    return
+  }
+  config.ConfigureLogging(listenerConfig.Logging)
+
+  // Build the configured event consumers, each receiving its own events.
+  consumers, err := registry.NewConsumerSet(listenerConfig.Consumers)
+  if (err != nil) {
+    logger.Error("Invalid consumer config.", logger.ErrorKey, err)
+    return
+  }
+  // The set retries its members, the listener does not retry it again.
+  consumers.RetryPolicy = config.RetryPolicy(listenerConfig.Dispatch)
+
+  // Initialize the listeners & register for the events of all consumers.
+  listeners, err := config.StartListeners(listenerConfig,
+    listenerConfig.Events, consumers)
+  if (err != nil) {
+    logger.Error("Failed to start listeners.", logger.ErrorKey, err)
+    return
+  }
+
+  // Reload the configuration on SIGHUP or when the file changes.
+  reloader := config.NewReloader(*configPath, listenerConfig, "", consumers,
+    listeners)
+  go reloader.Watch(nil)
+  config.Wait(listeners)
+}
@@ -1,5 +1,7 @@
 package config
 
+import "aplos/partners/WebhooksListener/schemas"
+
 type F5Config struct {
 	F5InstanceConfig struct {
 		IP       string `json:"ip"`
@@ -8,14 +10,17 @@ type F5Config struct {
 			PoolMembers []string `json:"pool_members"`
 			PoolName    string   `json:"pool_name"`
 		} `json:"pools"`
-		Port        string `json:"port"`
-		Serviceport string `json:"serviceport"`
-		Username    string `json:"username"`
+		Port        string             `json:"port"`
+		Proxy       schema.ProxyConfig `json:"proxy"`
+		Serviceport string             `json:"serviceport"`
+		Username    string             `json:"username"`
 	} `json:"f5_instance_config"`
 	NutanixClusterConfig struct {
-		IP       string `json:"ip"`
-		Password string `json:"password"`
-		Port     string `json:"port"`
-		Username string `json:"username"`
+		IP        string             `json:"ip"`
+		Endpoints []string           `json:"endpoints"`
+		Password  string             `json:"password"`
+		Port      string             `json:"port"`
+		Proxy     schema.ProxyConfig `json:"proxy"`
+		Username  string             `json:"username"`
 	} `json:"nutanix_cluster_config"`
 }
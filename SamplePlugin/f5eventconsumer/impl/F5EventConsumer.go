@@ -15,22 +15,51 @@ import (
   "encoding/base64"
   "fmt"
   "aplos/partners/f5eventconsumer/config"
-  "github.com/golang/glog"
   "io/ioutil"
+  "aplos/partners/WebhooksListener/interfaces"
   "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/logger"
+  "aplos/partners/WebhooksListener/registry"
   "aplos/partners/WebhooksListener/schemas"
   "regexp"
+  "sync/atomic"
 )
 
 type F5EventConsumer struct {
   // Type that implements the EventConsumer interface.
+
+  // Current configuration, shared by the copies of the consumer. The
+  // configuration is loaded from the configuration directory for every
+  // event if nil, i.e. if the consumer was not created by
+  // NewF5EventConsumer.
+  current *atomic.Value
 }
 
 const (
   // F5 Config Directory Path
   F5ConfigDir = "/opt/f5/config/"
+
+  // Name identifying the consumer in logs & metrics.
+  ConsumerName = "f5"
 )
 
+func init() {
+  registry.Register(ConsumerName,
+    func(config []byte) (interfaces.EventConsumer, error) {
+      return NewF5EventConsumer(config)
+    })
+}
+
+// This method will return the name of the consumer.
+//
+// Args:
+//    None.
+// Returns:
+//    string : Consumer name.
+func (f5EventConsumer F5EventConsumer) Name() (string) {
+  return ConsumerName
+}
+
 // This method will act as a callback method that will configure the networking
 // appliance based on the networking events received through the listener.
 //
@@ -41,11 +70,12 @@ const (
 func (f5EventConsumer F5EventConsumer) OnEvent(event schema.Event) (error) {
   var err error
 
-  glog.Info("Received event of type " + event.Event_Type)
+  log := eventLogger(event)
+  log.Info("Received event.")
   // Load F5 BIG IP Event consumer configuration file.
-  f5Config, err := LoadF5Config()
+  f5Config, err := f5EventConsumer.config()
   if (err != nil) {
-    glog.Error("Failed to load config. Cannot proceed.", err)
+    log.Error("Failed to load config. Cannot proceed.", logger.ErrorKey, err)
     return err
   }
 
@@ -60,12 +90,24 @@ func (f5EventConsumer F5EventConsumer) OnEvent(event schema.Event) (error) {
     }
   }
   if (err != nil) {
-    glog.Error("Failed to process event.", err)
+    log.Error("Failed to process event.", logger.ErrorKey, err)
   }
 
   return err
 }
 
+// This method will return a logger with the fields of the given event.
+//
+// Args:
+//    event : Event object containing the event data sent by the listener.
+// Returns:
+//    Logger : Logger for the event.
+func eventLogger(event schema.Event) (logger.Logger) {
+  return logger.With(logger.ConsumerKey, ConsumerName,
+    logger.EventTypeKey, event.Event_Type,
+    logger.VMUUIDKey, event.EntityReference.UUID)
+}
+
 // This method will process the VM.ON event. It will make the
 // intended configuration on the target virtual appliance.
 //
@@ -77,21 +119,24 @@ func (f5EventConsumer F5EventConsumer) OnEvent(event schema.Event) (error) {
 func onVmOn(event schema.Event, f5Config config.F5Config) (error) {
   var err error
   vmIPAddress := event.Data.Metadata.Status.Resources.NICList[0].IPEndPointList[0].IPAddress
-  vmCategoryPool := event.Data.Metadata.SubMetadata.Categories.NetworkFunctionProvider
-  glog.Infof("Processing '%s' event.", event.Event_Type)
+  vmCategoryPool := event.Data.Metadata.SubMetadata.Categories[lib.NetworkFunctionProviderCategory]
+  log := eventLogger(event)
+  log.Info("Processing event.")
+  proxy := f5Config.F5InstanceConfig.Proxy
   // Prepare F5 BIG IP REST API for creating or updating load balancing pool
   baseURL := fmt.Sprintf("https://%s:%s/mgmt/tm/ltm/pool",
     f5Config.F5InstanceConfig.IP,
     f5Config.F5InstanceConfig.Port)
 
   // Check if Pool already exist.
-  glog.Infof("Checking if Pool '%s' already exists.", vmCategoryPool)
+  log.Info("Checking if Pool already exists.", "pool", vmCategoryPool)
   requestURL := fmt.Sprintf("%s/%s", baseURL, vmCategoryPool)
 
   // Prepare Request to the F5 BIG IP virtual appliance
-  request := lib.PrepareRequest(requestURL,
+  request := lib.PrepareRequestWithProxy(requestURL,
                                   f5Config.F5InstanceConfig.Username,
-				  f5Config.F5InstanceConfig.Password, "GET")
+				  f5Config.F5InstanceConfig.Password, "GET", proxy)
+  request.Context = event.Context()
 
   response, err := lib.DoRequest(request)
   respData, _ := ioutil.ReadAll(response.Body)
@@ -99,46 +144,49 @@ func onVmOn(event schema.Event, f5Config config.F5Config) (error) {
   matched, _ := regexp.MatchString(pattern, string(respData))
   // Load Balancing pool does not exist. Create a pool.
   if (matched == false) {
-    glog.Infof("Pool '%s' not exists. Creating now.", vmCategoryPool)
+    log.Info("Pool not exists. Creating now.", "pool", vmCategoryPool)
     // Prepare Request to the F5 BIG IP virtual appliance
-    request = lib.PrepareRequest(baseURL,
+    request = lib.PrepareRequestWithProxy(baseURL,
                                   f5Config.F5InstanceConfig.Username,
-				  f5Config.F5InstanceConfig.Password, "POST")
+				  f5Config.F5InstanceConfig.Password, "POST", proxy)
+    request.Context = event.Context()
     request.RequestData = fmt.Sprintf("{\"name\": \"%s\"}", vmCategoryPool)
-    glog.Info(requestURL)
-    glog.Info(request.RequestData)
+    log.Debug("Creating pool.", logger.URLKey, baseURL,
+      "data", lib.RedactBody(request.RequestData))
     response, err = lib.DoRequest(request)
     if (err != nil || (
         response.StatusCode != 200 && response.StatusCode != 409)) {
-      glog.Error("Failed to create pool.", err)
+      log.Error("Failed to create pool.", logger.ErrorKey, err)
       return err
     }
   } else {
-    glog.Infof("Pool '%s' already exists.", vmCategoryPool)
+    log.Info("Pool already exists.", "pool", vmCategoryPool)
   }
 
   // Add members to the pool.
   requestURL = fmt.Sprintf("%s/%s/members", baseURL, vmCategoryPool)
   // Prepare Request to the F5 BIG IP virtual appliance
-  request = lib.PrepareRequest(requestURL,
+  request = lib.PrepareRequestWithProxy(requestURL,
                                   f5Config.F5InstanceConfig.Username,
-				  f5Config.F5InstanceConfig.Password, "POST")
+				  f5Config.F5InstanceConfig.Password, "POST", proxy)
+  request.Context = event.Context()
   request.RequestData = fmt.Sprintf("{\"name\": \"%s:%s\"}",
     vmIPAddress, f5Config.F5InstanceConfig.Serviceport)
-  glog.Info(requestURL)
-  glog.Info(request.RequestData)
+  log.Debug("Adding pool member.", logger.URLKey, requestURL,
+    "data", lib.RedactBody(request.RequestData))
   response, err = lib.DoRequest(request)
   if (err != nil || (
         response.StatusCode != 200 && response.StatusCode != 409)) {
-    glog.Error("Failed to add member to pool.", err)
+    log.Error("Failed to add member to pool.", logger.ErrorKey, err)
     return err
   }
   if (response.StatusCode == 409) {
-    glog.Warning("Member already added to the pool.")
+    log.Warn("Member already added to the pool.")
     return nil
   }
   resp, _ := ioutil.ReadAll(response.Body)
-  glog.Info("Successfully added member to pool.", string(resp))
+  log.Info("Successfully added member to pool.",
+    "response", lib.RedactBody(string(resp)))
   return err
 }
 
@@ -152,31 +200,116 @@ func onVmOn(event schema.Event, f5Config config.F5Config) (error) {
 //    error : Error, if any.
 func onVmOff(event schema.Event, f5Config config.F5Config) (error) {
   var err error
-  glog.Infof("Processing %v event.", event.Event_Type)
+  log := eventLogger(event)
+  log.Info("Processing event.")
+  proxy := f5Config.F5InstanceConfig.Proxy
   // Prepare F5 BIG IP REST API for removing members from the pool
   vmIPAddress := event.Data.Metadata.Status.Resources.NICList[0].IPEndPointList[0].IPAddress
-  vmCategoryPool := event.Data.Metadata.SubMetadata.Categories.NetworkFunctionProvider
+  vmCategoryPool := event.Data.Metadata.SubMetadata.Categories[lib.NetworkFunctionProviderCategory]
   requestURL := fmt.Sprintf("https://%s:%s/mgmt/tm/ltm/pool/%s/members/%s:%s",
     f5Config.F5InstanceConfig.IP,
     f5Config.F5InstanceConfig.Port,
     vmCategoryPool, vmIPAddress, f5Config.F5InstanceConfig.Serviceport)
 
   // Prepare Request to the F5 BIG IP virtual appliance
-  request := lib.PrepareRequest(requestURL,
+  request := lib.PrepareRequestWithProxy(requestURL,
                                   f5Config.F5InstanceConfig.Username,
-				  f5Config.F5InstanceConfig.Password, "DELETE")
-  glog.Info(requestURL)
+				  f5Config.F5InstanceConfig.Password, "DELETE", proxy)
+  request.Context = event.Context()
+  log.Debug("Deleting pool member.", logger.URLKey, requestURL)
   response, err := lib.DoRequest(request)
   if (err != nil || response.StatusCode != 200) {
-    glog.Error("Failed to delete member from pool.", err)
+    log.Error("Failed to delete member from pool.", logger.ErrorKey, err)
     return err
   }
 
   resp, _ := ioutil.ReadAll(response.Body)
-  glog.Info("Successfully deleted member from pool.", string(resp))
+  log.Info("Successfully deleted member from pool.",
+    "response", lib.RedactBody(string(resp)))
   return err
 }
 
+// This method will return the configuration of the consumer.
+//
+// Args:
+//    None.
+// Returns:
+//    f5Config  : F5 specific config.
+//    error : Error, if any.
+func (f5EventConsumer F5EventConsumer) config() (config.F5Config, error) {
+  if (f5EventConsumer.current != nil) {
+    return f5EventConsumer.current.Load().(config.F5Config), nil
+  }
+  return LoadF5Config()
+}
+
+// This method will create the consumer with the given configuration block,
+// or with the configuration of the configuration directory if the block is
+// empty. The configuration is loaded once & validated here, instead of on
+// every event.
+//
+// Args:
+//    data : Configuration block of the consumer, may be empty.
+// Returns:
+//    F5EventConsumer : Event consumer.
+//    error : Error if the configuration is invalid.
+func NewF5EventConsumer(data []byte) (F5EventConsumer, error) {
+  f5EventConsumer := F5EventConsumer{current: &atomic.Value{}}
+  err := f5EventConsumer.Reconfigure(data)
+  return f5EventConsumer, err
+}
+
+// This method will replace the configuration of the consumer, keeping the
+// current one if the new one is invalid.
+//
+// Args:
+//    data : Configuration block of the consumer, the configuration of the
+//           configuration directory is reloaded if empty.
+// Returns:
+//    error : Error if the configuration is invalid.
+func (f5EventConsumer F5EventConsumer) Reconfigure(data []byte) (error) {
+  if (f5EventConsumer.current == nil) {
+    return fmt.Errorf("consumer was not created by NewF5EventConsumer")
+  }
+  var f5Config config.F5Config
+  var err error
+  if (len(data) == 0) {
+    f5Config, err = LoadF5Config()
+  } else {
+    f5Config, err = ParseF5Config(data)
+  }
+  if (err != nil) {
+    return err
+  }
+  f5EventConsumer.current.Store(f5Config)
+  logger.Info("Loaded config.", logger.ConsumerKey, ConsumerName)
+  return nil
+}
+
+// This method will parse the configuration block of the consumer in the
+// listener configuration, e.g. {"f5_instance_config": {...}}, decoding the
+// base64 encoded password of the instance.
+//
+// Args:
+//    data : Configuration block.
+// Returns:
+//    f5Config  : F5 specific config.
+//    error : Error, if any.
+func ParseF5Config(data []byte) (config.F5Config, error) {
+  var f5Config config.F5Config
+  err := json.Unmarshal(data, &f5Config)
+  if (err != nil) {
+    return f5Config, err
+  }
+  decoded, err := base64.StdEncoding.DecodeString(
+    f5Config.F5InstanceConfig.Password)
+  if (err != nil) {
+    return f5Config, fmt.Errorf("invalid F5 instance password: %s", err)
+  }
+  f5Config.F5InstanceConfig.Password = string(decoded)
+  return f5Config, nil
+}
+
 // This method will load the F5 specific config.
 //
 // Args:
@@ -186,25 +319,25 @@ func onVmOff(event schema.Event, f5Config config.F5Config) (error) {
 //    error : Error, if any.
 func LoadF5Config() (config.F5Config, error) {
   var f5Config config.F5Config
-  glog.Info("Loading config..")
+  logger.Info("Loading config..", logger.ConsumerKey, ConsumerName)
   // F5 BIG IP Event consumer configuration file.
   f5ConfigPath := F5ConfigDir + "f5_config.json"
   // Read event consumer configuration file content
   f5ConfigFileContent, err := ioutil.ReadFile(f5ConfigPath)
   if (err != nil) {
-    glog.Error("Error reading config.", err)
+    logger.Error("Error reading config.", logger.ErrorKey, err)
     return f5Config, err
   }
   // Unmarshal event consumer file content
   err = json.Unmarshal([]byte(f5ConfigFileContent), &f5Config)
   if (err != nil) {
-    glog.Error("Failed to unmarshal config.",err)
+    logger.Error("Failed to unmarshal config.", logger.ErrorKey, err)
   }
   // Decode F5 instance base64 encoded password.
   // Note : Developers can exercise their own encryption mechanism for credentials.
   decoded, err := base64.StdEncoding.DecodeString(f5Config.F5InstanceConfig.Password)
   if err != nil {
-    glog.Error("Decode error:", err)
+    logger.Error("Decode error.", logger.ErrorKey, err)
   } else {
     f5Config.F5InstanceConfig.Password = string(decoded)
   }
@@ -212,12 +345,12 @@ func LoadF5Config() (config.F5Config, error) {
   // Note : Developers can exercise their own encryption mechanism for credentials.
   decoded, err = base64.StdEncoding.DecodeString(f5Config.NutanixClusterConfig.Password)
   if err != nil {
-    glog.Error("Decode error:", err)
+    logger.Error("Decode error.", logger.ErrorKey, err)
   } else {
     f5Config.NutanixClusterConfig.Password = string(decoded)
   }
   if err != nil {
-    glog.Error("Decode error:", err)
+    logger.Error("Decode error.", logger.ErrorKey, err)
   }
   return f5Config, err
 }
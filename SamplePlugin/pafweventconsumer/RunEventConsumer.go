@@ -4,17 +4,24 @@
 package main
 
 import (
-  "aplos/partners/WebhooksListener/lib"
-  "aplos/partners/WebhooksListener/webhook"
+  "aplos/partners/WebhooksListener/config"
+  "aplos/partners/WebhooksListener/logger"
+  "aplos/partners/WebhooksListener/logger/glogger"
+  "aplos/partners/WebhooksListener/tracing"
   consumer "aplos/partners/pafweventconsumer/impl"
-  "github.com/golang/glog"
+  "context"
   "flag"
   "fmt"
   "os"
 )
 
+// Listener configuration file.
+var configPath = flag.String("config",
+  consumer.PAFWConfigDir + "listener.yaml",
+  "Listener configuration file, YAML or JSON.")
+
 func usage() {
-  fmt.Fprintf(os.Stderr, "usage: example -stderrthreshold=[INFO|WARN|FATAL] -log_dir=[string]\n", )
+  fmt.Fprintf(os.Stderr, "usage: example -config=[string] -stderrthreshold=[INFO|WARN|FATAL] -log_dir=[string]\n", )
   flag.PrintDefaults()
   os.Exit(2)
 }
@@ -24,37 +31,49 @@ func init() {
   // NOTE: This next line is key you have to call flag.Parse() for the command line
   // options or "flags" that are defined in the glog module to be picked up.
   flag.Parse()
+  // Keep the glog file layout under -log_dir for the listener & consumer.
+  logger.SetLogger(glogger.New())
 }
 
 func main() {
-  var webhooksListener WebhooksListener.WebhooksListener
+  // Set up tracing as per the OTEL_* environment variables.
+  shutdownTracing, err := tracing.Init(tracing.ConfigFromEnv())
+  if (err != nil) {
+    logger.Error("Failed to set up tracing.", logger.ErrorKey, err)
+    return
+  }
+  defer shutdownTracing(context.Background())
 
-  // Define the networking events that will be subscribed by the event consumer.
-  events := []string{lib.VM_ON, lib.VM_OFF}
-  // Load the event consumer configuration file.
-  pafwConfig, err := consumer.LoadPAFWConfig()
+  // Load the listener configuration file.
+  listenerConfig, err := config.Load(*configPath)
   if (err != nil) {
-    glog.Errorf("Failed to load config. Cannot proceed. Error:- %v", err)
+    logger.Error("Failed to load config. Cannot proceed.", logger.ErrorKey, err)
     return
   }
-  // Initialize listener.
-  webhooksListener, err = webhooksListener.Initialize(
-    pafwConfig.NutanixClusterConfig.IP,
-    pafwConfig.NutanixClusterConfig.Port,
-    pafwConfig.NutanixClusterConfig.Username,
-    pafwConfig.NutanixClusterConfig.Password)
+  config.ConfigureLogging(listenerConfig.Logging)
+
+  // Configure the event consumer from its block of the configuration, if
+  // any, & subscribe to its events.
+  consumerConfig, _ := config.FindConsumer(listenerConfig,
+    consumer.ConsumerName)
+  eventConsumer, err := consumer.NewPAFWEventConsumer(consumerConfig.Config)
   if (err != nil) {
-    glog.Errorf("Failed to initialize listener.Erro: %v", err)
+    logger.Error("Invalid consumer config.", logger.ErrorKey, err)
     return
   }
-  webhooksListener.ListenerState = make(chan string)
-  // Event Consumer Register for Events.
-  webhooksListener.RegisterForEvents(events, consumer.PAFWEventConsumer{})
-  listenerStateMsg, listenerRunning := <-webhooksListener.ListenerState
-  for listenerRunning == true {
-    if(listenerStateMsg != "") {
-      glog.Info("Message from Listener: ", listenerStateMsg)
-    }
-    listenerStateMsg, listenerRunning = <-webhooksListener.ListenerState
+  events := config.ConsumerEvents(listenerConfig, consumer.ConsumerName)
+
+  // Initialize the listeners & register for events.
+  listeners, err := config.StartListeners(listenerConfig, events,
+    eventConsumer)
+  if (err != nil) {
+    logger.Error("Failed to start listeners.", logger.ErrorKey, err)
+    return
   }
+
+  // Reload the configuration on SIGHUP or when the file changes.
+  reloader := config.NewReloader(*configPath, listenerConfig,
+    consumer.ConsumerName, eventConsumer, listeners)
+  go reloader.Watch(nil)
+  config.Wait(listeners)
 }
@@ -27,6 +27,8 @@
 //
 package config
 
+import "aplos/partners/WebhooksListener/schemas"
+
 type PAFWConfig struct {
   PAFWInstanceConfig PAFWInstanceConfig `json:"pafw_instance_config"`
   NutanixClusterConfig NutanixClusterConfig `json:"nutanix_cluster_config"`
@@ -41,11 +43,14 @@ type PAFWInstanceConfig struct {
   SecurityPolicyRule string `json:"security_policy_rule"`
   DeviceGroup string `json:"device_group"`
   Category string `json:"category"`
+  Proxy schema.ProxyConfig `json:"proxy"`
 }
 
 type NutanixClusterConfig struct {
   IP string `json:"ip"`
+  Endpoints []string `json:"endpoints"` // CVM IPs, used when IP is down.
   Port string `json:"port"`
   Username string `json:"username"`
   Password string `json:"password"`
+  Proxy schema.ProxyConfig `json:"proxy"`
 }
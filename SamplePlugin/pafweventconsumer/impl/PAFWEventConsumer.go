@@ -15,30 +15,71 @@ package pafweventconsumer
 
 import (
   "aplos/partners/pafweventconsumer/config"
+  "aplos/partners/WebhooksListener/interfaces"
   "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/logger"
+  "aplos/partners/WebhooksListener/metrics"
+  "aplos/partners/WebhooksListener/registry"
   "aplos/partners/WebhooksListener/schemas"
+  "aplos/partners/WebhooksListener/tracing"
   "bytes"
-  "crypto/tls"
+  "context"
   "encoding/json"
   "encoding/base64"
   "errors"
   "fmt"
-  "github.com/golang/glog"
+  "github.com/prometheus/client_golang/prometheus"
   "io/ioutil"
   "net/http"
   "regexp"
   "strings"
+  "sync/atomic"
+  "time"
 )
 
 type PAFWEventConsumer struct {
   // Type that implements the EventConsumer interface.
+
+  // Current configuration, shared by the copies of the consumer. The
+  // configuration is loaded from the configuration directory for every
+  // event if nil, i.e. if the consumer was not created by
+  // NewPAFWEventConsumer.
+  current *atomic.Value
 }
 
 const (
   // PaloAlto Config Directory Path
   PAFWConfigDir = "/opt/pafw/config/"
+
+  // Name identifying the consumer in logs & metrics.
+  ConsumerName = "pafw"
 )
 
+// Firewall commits made by the consumer, exposed through the listener.
+var commitsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+  Namespace: metrics.Namespace,
+  Name: "pafw_commits_total",
+  Help: "PAN-OS configuration commits, by result.",
+}, []string{"result"})
+
+func init() {
+  metrics.Register(commitsTotal)
+  registry.Register(ConsumerName,
+    func(config []byte) (interfaces.EventConsumer, error) {
+      return NewPAFWEventConsumer(config)
+    })
+}
+
+// This method will return the name of the consumer.
+//
+// Args:
+//    None.
+// Returns:
+//    string : Consumer name.
+func (pafwEventConsumer PAFWEventConsumer) Name() (string) {
+  return ConsumerName
+}
+
 // This method will act as a callback method that will configure the networking
 // appliance based on the networking events received through the listener.
 //
@@ -48,62 +89,96 @@ const (
 //    error : Error, if any.
 func (pafwEventConsumer PAFWEventConsumer) OnEvent(event schema.Event) (error) {
   var err error
-  glog.Info("Received event of type " + event.Event_Type)
+  log := eventLogger(event)
+  log.Info("Received event.")
   // Load Palo Alto Firewall Event consumer configuration file
-  pafwConfig, err := LoadPAFWConfig()
+  pafwConfig, err := pafwEventConsumer.config()
   if (err != nil) {
-    glog.Error("Failed to load config. Cannot proceed.", err)
+    log.Error("Failed to load config. Cannot proceed.", logger.ErrorKey, err)
     return err
   }
 
   switch eventType := event.Event_Type; eventType {
   // If event type is VM.ON
   case lib.VM_ON: {
-    err := onVmOn(event, pafwConfig)
+    err = onVmOn(event, pafwConfig)
     if (err != nil) {
-      glog.Error("Failed to process event. Error: ", err)
+      log.Error("Failed to process event.", logger.ErrorKey, err)
     }
   }
   // If event type is VM.OFF
   case lib.VM_OFF: {
-    err := onVmOff(event, pafwConfig)
+    err = onVmOff(event, pafwConfig)
     if (err != nil) {
-      glog.Error("Failed to process event. Error: ", err)
+      log.Error("Failed to process event.", logger.ErrorKey, err)
     }
   }
   }
   return err
 }
 
+// This method will return a logger with the fields of the given event.
+//
+// Args:
+//    event : Event object containing the event data sent by the listener.
+// Returns:
+//    Logger : Logger for the event.
+func eventLogger(event schema.Event) (logger.Logger) {
+  return logger.With(logger.ConsumerKey, ConsumerName,
+    logger.EventTypeKey, event.Event_Type,
+    logger.VMUUIDKey, event.EntityReference.UUID)
+}
+
 // This is a generic method to perform HTTP requests.
 //
 // Args:
+//    ctx : Context of the event handling, carrying its trace.
 //    url : Website url to which request will be made.
 //    httpClient : Predefined interface of HTTP client.
 // Returns:
 //    Response : HTTP response for the request.
 //    error : Error, if any.
 
-func doHttpRequest(url string, httpClient  http.Client) (*http.Response, error) {
+func doHttpRequest(ctx context.Context, url string,
+                   httpClient http.Client) (*http.Response, error) {
   var resp *http.Response
   url = strings.Replace(url, " ", "%20", -1)
-  request, err := http.NewRequest("GET", url, nil)
+  request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
   if (err != nil) {
-    glog.Error("failed to create request. ", err)
+    err = lib.RedactError(err)
+    logger.Error("failed to create request.", logger.ErrorKey, err)
     return resp, err
   }
+  request, span := tracing.StartRequestSpan(request, lib.RedactURL(url))
+  start := time.Now()
   resp, err = httpClient.Do(request)
-  if (err != nil || resp.StatusCode != 200) {
-    glog.Error("Request failed.", err)
-    glog.Error("HTTP status code :", resp.StatusCode)
+  statusCode := 0
+  if (resp != nil) {
+    statusCode = resp.StatusCode
+  }
+  metrics.ObserveOutboundRequest(request.URL.Host, request.Method, statusCode,
+                                 start)
+  if (err != nil) {
+    err = lib.RedactError(err)
+  }
+  tracing.EndRequestSpan(span, statusCode, err)
+  if (err != nil) {
+    logger.Error("Request failed.", logger.ErrorKey, err)
+    return resp, err
+  }
+  if (resp.StatusCode != 200) {
+    logger.Error("Request failed.", logger.URLKey, lib.RedactURL(url),
+      logger.StatusCodeKey, resp.StatusCode)
     return resp, err
   }
   respData, _ := ioutil.ReadAll(resp.Body)
   resp.Body = ioutil.NopCloser(bytes.NewBuffer(respData))
   matched, _ := regexp.MatchString("<response status.*success.*?>", string(respData))
   if matched == false {
-    glog.Errorf("Url failed.\nURL: %s\nResponse: %s", url, string(respData))
-    return resp, errors.New(string(respData))
+    redactedResp := lib.RedactBody(string(respData))
+    logger.Error("Url failed.", logger.URLKey, lib.RedactURL(url),
+      "response", redactedResp)
+    return resp, errors.New(redactedResp)
   }
   return resp, err
 }
@@ -120,22 +195,23 @@ func onVmOn(event schema.Event, pafwConfig config.PAFWConfig) (error) {
 
   var err error
   var url, urlStr string
-  glog.Infof("Processing %v event.", event.Event_Type)
+  log := eventLogger(event)
+  log.Info("Processing event.")
 
   // Setting Http Client
-  tr := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true},}
+  tr := lib.NewTransport(pafwConfig.PAFWInstanceConfig.Proxy)
   httpClient := http.Client{}
   httpClient.Transport = tr
 
   // Login to Palo Alto Firewall VM.
-  glog.Info("Login to Firewall VM and generate session key")
+  log.Info("Login to Firewall VM and generate session key")
   url = fmt.Sprintf("https://%s/api/?type=keygen&user=%s&password=%s",
                     pafwConfig.PAFWInstanceConfig.IP,
                     pafwConfig.PAFWInstanceConfig.Username,
                     pafwConfig.PAFWInstanceConfig.Password)
-  resp, err := doHttpRequest(url, httpClient)
+  resp, err := doHttpRequest(event.Context(), url, httpClient)
   if err != nil {
-    glog.Errorf("Login to Firewall VM Failed.")
+    log.Error("Login to Firewall VM Failed.", logger.ErrorKey, err)
     return err
   }
 
@@ -148,22 +224,22 @@ func onVmOn(event schema.Event, pafwConfig config.PAFWConfig) (error) {
   urlXPath:= "/config/devices/entry[@name='localhost.localdomain']"
   urlXPath = urlXPath + "/vsys/entry[@name='vsys1']"
   baseUrl := fmt.Sprintf("https://%s/api/?key=%s", pafwConfig.PAFWInstanceConfig.IP, key)
-  category := event.Data.Metadata.SubMetadata.Categories.NetworkFunctionProvider
+  category := event.Data.Metadata.SubMetadata.Categories[lib.NetworkFunctionProviderCategory]
   // Temporary Code //
   if(category == "") {
     category = pafwConfig.PAFWInstanceConfig.Category
   }
   // Temporary Code //
-  glog.Infof("Processing VM of Category: %s", category)
+  log.Info("Processing VM of Category.", "category", category)
 
   // Tag (Category) creation process.
 
   tagName := strings.Replace(category, " ", "-", -1)
-  glog.Infof("Creating tag by name: %s", tagName)
+  log.Info("Creating tag.", "tag", tagName)
   url = fmt.Sprintf("%s&type=config&action=get&xpath=%s/tag", baseUrl, urlXPath)
-  resp, err = doHttpRequest(url, httpClient)
+  resp, err = doHttpRequest(event.Context(), url, httpClient)
   if err != nil {
-    glog.Errorf("Check of tag existense failed. Error: ", err)
+    log.Error("Check of tag existense failed.", logger.ErrorKey, err)
     return err
   }
   respData, _ = ioutil.ReadAll(resp.Body)
@@ -174,66 +250,69 @@ func onVmOn(event schema.Event, pafwConfig config.PAFWConfig) (error) {
     urlStr = "%s&type=config&action=set&xpath=%s"
     urlStr = urlStr + "/tag/entry[@name='%s']&element=<color>color2</color>"
     url = fmt.Sprintf(urlStr, baseUrl, urlXPath, tagName)
-    resp, err = doHttpRequest(url, httpClient)
+    resp, err = doHttpRequest(event.Context(), url, httpClient)
   } else { // Tag already exists.
-    glog.Warningf("Tag '%s' already exists.", tagName)
+    log.Warn("Tag already exists.", "tag", tagName)
   }
 
   // Create Address entity by VM Name
   address := event.Data.Metadata.Status.Name
   vmIPAddress := event.Data.Metadata.Status.Resources.NICList[0].IPEndPointList[0].IPAddress
-  glog.Infof("Creating VM Address by name '%s'/IP Address: '%s'", address,
-                                                           vmIPAddress)
+  log.Info("Creating VM Address.", "address", address, "ip", vmIPAddress)
   urlStr = "%s&type=config&action=set&xpath=%s/address/entry[@name='%s']"
   urlStr = urlStr + "&element=<ip-netmask>%s</ip-netmask><tag>"
   urlStr = urlStr + "<member>%s</member></tag><description>%s</description>"
   url = fmt.Sprintf(urlStr, baseUrl, urlXPath, address, vmIPAddress,
                     tagName, "Apache Web Server")
-  resp, err = doHttpRequest(url, httpClient)
+  resp, err = doHttpRequest(event.Context(), url, httpClient)
   if err != nil {
-    glog.Errorf("Creation of VM Address entity failed.")
+    log.Error("Creation of VM Address entity failed.")
     return err
   }
 
   // Dynamic Address Creation process.
   addressGroup := pafwConfig.PAFWInstanceConfig.AddressGroup
-  glog.Infof("Creating Dynamic Address Group by name '%s'.", addressGroup)
+  log.Info("Creating Dynamic Address Group.", "address_group", addressGroup)
   url = fmt.Sprintf("%s&type=config&action=get&xpath=%s", baseUrl, urlXPath)
   url = fmt.Sprintf("%s/address-group/entry[@name='%s']", url, addressGroup)
-  resp, err = doHttpRequest(url, httpClient)
+  resp, err = doHttpRequest(event.Context(), url, httpClient)
   respData, _ = ioutil.ReadAll(resp.Body)
-  glog.Infof("Checking if Address Group '%s' already exists.", addressGroup)
+  log.Info("Checking if Address Group already exists.",
+    "address_group", addressGroup)
   addAddressGroup, tags := checkNGetAddressGroupTagExistence(string(respData),
                                                         addressGroup, tagName)
   if addAddressGroup == true { // Either AddressGroup or Tag is missing. Need to create either one.
     urlStr = "%s&type=config&action=set&xpath=%s/address-group/entry[@name='%s']"
     urlStr = urlStr + "&element=<dynamic><filter>%s</filter></dynamic>"
     url = fmt.Sprintf(urlStr, baseUrl, urlXPath, addressGroup, tags)
-    resp, err = doHttpRequest(url, httpClient)
+    resp, err = doHttpRequest(event.Context(), url, httpClient)
     if(err != nil) {
-      glog.Errorf("Creation of Dynamic Address group '%s' failed.", addressGroup)
+      log.Error("Creation of Dynamic Address group failed.",
+        "address_group", addressGroup)
       return err
     }
   } else { // AddressGroup alredy exist with required tag.
-    glog.Warningf("Dynamic Address Group '%s' already exists.", addressGroup)
+    log.Warn("Dynamic Address Group already exists.",
+      "address_group", addressGroup)
   }
 
   // Create Security Policy Rule
   policyRule := pafwConfig.PAFWInstanceConfig.SecurityPolicyRule
-  glog.Infof("Creating security policy rule by name: %s", policyRule)
+  log.Info("Creating security policy rule.", "rule", policyRule)
   urlStr = "%s&type=config&action=get&xpath=%s"
   urlStr = urlStr + "/rulebase/security/rules/entry[@name='%s']"
   url = fmt.Sprintf(urlStr, baseUrl, urlXPath, policyRule)
-  glog.Infof("Checking if Security Policy Rule '%s' already exists.",policyRule)
-  resp, err = doHttpRequest(url, httpClient)
+  log.Info("Checking if Security Policy Rule already exists.",
+    "rule", policyRule)
+  resp, err = doHttpRequest(event.Context(), url, httpClient)
   if(err != nil) {
-      glog.Warningf("Check of existing security policy rule failed.")
+      log.Warn("Check of existing security policy rule failed.")
   }
   respData, _ = ioutil.ReadAll(resp.Body)
   pattern = fmt.Sprintf("<entry name=\"%s\" ", policyRule)
   matched, _ = regexp.MatchString(pattern, string(respData))
   if matched == false { // Security Policy Rule does not exist. Create new rule.
-    glog.Infof("Creating Security Policy Rule '%s'.", policyRule)
+    log.Info("Creating Security Policy Rule.", "rule", policyRule)
     urlStr = "%s&type=config&action=set&xpath=%s"
     urlStr = urlStr + "/rulebase/security/rules/entry[@name='%s']"
     urlStr = urlStr + "&element=<to><member>untrust</member></to>"
@@ -246,24 +325,26 @@ func onVmOn(event schema.Event, pafwConfig config.PAFWConfig) (error) {
     urlStr = urlStr + "<hip-profiles><member>any</member></hip-profiles>"
     urlStr = urlStr + "<action>allow</action>"
     url = fmt.Sprintf(urlStr, baseUrl, urlXPath, policyRule, addressGroup)
-    resp, err = doHttpRequest(url, httpClient)
+    resp, err = doHttpRequest(event.Context(), url, httpClient)
     if(err != nil) {
-      glog.Errorf("Creation of Security Policy Rule failed.")
+      log.Error("Creation of Security Policy Rule failed.")
       return err
     }
   } else {
-    glog.Warningf("Security Policy Rule '%s' already exists.", policyRule)
+    log.Warn("Security Policy Rule already exists.", "rule", policyRule)
   }
 
   // Commit Changes
-  glog.Info("Commit changes.")
+  log.Info("Commit changes.")
   url = fmt.Sprintf("%s&type=commit&cmd=<commit><force></force></commit>",
                      baseUrl)
-  resp, err = doHttpRequest(url, httpClient)
+  resp, err = doHttpRequest(event.Context(), url, httpClient)
   if(err != nil) {
-    glog.Errorf("Failed to commit changes.")
+    commitsTotal.WithLabelValues("failure").Inc()
+    log.Error("Failed to commit changes.", logger.ErrorKey, err)
     return err
   }
+  commitsTotal.WithLabelValues("success").Inc()
 
   return err
 }
@@ -310,23 +391,24 @@ func checkNGetAddressGroupTagExistence(data string, addressGroup string, tags st
 func onVmOff(event schema.Event, pafwConfig config.PAFWConfig) (error) {
   var err error
   var url string
-  glog.Infof("Processing %v event.", event.Event_Type)
+  log := eventLogger(event)
+  log.Info("Processing event.")
   // Remove VM Address from Security Policy Rule.
 
   // Setting Http Client
-  tr := &http.Transport{ TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, }
+  tr := lib.NewTransport(pafwConfig.PAFWInstanceConfig.Proxy)
   httpClient := http.Client{}
   httpClient.Transport = tr
 
   // Login to Palo Alto Firewall VM.
-  glog.Info("Login to Firewall VM and generating key")
+  log.Info("Login to Firewall VM and generating key")
   url = fmt.Sprintf("https://%s/api/?type=keygen&user=%s&password=%s",
                     pafwConfig.PAFWInstanceConfig.IP,
                     pafwConfig.PAFWInstanceConfig.Username,
                     pafwConfig.PAFWInstanceConfig.Password)
-  resp, err := doHttpRequest(url, httpClient)
+  resp, err := doHttpRequest(event.Context(), url, httpClient)
   if err != nil {
-    glog.Errorf("Login to Firewall VM Failed.")
+    log.Error("Login to Firewall VM Failed.", logger.ErrorKey, err)
     return err
   }
   respData, _ := ioutil.ReadAll(resp.Body)
@@ -339,30 +421,113 @@ func onVmOff(event schema.Event, pafwConfig config.PAFWConfig) (error) {
 
   // Delete Address by VM Name & IP Address
   address := event.Data.Metadata.Status.Name
-  glog.Infof("Deleting VM '%s'", address)
+  log.Info("Deleting VM.", "address", address)
   url = fmt.Sprintf("https://%s/api/?type=config&action=delete", pafwConfig.PAFWInstanceConfig.IP)
   url = fmt.Sprintf("%s&key=%s&xpath=%s/address/entry[@name='%s']", url, key,
                                                             urlXPath, address)
-  resp, err = doHttpRequest(url, httpClient)
+  resp, err = doHttpRequest(event.Context(), url, httpClient)
   if err != nil {
-    glog.Errorf("Delete Address failed.")
+    log.Error("Delete Address failed.")
     return err
   }
   respData, _ = ioutil.ReadAll(resp.Body)
 
   // Commit Changes
-  glog.Info("Commit changes.")
+  log.Info("Commit changes.")
   url = fmt.Sprintf("https://%s/api/?type=commit&cmd=<commit>", pafwConfig.PAFWInstanceConfig.IP)
   url = fmt.Sprintf("%s<force></force></commit>&key=%s", url, key)
-  resp, err = doHttpRequest(url, httpClient)
+  resp, err = doHttpRequest(event.Context(), url, httpClient)
   if(err != nil) {
-    glog.Errorf("Failed to commit changes.")
+    commitsTotal.WithLabelValues("failure").Inc()
+    log.Error("Failed to commit changes.", logger.ErrorKey, err)
     return err
   }
+  commitsTotal.WithLabelValues("success").Inc()
 
   return err
 }
 
+// This method will return the configuration of the consumer.
+//
+// Args:
+//    None.
+// Returns:
+//    pafwConfig  : PAFW specific config.
+//    error : Error, if any.
+func (pafwEventConsumer PAFWEventConsumer) config() (config.PAFWConfig, error) {
+  if (pafwEventConsumer.current != nil) {
+    return pafwEventConsumer.current.Load().(config.PAFWConfig), nil
+  }
+  return LoadPAFWConfig()
+}
+
+// This method will create the consumer with the given configuration block,
+// or with the configuration of the configuration directory if the block is
+// empty. The configuration is loaded once & validated here, instead of on
+// every event.
+//
+// Args:
+//    data : Configuration block of the consumer, may be empty.
+// Returns:
+//    PAFWEventConsumer : Event consumer.
+//    error : Error if the configuration is invalid.
+func NewPAFWEventConsumer(data []byte) (PAFWEventConsumer, error) {
+  pafwEventConsumer := PAFWEventConsumer{current: &atomic.Value{}}
+  err := pafwEventConsumer.Reconfigure(data)
+  return pafwEventConsumer, err
+}
+
+// This method will replace the configuration of the consumer, keeping the
+// current one if the new one is invalid.
+//
+// Args:
+//    data : Configuration block of the consumer, the configuration of the
+//           configuration directory is reloaded if empty.
+// Returns:
+//    error : Error if the configuration is invalid.
+func (pafwEventConsumer PAFWEventConsumer) Reconfigure(data []byte) (error) {
+  if (pafwEventConsumer.current == nil) {
+    return fmt.Errorf("consumer was not created by NewPAFWEventConsumer")
+  }
+  var pafwConfig config.PAFWConfig
+  var err error
+  if (len(data) == 0) {
+    pafwConfig, err = LoadPAFWConfig()
+  } else {
+    pafwConfig, err = ParsePAFWConfig(data)
+  }
+  if (err != nil) {
+    return err
+  }
+  pafwEventConsumer.current.Store(pafwConfig)
+  logger.Info("Loaded config.", logger.ConsumerKey, ConsumerName)
+  return nil
+}
+
+// This method will parse the configuration block of the consumer in the
+// listener configuration, e.g. {"pafw_instance_config": {...}}, decoding the
+// base64 encoded password of the instance.
+//
+// Args:
+//    data : Configuration block.
+// Returns:
+//    pafwConfig  : PAFW specific config.
+//    error : Error, if any.
+func ParsePAFWConfig(data []byte) (config.PAFWConfig, error) {
+  var pafwConfig config.PAFWConfig
+  err := json.Unmarshal(data, &pafwConfig)
+  if (err != nil) {
+    return pafwConfig, err
+  }
+  decoded, err := base64.StdEncoding.DecodeString(
+    pafwConfig.PAFWInstanceConfig.Password)
+  if (err != nil) {
+    return pafwConfig, fmt.Errorf("invalid PAFW instance password: %s", err)
+  }
+  pafwConfig.PAFWInstanceConfig.Password = string(decoded)
+  return pafwConfig, nil
+}
+
 // This method will load the PAFW specific config.
 //
 // Args:
@@ -372,25 +537,25 @@ func onVmOff(event schema.Event, pafwConfig config.PAFWConfig) (error) {
 //    error : Error, if any.
 func LoadPAFWConfig() (config.PAFWConfig, error) {
   var pafwConfig config.PAFWConfig
-  glog.Info("Loading config..")
+  logger.Info("Loading config..", logger.ConsumerKey, ConsumerName)
   // PAFW Event consumer configuration file.
   pafwConfigPath := PAFWConfigDir + "pafw_config.json"
   // Read event consumer configuration file content
   pafwConfigFileContent, err := ioutil.ReadFile(pafwConfigPath)
   if (err != nil) {
-    glog.Error("Error reading config.", err)
+    logger.Error("Error reading config.", logger.ErrorKey, err)
     return pafwConfig, err
   }
   // Unmarshal event consumer file content
   err = json.Unmarshal([]byte(pafwConfigFileContent), &pafwConfig)
   if (err != nil) {
-    glog.Error("Failed to unmarshal config.",err)
+    logger.Error("Failed to unmarshal config.", logger.ErrorKey, err)
   }
   // Decode PAFW instance Base64 encoded password.
   // Note : Developers can exercise their own encryption mechanism for credentials.
   decoded, err := base64.StdEncoding.DecodeString(pafwConfig.PAFWInstanceConfig.Password)
   if err != nil {
-    glog.Error("Decode error:", err)
+    logger.Error("Decode error.", logger.ErrorKey, err)
   } else {
     pafwConfig.PAFWInstanceConfig.Password = string(decoded)
   }
@@ -398,12 +563,12 @@ func LoadPAFWConfig() (config.PAFWConfig, error) {
   // Note : Developers can exercise their own encryption mechanism for credentials.
   decoded, err = base64.StdEncoding.DecodeString(pafwConfig.NutanixClusterConfig.Password)
   if err != nil {
-    glog.Error("Decode error:", err)
+    logger.Error("Decode error.", logger.ErrorKey, err)
   } else {
     pafwConfig.NutanixClusterConfig.Password = string(decoded)
   }
   if err != nil {
-    glog.Error("Decode error:", err)
+    logger.Error("Decode error.", logger.ErrorKey, err)
   }
   return pafwConfig, err
 }
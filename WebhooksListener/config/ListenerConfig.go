@@ -0,0 +1,341 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+
+// Listener configuration file. A single YAML or JSON file declares the
+// clusters to listen to, the callback server (address, TLS &
+// authorization), the event subscriptions, the event consumers with their
+// own configuration blocks, the event dispatch & the logging.
+//
+// The file is validated against the published schema, then checked for the
+// constraints the schema cannot express, e.g. unknown event types or
+// clusters sharing a callback port. All the errors are reported at once,
+// with their line numbers.
+
+package config
+
+import (
+  "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/logger"
+  "aplos/partners/WebhooksListener/logger/glogger"
+  "aplos/partners/WebhooksListener/schemas"
+  "aplos/partners/WebhooksListener/webhook"
+  "encoding/json"
+  "fmt"
+  "gopkg.in/yaml.v3"
+  "io/ioutil"
+  "log/slog"
+  "os"
+  "strings"
+  "time"
+)
+
+// This method will load & validate the listener configuration file.
+//
+// Args:
+//    path : Path of the YAML or JSON configuration file.
+// Returns:
+//    ListenerConfig : Listener configuration.
+//    error : ValidationErrors if the file is invalid, or the read error.
+func Load(path string) (*schema.ListenerConfig, error) {
+  data, err := ioutil.ReadFile(path)
+  if (err != nil) {
+    return nil, err
+  }
+  return Parse(data, path)
+}
+
+// This method will parse & validate a listener configuration.
+//
+// Args:
+//    data : YAML or JSON configuration.
+//    file : Name of the configuration file, for the errors.
+// Returns:
+//    ListenerConfig : Listener configuration.
+//    error : ValidationErrors if the configuration is invalid.
+func Parse(data []byte, file string) (*schema.ListenerConfig, error) {
+  var document yaml.Node
+  err := yaml.Unmarshal(data, &document)
+  if (err != nil) {
+    return nil, fmt.Errorf("%s: %s", file, err)
+  }
+  if (len(document.Content) == 0) {
+    return nil, ValidationErrors{&ValidationError{File: file, Line: 1,
+      Column: 1, Message: "empty configuration"}}
+  }
+
+  validator := &validator{file: file, root: listenerSchema,
+                          lines: map[string]*yaml.Node{}}
+  value := validator.validate(&document, listenerSchema, "")
+  if (len(validator.errors) > 0) {
+    return nil, validator.errors
+  }
+  encoded, err := json.Marshal(value)
+  if (err != nil) {
+    return nil, err
+  }
+  var listenerConfig schema.ListenerConfig
+  err = json.Unmarshal(encoded, &listenerConfig)
+  if (err != nil) {
+    return nil, fmt.Errorf("%s: %s", file, err)
+  }
+  validator.check(&listenerConfig)
+  if (len(validator.errors) > 0) {
+    return nil, validator.errors
+  }
+  return &listenerConfig, nil
+}
+
+// This method will check the constraints the schema cannot express.
+//
+// Args:
+//    listenerConfig : Decoded listener configuration.
+// Returns:
+//    None.
+func (validator *validator) check(listenerConfig *schema.ListenerConfig) {
+  failAt := func(path string, format string, args ...interface{}) {
+    node, ok := validator.lines[path]
+    if (!ok) {
+      node = validator.lines[""]
+    }
+    validator.fail(node, path, format, args...)
+  }
+
+  for i, eventType := range listenerConfig.Events {
+    if (!lib.IsKnownEventType(eventType)) {
+      failAt(fmt.Sprintf("events[%d]", i), "unknown event type %q",
+             eventType)
+    }
+  }
+  for i, consumerConfig := range listenerConfig.Consumers {
+    for j, eventType := range consumerConfig.Events {
+      if (!contains(listenerConfig.Events, eventType)) {
+        failAt(fmt.Sprintf("consumers[%d].events[%d]", i, j),
+               "event type %q is not in events", eventType)
+      }
+    }
+  }
+
+  // Each cluster has its own callback server, on its own port.
+  ports := map[string]int{}
+  for i, cluster := range listenerConfig.Clusters {
+    path := fmt.Sprintf("clusters[%d]", i)
+    passwords := 0
+    for _, password := range []string{cluster.Password, cluster.PasswordEnv,
+                                      cluster.PasswordFile} {
+      if (password != "") {
+        passwords++
+      }
+    }
+    if (passwords != 1) {
+      failAt(path, "exactly one of password, password_env & password_file " +
+             "is required")
+    }
+    if (listenerConfig.Listener.UnixSocket != "") {
+      continue
+    }
+    port := listenerPort(listenerConfig, cluster)
+    if first, ok := ports[port]; ok {
+      portPath := path + ".listener_port"
+      if _, ok := validator.lines[portPath]; !ok {
+        portPath = path
+      }
+      failAt(portPath, "listener port %s is already used by clusters[%d], " +
+             "set listener_port", port, first)
+    } else {
+      ports[port] = i
+    }
+  }
+  if (listenerConfig.Listener.UnixSocket != "" &&
+      len(listenerConfig.Clusters) > 1) {
+    failAt("listener.unix_socket", "a unix socket serves a single cluster")
+  }
+  if (listenerConfig.Listener.UnixSocket != "" &&
+      listenerConfig.Listener.AdvertiseURL == "" &&
+      (len(listenerConfig.Clusters) != 1 ||
+       listenerConfig.Clusters[0].AdvertiseURL == "")) {
+    failAt("listener.unix_socket", "a unix socket requires advertise_url, " +
+           "Prism cannot reach it directly")
+  }
+  auth := listenerConfig.Listener.Auth
+  if ((auth.Username == "") != (auth.Password == "")) {
+    failAt("listener.auth", "username & password go together")
+  }
+}
+
+// This method will return the callback port of a cluster.
+//
+// Args:
+//    listenerConfig : Listener configuration.
+//    cluster : Cluster configuration.
+// Returns:
+//    string : Callback port.
+func listenerPort(listenerConfig *schema.ListenerConfig,
+  cluster schema.NutanixClusterConfig) (string) {
+  if (cluster.ListenerPort != "") {
+    return cluster.ListenerPort
+  }
+  if (listenerConfig.Listener.Port != "") {
+    return listenerConfig.Listener.Port
+  }
+  return lib.DefaultListenerPort
+}
+
+// This method will return the password of a cluster, from the file, an
+// environment variable or a password file.
+//
+// Args:
+//    cluster : Cluster configuration.
+// Returns:
+//    string : Password.
+//    error : Error if the password cannot be read.
+func ClusterPassword(cluster schema.NutanixClusterConfig) (string, error) {
+  switch {
+  case cluster.PasswordEnv != "":
+    password, ok := os.LookupEnv(cluster.PasswordEnv)
+    if (!ok) {
+      return "", fmt.Errorf("environment variable %s is not set",
+                            cluster.PasswordEnv)
+    }
+    return password, nil
+  case cluster.PasswordFile != "":
+    content, err := ioutil.ReadFile(cluster.PasswordFile)
+    if (err != nil) {
+      return "", err
+    }
+    return strings.TrimRight(string(content), "\r\n"), nil
+  }
+  return cluster.Password, nil
+}
+
+// This method will return the listener of a cluster, configured but not
+// initialized.
+//
+// Args:
+//    listenerConfig : Listener configuration.
+//    cluster : Cluster configuration.
+// Returns:
+//    WebhooksListener : Listener of the cluster.
+func NewListener(listenerConfig *schema.ListenerConfig,
+  cluster schema.NutanixClusterConfig) (WebhooksListener.WebhooksListener) {
+  server := listenerConfig.Listener
+  dispatch := listenerConfig.Dispatch
+  var webhooksListener WebhooksListener.WebhooksListener
+  webhooksListener.ListenerPort = listenerPort(listenerConfig, cluster)
+  webhooksListener.BindAddress = server.BindAddress
+  webhooksListener.UnixSocket = server.UnixSocket
+  webhooksListener.CallbackPath = server.CallbackPath
+  webhooksListener.AdvertiseURL = server.AdvertiseURL
+  if (cluster.AdvertiseURL != "") {
+    webhooksListener.AdvertiseURL = cluster.AdvertiseURL
+  }
+  webhooksListener.ListenerID = server.ListenerID
+  webhooksListener.ListenerIDFile = server.ListenerIDFile
+  webhooksListener.TLSCertFile = server.TLS.CertFile
+  webhooksListener.TLSKeyFile = server.TLS.KeyFile
+  webhooksListener.CallbackCredentials = schema.Credentials{
+    Username: server.Auth.Username,
+    Password: server.Auth.Password,
+  }
+  webhooksListener.AllowedSources = server.Auth.AllowedSources
+  webhooksListener.ServerLimits = schema.ServerLimits{
+    ReadHeaderTimeout: time.Duration(server.Limits.ReadHeaderTimeout),
+    ReadTimeout: time.Duration(server.Limits.ReadTimeout),
+    WriteTimeout: time.Duration(server.Limits.WriteTimeout),
+    IdleTimeout: time.Duration(server.Limits.IdleTimeout),
+    MaxHeaderBytes: server.Limits.MaxHeaderBytes,
+    MaxConnections: server.Limits.MaxConnections,
+  }
+  webhooksListener.SkipReachabilityCheck = server.SkipReachabilityCheck
+  webhooksListener.ProbeHelperURL = server.ProbeHelperURL
+  webhooksListener.WatchdogInterval = time.Duration(server.WatchdogInterval)
+  webhooksListener.SubscriptionMode = listenerConfig.SubscriptionMode
+  webhooksListener.ClusterProxy = cluster.Proxy
+  webhooksListener.ClusterEndpoints = cluster.Endpoints
+  webhooksListener.QueueSize = dispatch.QueueSize
+  webhooksListener.MaxEventSize = dispatch.MaxEventSize
+  webhooksListener.RetryPolicy = RetryPolicy(dispatch)
+  return webhooksListener
+}
+
+// This method will return the retry policy of the dispatch configuration.
+//
+// Args:
+//    dispatch : Dispatch configuration.
+// Returns:
+//    RetryPolicy : Retry policy, the defaults apply to the zero values.
+func RetryPolicy(dispatch schema.DispatchConfig) (schema.RetryPolicy) {
+  return schema.RetryPolicy{
+    MaxAttempts: dispatch.MaxAttempts,
+    InitialBackoff: time.Duration(dispatch.InitialBackoff),
+    MaxBackoff: time.Duration(dispatch.MaxBackoff),
+  }
+}
+
+// This method will return the configuration block of the first consumer of
+// the given type.
+//
+// Args:
+//    listenerConfig : Listener configuration.
+//    consumerType : Type of the consumer, e.g. "pafw".
+// Returns:
+//    ConsumerConfig : Consumer configuration.
+//    bool : False if no consumer of the type is configured.
+func FindConsumer(listenerConfig *schema.ListenerConfig,
+  consumerType string) (schema.ConsumerConfig, bool) {
+  for _, consumerConfig := range listenerConfig.Consumers {
+    if (consumerConfig.Type == consumerType) {
+      return consumerConfig, true
+    }
+  }
+  return schema.ConsumerConfig{}, false
+}
+
+// This method will return the events to subscribe to for a consumer: the
+// events of its configuration block, or all the events.
+//
+// Args:
+//    listenerConfig : Listener configuration.
+//    consumerType : Type of the consumer, e.g. "pafw".
+// Returns:
+//    []string : Events of the consumer.
+func ConsumerEvents(listenerConfig *schema.ListenerConfig,
+  consumerType string) ([]string) {
+  consumerConfig, ok := FindConsumer(listenerConfig, consumerType)
+  if (ok && len(consumerConfig.Events) > 0) {
+    return consumerConfig.Events
+  }
+  return listenerConfig.Events
+}
+
+// This method will set up the process wide logger as configured, & mask
+// the configured secrets in the logs. The glog format keeps the glog file
+// layout under -log_dir. Secrets removed from the configuration stay masked
+// until restart.
+//
+// Args:
+//    loggingConfig : Logging configuration.
+// Returns:
+//    None.
+func ConfigureLogging(loggingConfig schema.LoggingConfig) {
+  lib.AddRedactedFields(loggingConfig.RedactFields...)
+  lib.AddRedactedParams(loggingConfig.RedactParams...)
+  lib.AddRedactedHeaders(loggingConfig.RedactHeaders...)
+  if (loggingConfig.Format == "glog") {
+    logger.SetLogger(glogger.New())
+    return
+  }
+  var level slog.Level
+  switch loggingConfig.Level {
+  case "debug":
+    level = slog.LevelDebug
+  case "warn":
+    level = slog.LevelWarn
+  case "error":
+    level = slog.LevelError
+  }
+  options := &slog.HandlerOptions{Level: level}
+  var handler slog.Handler = slog.NewTextHandler(os.Stderr, options)
+  if (loggingConfig.Format == "json") {
+    handler = slog.NewJSONHandler(os.Stderr, options)
+  }
+  logger.SetLogger(logger.NewSlogLogger(slog.New(handler)))
+}
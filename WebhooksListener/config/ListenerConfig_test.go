@@ -0,0 +1,165 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+//
+// This test package apply unit tests on the loading & validation of the
+// listener configuration file.
+//
+
+package config
+
+import (
+  "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/logger"
+  "aplos/partners/WebhooksListener/webhook"
+  "bytes"
+  "errors"
+  "log/slog"
+  "net/http/httptest"
+  "strings"
+  "testing"
+  "time"
+)
+
+const validConfig = `
+clusters:
+  - ip: 10.1.1.1
+    port: 9440
+    endpoints: [10.1.1.2]
+    username: admin
+    password_env: NUTANIX_PASSWORD
+  - ip: 10.2.2.2
+    username: admin
+    password: secret
+    listener_port: "8081"
+listener:
+  port: 8080
+  tls:
+    cert_file: /etc/listener/cert.pem
+    key_file: /etc/listener/key.pem
+  limits:
+    read_timeout: 15s
+events: [VM.ON, VM.OFF]
+consumers:
+  - type: pafw
+    events: [VM.ON]
+    config:
+      pafw_instance_config:
+        ip: 10.3.3.3
+dispatch:
+  max_attempts: 3
+  initial_backoff: 500ms
+`
+
+// Test to verify a valid configuration is decoded into listeners.
+func TestParseValidConfig(t *testing.T) {
+  listenerConfig, err := Parse([]byte(validConfig), "listener.yaml")
+  if (err != nil) {
+    t.Fatalf("Failed to parse valid config: %s", err)
+  }
+  webhooksListener := NewListener(listenerConfig,
+                                  listenerConfig.Clusters[0])
+  if (webhooksListener.ListenerPort != "8080" ||
+      webhooksListener.TLSCertFile != "/etc/listener/cert.pem" ||
+      webhooksListener.ServerLimits.ReadTimeout != 15 * time.Second ||
+      webhooksListener.RetryPolicy.InitialBackoff != 500 * time.Millisecond ||
+      len(webhooksListener.ClusterEndpoints) != 1) {
+    t.Errorf("Unexpected listener of the first cluster: %+v",
+             webhooksListener)
+  }
+  if port := NewListener(listenerConfig,
+      listenerConfig.Clusters[1]).ListenerPort; (port != "8081") {
+    t.Errorf("Expected the cluster listener port, got %s", port)
+  }
+  consumerConfig, ok := FindConsumer(listenerConfig, "pafw")
+  if (!ok || !strings.Contains(string(consumerConfig.Config), "10.3.3.3")) {
+    t.Errorf("Expected the consumer block, got %s", consumerConfig.Config)
+  }
+}
+
+// Test to verify the errors of an invalid configuration carry their lines.
+func TestParseInvalidConfig(t *testing.T) {
+  config := strings.Join([]string{
+    `clusters:`,
+    `  - ip: 10.1.1.1`,
+    `    username: admin`,
+    `    password: secret`,
+    `    prot: 9440`,
+    `listener:`,
+    `  limits:`,
+    `    read_timeout: 15`,
+    `events: [VM.ON, VM.BOOT]`,
+    `subscription_mode: overwrite`,
+  }, "\n")
+  _, err := Parse([]byte(config), "listener.yaml")
+  var validationErrors ValidationErrors
+  if (!errors.As(err, &validationErrors)) {
+    t.Fatalf("Expected validation errors, got %v", err)
+  }
+  expected := []string{
+    "listener.yaml:5:5: clusters[0].prot: unknown key",
+    "listener.yaml:8:19: listener.limits.read_timeout: invalid value",
+    "listener.yaml:10:20: subscription_mode: must be one of",
+  }
+  if (len(validationErrors) != len(expected)) {
+    t.Fatalf("Expected %d errors, got:\n%s", len(expected), err)
+  }
+  for i, prefix := range expected {
+    if (!strings.HasPrefix(validationErrors[i].Error(), prefix)) {
+      t.Errorf("Expected error %q, got %q", prefix, validationErrors[i])
+    }
+  }
+
+  // A unix socket needs the URL Prism reaches it through.
+  config = strings.Join([]string{
+    `clusters:`,
+    `  - {ip: 10.1.1.1, username: admin, password: secret}`,
+    `listener: {unix_socket: /run/listener.sock}`,
+    `events: [VM.ON]`,
+  }, "\n")
+  _, err = Parse([]byte(config), "listener.yaml")
+  if (err == nil || !strings.Contains(err.Error(),
+        "listener.unix_socket: a unix socket requires advertise_url")) {
+    t.Errorf("Unexpected errors:\n%v", err)
+  }
+
+  // The schema is valid, the event type & the shared port are not.
+  config = strings.Join([]string{
+    `clusters:`,
+    `  - {ip: 10.1.1.1, username: admin, password: secret}`,
+    `  - {ip: 10.2.2.2, username: admin, password: secret}`,
+    `events: [VM.ON, VM.BOOT]`,
+  }, "\n")
+  _, err = Parse([]byte(config), "listener.yaml")
+  if (err == nil ||
+      !strings.Contains(err.Error(),
+        `listener.yaml:4:17: events[1]: unknown event type "VM.BOOT"`) ||
+      !strings.Contains(err.Error(), "listener.yaml:3:5: clusters[1]: " +
+        "listener port 8080 is already used by clusters[0]")) {
+    t.Errorf("Unexpected errors:\n%v", err)
+  }
+}
+
+// Test to verify the configured fields are masked in the logged events.
+func TestRedactFields(t *testing.T) {
+  listenerConfig, err := Parse([]byte(validConfig +
+    "logging: {format: json, redact_fields: [vm_note]}\n"), "listener.yaml")
+  if (err != nil) {
+    t.Fatalf("Failed to parse config: %s", err)
+  }
+  previous := logger.Get()
+  defer logger.SetLogger(previous)
+  ConfigureLogging(listenerConfig.Logging)
+  var output bytes.Buffer
+  logger.SetLogger(logger.NewSlogLogger(slog.New(slog.NewJSONHandler(
+    &output, &slog.HandlerOptions{Level: slog.LevelDebug}))))
+
+  request := httptest.NewRequest("POST", lib.ListenerCallbackURL,
+    strings.NewReader(`{"vm_note": "do-not-log"}`))
+  request.Header.Set("Content-Type", "application/json")
+  WebhooksListener.WebhooksListener{}.Handler().ServeHTTP(
+    httptest.NewRecorder(), request)
+  if (!strings.Contains(output.String(), "Event data.") ||
+      strings.Contains(output.String(), "do-not-log")) {
+    t.Errorf("Expected the event logged with vm_note masked, got %s",
+             output.String())
+  }
+}
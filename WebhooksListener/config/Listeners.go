@@ -0,0 +1,95 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+
+// Start of the listeners of all the configured clusters, for the mains of
+// the event consumers.
+
+package config
+
+import (
+  "aplos/partners/WebhooksListener/interfaces"
+  "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/logger"
+  "aplos/partners/WebhooksListener/schemas"
+  "aplos/partners/WebhooksListener/webhook"
+  "fmt"
+  "sync"
+)
+
+// Number of state messages buffered per listener until logged by Wait.
+const listenerStateBuffer = 16
+
+// This method will initialize the listener of every configured cluster &
+// register the event consumer for the given events.
+//
+// Args:
+//    listenerConfig : Listener configuration.
+//    events : Events to subscribe to.
+//    eventConsumer : Event consumer of the events.
+// Returns:
+//    []WebhooksListener : Registered listeners, with their ListenerState.
+//    error : Error of the first cluster that cannot be registered.
+func StartListeners(listenerConfig *schema.ListenerConfig, events []string,
+  eventConsumer interfaces.EventConsumer) (
+  []WebhooksListener.WebhooksListener, error) {
+  var listeners []WebhooksListener.WebhooksListener
+  for _, cluster := range listenerConfig.Clusters {
+    password, err := ClusterPassword(cluster)
+    if (err != nil) {
+      return listeners, fmt.Errorf("cluster %s: %s", cluster.IP, err)
+    }
+    webhooksListener := NewListener(listenerConfig, cluster)
+    webhooksListener, err = webhooksListener.Initialize(cluster.IP,
+      clusterPort(cluster), cluster.Username, password)
+    if (err != nil) {
+      return listeners, fmt.Errorf("cluster %s: %s", cluster.IP, err)
+    }
+    webhooksListener.ListenerState = make(chan string,
+                                          listenerStateBuffer)
+    err = webhooksListener.RegisterForEvents(events, eventConsumer)
+    if (err != nil) {
+      return listeners, fmt.Errorf("cluster %s: %s", cluster.IP, err)
+    }
+    listeners = append(listeners, webhooksListener)
+  }
+  return listeners, nil
+}
+
+// This method will log the state messages of the listeners until they are
+// all closed, i.e. they reported lib.ListenerClosedState or their state
+// channel was closed.
+//
+// Args:
+//    listeners : Registered listeners.
+// Returns:
+//    None.
+func Wait(listeners []WebhooksListener.WebhooksListener) {
+  var group sync.WaitGroup
+  for _, webhooksListener := range listeners {
+    group.Add(1)
+    go func(listenerState chan string) {
+      defer group.Done()
+      for listenerStateMsg := range listenerState {
+        if (listenerStateMsg != "") {
+          logger.Info("Message from Listener.", "state", listenerStateMsg)
+        }
+        if (listenerStateMsg == lib.ListenerClosedState) {
+          return
+        }
+      }
+    }(webhooksListener.ListenerState)
+  }
+  group.Wait()
+}
+
+// This method will return the Prism port of a cluster.
+//
+// Args:
+//    cluster : Cluster configuration.
+// Returns:
+//    string : Prism port.
+func clusterPort(cluster schema.NutanixClusterConfig) (string) {
+  if (cluster.Port == "") {
+    return lib.DefaultPrismPort
+  }
+  return cluster.Port
+}
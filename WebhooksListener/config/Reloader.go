@@ -0,0 +1,253 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+
+// Reload of the listener configuration file, on SIGHUP or when the file
+// changes. The new file is validated as a whole before anything is applied,
+// and the last good configuration is kept if it is invalid or cannot be
+// applied, in which case the consumer is reconfigured back. The consumer
+// configuration block, the event subscriptions & the logging are applied
+// without a restart; the clusters, the callback server & the block of a
+// consumer not supporting reload need one.
+
+package config
+
+import (
+  "aplos/partners/WebhooksListener/interfaces"
+  "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/logger"
+  "aplos/partners/WebhooksListener/schemas"
+  "aplos/partners/WebhooksListener/webhook"
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "os"
+  "os/signal"
+  "reflect"
+  "sync"
+  "syscall"
+  "time"
+)
+
+type Reloader struct {
+  path string
+  consumerType string
+  eventConsumer interfaces.EventConsumer
+  listeners []WebhooksListener.WebhooksListener
+
+  lock sync.Mutex
+  current *schema.ListenerConfig
+  modTime time.Time
+}
+
+// This method will create the reloader of a loaded configuration file.
+//
+// Args:
+//    path : Path of the configuration file.
+//    listenerConfig : Configuration currently applied.
+//    consumerType : Type of the consumer, e.g. "pafw", or empty for a set
+//                   of consumers, e.g. registry.ConsumerSet.
+//    eventConsumer : Event consumer, reconfigured if it implements
+//                    interfaces.Reconfigurable, with its configuration
+//                    block or, for a set, the JSON list of the consumers.
+//    listeners : Registered listeners, resubscribed if the events change.
+// Returns:
+//    Reloader : Reloader of the configuration file.
+func NewReloader(path string, listenerConfig *schema.ListenerConfig,
+  consumerType string, eventConsumer interfaces.EventConsumer,
+  listeners []WebhooksListener.WebhooksListener) (*Reloader) {
+  reloader := &Reloader{path: path, consumerType: consumerType,
+                        eventConsumer: eventConsumer, listeners: listeners,
+                        current: listenerConfig}
+  if info, err := os.Stat(path); (err == nil) {
+    reloader.modTime = info.ModTime()
+  }
+  return reloader
+}
+
+// This method will return the configuration currently applied.
+//
+// Args:
+//    None.
+// Returns:
+//    ListenerConfig : Last good listener configuration.
+func (reloader *Reloader) Current() (*schema.ListenerConfig) {
+  reloader.lock.Lock()
+  defer reloader.lock.Unlock()
+  return reloader.current
+}
+
+// This method will reload the configuration file & apply the changes. The
+// last good configuration is kept if the file or the consumer block is
+// invalid, or if the subscriptions cannot be updated. A failed update is
+// retried at the next poll of the file.
+//
+// Args:
+//    None.
+// Returns:
+//    error : Error if the new configuration is invalid or cannot be applied.
+func (reloader *Reloader) Reload() (error) {
+  reloader.lock.Lock()
+  defer reloader.lock.Unlock()
+  log := logger.With("config", reloader.path)
+  log.Info("Reloading config.")
+  if info, err := os.Stat(reloader.path); (err == nil) {
+    reloader.modTime = info.ModTime()
+  }
+  listenerConfig, err := Load(reloader.path)
+  if (err != nil) {
+    log.Error("Invalid config, keeping the current one.", logger.ErrorKey,
+              err)
+    return err
+  }
+  previous := reloader.current
+
+  // The clusters & the consumer validate the changes first, nothing is
+  // applied otherwise.
+  events := ConsumerEvents(listenerConfig, reloader.consumerType)
+  previousEvents := ConsumerEvents(previous, reloader.consumerType)
+  eventsChanged := !reflect.DeepEqual(events, previousEvents)
+  if (eventsChanged) {
+    for _, webhooksListener := range reloader.listeners {
+      err = lib.ValidateEventTypes(events, webhooksListener.ClusterVersion())
+      if (err != nil) {
+        log.Error("Invalid events, keeping the current config.",
+                  logger.ErrorKey, err)
+        return err
+      }
+    }
+  }
+  err = reloader.reconfigure(listenerConfig)
+  if (err != nil) {
+    log.Error("Invalid consumer config, keeping the current one.",
+              logger.ErrorKey, err)
+    return err
+  }
+
+  if (eventsChanged) {
+    for i, webhooksListener := range reloader.listeners {
+      err = webhooksListener.UpdateSubscriptions(events)
+      if (err == nil) {
+        continue
+      }
+      log.Error("Failed to update the subscriptions, keeping the current " +
+                "config.", logger.ErrorKey, err)
+      for _, updated := range reloader.listeners[:i] {
+        if rollbackErr := updated.UpdateSubscriptions(previousEvents);
+            (rollbackErr != nil) {
+          log.Error("Failed to restore the subscriptions.",
+                    logger.ErrorKey, rollbackErr)
+        }
+      }
+      if rollbackErr := reloader.reconfigure(previous); (rollbackErr != nil) {
+        log.Error("Failed to restore the consumer config.",
+                  logger.ErrorKey, rollbackErr)
+      }
+      reloader.modTime = time.Time{}
+      return err
+    }
+  }
+  reloader.current = listenerConfig
+
+  if (!reflect.DeepEqual(listenerConfig.Logging, previous.Logging)) {
+    ConfigureLogging(listenerConfig.Logging)
+  }
+  if (!reflect.DeepEqual(listenerConfig.Clusters, previous.Clusters) ||
+      !reflect.DeepEqual(listenerConfig.Listener, previous.Listener) ||
+      listenerConfig.Dispatch != previous.Dispatch) {
+    log.Warn("Changes to the clusters, listener or dispatch settings " +
+             "take effect on restart.")
+  }
+  log.Info("Reloaded config.")
+  return nil
+}
+
+// This method will reconfigure the event consumer with its block of the
+// given configuration. A consumer not implementing interfaces.Reconfigurable
+// only accepts its current block.
+//
+// Args:
+//    listenerConfig : Listener configuration.
+// Returns:
+//    error : Error if the consumer rejects its block or cannot reload it.
+func (reloader *Reloader) reconfigure(
+  listenerConfig *schema.ListenerConfig) (error) {
+  name, config, err := reloader.consumerBlock(listenerConfig)
+  if (err != nil) {
+    return fmt.Errorf("%s: %s", name, err)
+  }
+  reconfigurable, ok := reloader.eventConsumer.(interfaces.Reconfigurable)
+  if (!ok) {
+    _, current, err := reloader.consumerBlock(reloader.current)
+    if (err != nil || !bytes.Equal(config, current)) {
+      return fmt.Errorf("%s does not support reload, restart to apply",
+                        name)
+    }
+    return nil
+  }
+  err = reconfigurable.Reconfigure(config)
+  if (err != nil) {
+    return fmt.Errorf("%s: %s", name, err)
+  }
+  return nil
+}
+
+// This method will return the block of the event consumer in the given
+// configuration.
+//
+// Args:
+//    listenerConfig : Listener configuration.
+// Returns:
+//    string : Name of the consumer, for the errors.
+//    []byte : Configuration block or, for a set, JSON list of the consumers.
+//    error : Error if the consumers cannot be encoded.
+func (reloader *Reloader) consumerBlock(
+  listenerConfig *schema.ListenerConfig) (string, []byte, error) {
+  if (reloader.consumerType == "") {
+    config, err := json.Marshal(listenerConfig.Consumers)
+    return "consumers", config, err
+  }
+  consumerConfig, _ := FindConsumer(listenerConfig, reloader.consumerType)
+  return "consumer " + reloader.consumerType, consumerConfig.Config, nil
+}
+
+// This method will reload the configuration on SIGHUP, or when the
+// modification time of the file changes, until stop is closed.
+//
+// Args:
+//    stop : Channel closed to stop watching, may be nil.
+// Returns:
+//    None.
+func (reloader *Reloader) Watch(stop <-chan struct{}) {
+  signals := make(chan os.Signal, 1)
+  signal.Notify(signals, syscall.SIGHUP)
+  defer signal.Stop(signals)
+  ticker := time.NewTicker(lib.ConfigPollInterval)
+  defer ticker.Stop()
+  for {
+    select {
+    case <-stop:
+      return
+    case <-signals:
+      reloader.Reload()
+    case <-ticker.C:
+      if (reloader.changed()) {
+        reloader.Reload()
+      }
+    }
+  }
+}
+
+// This method will check whether the file changed since its last load.
+//
+// Args:
+//    None.
+// Returns:
+//    bool : True if the modification time of the file changed.
+func (reloader *Reloader) changed() (bool) {
+  info, err := os.Stat(reloader.path)
+  if (err != nil) {
+    return false
+  }
+  reloader.lock.Lock()
+  defer reloader.lock.Unlock()
+  return !info.ModTime().Equal(reloader.modTime)
+}
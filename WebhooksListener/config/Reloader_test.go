@@ -0,0 +1,150 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+//
+// This test package apply unit tests on the reload of the listener
+// configuration file.
+//
+
+package config
+
+import (
+  "aplos/partners/WebhooksListener/schemas"
+  "aplos/partners/WebhooksListener/webhook"
+  "fmt"
+  "io/ioutil"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+// Consumer keeping the last configuration block it accepted.
+type reconfigurableConsumer struct {
+  config *string
+}
+
+func (consumer reconfigurableConsumer) OnEvent(event schema.Event) (error) {
+  return nil
+}
+
+func (consumer reconfigurableConsumer) Reconfigure(config []byte) (error) {
+  if (strings.Contains(string(config), "invalid")) {
+    return fmt.Errorf("invalid consumer config")
+  }
+  *consumer.config = string(config)
+  return nil
+}
+
+// Consumer without reload support.
+type staticConsumer struct{}
+
+func (consumer staticConsumer) OnEvent(event schema.Event) (error) {
+  return nil
+}
+
+// Test to verify a reload applies a valid configuration & keeps the last
+// good one otherwise.
+func TestReload(t *testing.T) {
+  path := filepath.Join(t.TempDir(), "listener.yaml")
+  write := func(address string, events string) {
+    config := strings.Join([]string{
+      `clusters:`,
+      `  - {ip: 10.1.1.1, username: admin, password: secret}`,
+      `events: ` + events,
+      `consumers:`,
+      `  - type: test`,
+      `    config: {address: ` + address + `}`,
+    }, "\n")
+    err := ioutil.WriteFile(path, []byte(config), 0600)
+    if (err != nil) {
+      t.Fatalf("Failed to write config: %s", err)
+    }
+  }
+  write("first", "[VM.ON]")
+  listenerConfig, err := Load(path)
+  if (err != nil) {
+    t.Fatalf("Failed to load config: %s", err)
+  }
+  consumerConfig := ""
+  reloader := NewReloader(path, listenerConfig, "test",
+    reconfigurableConsumer{config: &consumerConfig}, nil)
+
+  write("second", "[VM.ON, VM.OFF]")
+  err = reloader.Reload()
+  if (err != nil) {
+    t.Fatalf("Failed to reload config: %s", err)
+  }
+  if (!strings.Contains(consumerConfig, "second") ||
+      len(reloader.Current().Events) != 2) {
+    t.Errorf("Expected the new config, got %s & %v", consumerConfig,
+             reloader.Current().Events)
+  }
+
+  // Neither an invalid file nor an invalid consumer block is applied.
+  write("third", "[VM.BOOT]")
+  if err = reloader.Reload(); (err == nil) {
+    t.Errorf("Expected an error for an unknown event type")
+  }
+  write("invalid", "[VM.ON]")
+  if err = reloader.Reload(); (err == nil) {
+    t.Errorf("Expected an error for an invalid consumer config")
+  }
+  if (!strings.Contains(consumerConfig, "second") ||
+      len(reloader.Current().Events) != 2) {
+    t.Errorf("Expected the last good config, got %s & %v", consumerConfig,
+             reloader.Current().Events)
+  }
+
+  // Nothing is applied if the subscriptions cannot be updated, here for an
+  // unreachable cluster.
+  reloader.listeners = []WebhooksListener.WebhooksListener{{}}
+  write("fourth", "[VM.ON]")
+  if err = reloader.Reload(); (err == nil) {
+    t.Errorf("Expected an error for the failed subscription update")
+  }
+  if (!strings.Contains(consumerConfig, "second") ||
+      len(reloader.Current().Events) != 2 || !reloader.changed()) {
+    t.Errorf("Expected the last good config restored & retried, got %s & " +
+             "%v", consumerConfig, reloader.Current().Events)
+  }
+}
+
+// Test to verify a changed block of a consumer without reload support fails
+// the reload, while its other changes are applied.
+func TestReloadNotSupported(t *testing.T) {
+  path := filepath.Join(t.TempDir(), "listener.yaml")
+  write := func(address string, events string) {
+    config := strings.Join([]string{
+      `clusters:`,
+      `  - {ip: 10.1.1.1, username: admin, password: secret}`,
+      `events: ` + events,
+      `consumers:`,
+      `  - type: test`,
+      `    config: {address: ` + address + `}`,
+    }, "\n")
+    err := ioutil.WriteFile(path, []byte(config), 0600)
+    if (err != nil) {
+      t.Fatalf("Failed to write config: %s", err)
+    }
+  }
+  write("first", "[VM.ON]")
+  listenerConfig, err := Load(path)
+  if (err != nil) {
+    t.Fatalf("Failed to load config: %s", err)
+  }
+  reloader := NewReloader(path, listenerConfig, "test", staticConsumer{},
+                          nil)
+
+  write("first", "[VM.ON, VM.OFF]")
+  if err = reloader.Reload(); (err != nil) {
+    t.Fatalf("Failed to reload config: %s", err)
+  }
+  write("second", "[VM.ON]")
+  err = reloader.Reload()
+  if (err == nil || !strings.Contains(err.Error(),
+      "consumer test does not support reload")) {
+    t.Errorf("Expected a reload error, got %v", err)
+  }
+  if (len(reloader.Current().Events) != 2) {
+    t.Errorf("Expected the last good config, got %v",
+             reloader.Current().Events)
+  }
+}
@@ -0,0 +1,347 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+
+// Validation of the listener configuration file against the published JSON
+// schema, listener_config.schema.json. The file is validated on its YAML
+// node tree, so that every error reports the line & column it is about.
+// JSON files are valid YAML & are validated the same way.
+//
+// The validator supports the subset of JSON schema used by the published
+// schema: type, properties, required, additionalProperties, items, enum,
+// pattern, minLength, minItems, minimum & local $ref.
+
+package config
+
+import (
+  _ "embed"
+  "encoding/json"
+  "fmt"
+  "gopkg.in/yaml.v3"
+  "regexp"
+  "sort"
+  "strconv"
+  "strings"
+)
+
+//go:embed listener_config.schema.json
+var schemaJSON []byte
+
+type ValidationError struct {
+  // Error at a position of the configuration file.
+  File string
+  Line int
+  Column int
+  Path string // Path of the value, e.g. "clusters[0].port".
+  Message string
+}
+
+func (validationError *ValidationError) Error() (string) {
+  path := validationError.Path
+  if (path == "") {
+    path = "(root)"
+  }
+  return fmt.Sprintf("%s:%d:%d: %s: %s", validationError.File,
+                     validationError.Line, validationError.Column, path,
+                     validationError.Message)
+}
+
+// Errors of a configuration file, in the order of the file.
+type ValidationErrors []*ValidationError
+
+func (validationErrors ValidationErrors) Error() (string) {
+  messages := make([]string, len(validationErrors))
+  for i, validationError := range validationErrors {
+    messages[i] = validationError.Error()
+  }
+  return strings.Join(messages, "\n")
+}
+
+type jsonSchema struct {
+  // Node of the JSON schema.
+  Ref string `json:"$ref"`
+  Type string `json:"type"`
+  Properties map[string]*jsonSchema `json:"properties"`
+  Required []string `json:"required"`
+  AdditionalProperties *bool `json:"additionalProperties"`
+  Items *jsonSchema `json:"items"`
+  Enum []string `json:"enum"`
+  Pattern string `json:"pattern"`
+  MinLength int `json:"minLength"`
+  MinItems int `json:"minItems"`
+  Minimum *float64 `json:"minimum"`
+  Defs map[string]*jsonSchema `json:"$defs"`
+}
+
+// Published schema of the configuration file, parsed once.
+var listenerSchema = mustParseSchema(schemaJSON)
+
+// This method will return the published JSON schema of the configuration
+// file.
+//
+// Args:
+//    None.
+// Returns:
+//    []byte : JSON schema.
+func Schema() ([]byte) {
+  return append([]byte(nil), schemaJSON...)
+}
+
+// This method will parse the JSON schema.
+//
+// Args:
+//    data : JSON schema.
+// Returns:
+//    jsonSchema : Parsed schema, panics if invalid.
+func mustParseSchema(data []byte) (*jsonSchema) {
+  var parsed jsonSchema
+  err := json.Unmarshal(data, &parsed)
+  if (err != nil) {
+    panic(fmt.Sprintf("invalid listener configuration schema: %s", err))
+  }
+  return &parsed
+}
+
+type validator struct {
+  // State of the validation of a configuration file.
+  file string
+  root *jsonSchema
+  errors ValidationErrors
+  lines map[string]*yaml.Node // Node of every path, to locate later errors.
+}
+
+// This method will record an error at the given node.
+//
+// Args:
+//    node : Node of the error.
+//    path : Path of the node.
+//    format : Message format & its arguments.
+// Returns:
+//    None.
+func (validator *validator) fail(node *yaml.Node, path string,
+  format string, args ...interface{}) {
+  validator.errors = append(validator.errors, &ValidationError{
+    File: validator.file,
+    Line: node.Line,
+    Column: node.Column,
+    Path: path,
+    Message: fmt.Sprintf(format, args...),
+  })
+}
+
+// This method will validate a node against its schema & convert it into a
+// JSON value. Scalars are converted to strings where the schema expects
+// strings, e.g. an unquoted port.
+//
+// Args:
+//    node : YAML node.
+//    schema : Schema of the node.
+//    path : Path of the node.
+// Returns:
+//    interface{} : JSON value of the node, nil if invalid.
+func (validator *validator) validate(node *yaml.Node, schema *jsonSchema,
+  path string) (interface{}) {
+  for (node.Kind == yaml.DocumentNode || node.Kind == yaml.AliasNode) {
+    if (node.Kind == yaml.AliasNode) {
+      node = node.Alias
+    } else if (len(node.Content) > 0) {
+      node = node.Content[0]
+    } else {
+      break
+    }
+  }
+  validator.lines[path] = node
+  if (schema.Ref != "") {
+    name := strings.TrimPrefix(schema.Ref, "#/$defs/")
+    schema = validator.root.Defs[name]
+    if (schema == nil) {
+      validator.fail(node, path, "unknown schema reference %s", name)
+      return nil
+    }
+  }
+
+  switch schema.Type {
+  case "object":
+    return validator.validateObject(node, schema, path)
+  case "array":
+    return validator.validateArray(node, schema, path)
+  case "":
+    var value interface{}
+    node.Decode(&value)
+    return jsonValue(value)
+  }
+  if (node.Kind != yaml.ScalarNode || node.Tag == "!!null") {
+    validator.fail(node, path, "must be a %s", schema.Type)
+    return nil
+  }
+  switch schema.Type {
+  case "string":
+    if (len(node.Value) < schema.MinLength) {
+      validator.fail(node, path, "must not be empty")
+      return nil
+    }
+    if (len(schema.Enum) > 0 && !contains(schema.Enum, node.Value)) {
+      validator.fail(node, path, "must be one of: %s",
+                     strings.Join(schema.Enum, ", "))
+      return nil
+    }
+    if (schema.Pattern != "" &&
+        !regexp.MustCompile(schema.Pattern).MatchString(node.Value)) {
+      validator.fail(node, path, "invalid value %q, must match %s",
+                     node.Value, schema.Pattern)
+      return nil
+    }
+    return node.Value
+  case "integer":
+    value, err := strconv.ParseInt(node.Value, 0, 64)
+    if (err != nil || node.Tag != "!!int") {
+      validator.fail(node, path, "must be an integer")
+      return nil
+    }
+    if (schema.Minimum != nil && float64(value) < *schema.Minimum) {
+      validator.fail(node, path, "must be at least %v", *schema.Minimum)
+      return nil
+    }
+    return value
+  case "boolean":
+    if (node.Tag != "!!bool") {
+      validator.fail(node, path, "must be true or false")
+      return nil
+    }
+    return (node.Value == "true" || node.Value == "True" ||
+            node.Value == "TRUE")
+  }
+  validator.fail(node, path, "unsupported schema type %s", schema.Type)
+  return nil
+}
+
+// This method will validate a mapping node against an object schema.
+//
+// Args:
+//    node : YAML node.
+//    schema : Object schema.
+//    path : Path of the node.
+// Returns:
+//    interface{} : JSON object, nil if the node is not a mapping.
+func (validator *validator) validateObject(node *yaml.Node,
+  schema *jsonSchema, path string) (interface{}) {
+  if (node.Kind != yaml.MappingNode) {
+    validator.fail(node, path, "must be an object")
+    return nil
+  }
+  object := map[string]interface{}{}
+  for i := 0; i + 1 < len(node.Content); i += 2 {
+    key, value := node.Content[i], node.Content[i + 1]
+    childPath := key.Value
+    if (path != "") {
+      childPath = path + "." + key.Value
+    }
+    if _, ok := object[key.Value]; ok {
+      validator.fail(key, childPath, "duplicate key")
+      continue
+    }
+    propertySchema, ok := schema.Properties[key.Value]
+    if (!ok) {
+      if (schema.AdditionalProperties != nil &&
+          !*schema.AdditionalProperties) {
+        validator.fail(key, childPath, "unknown key, must be one of: %s",
+                       strings.Join(propertyNames(schema), ", "))
+        continue
+      }
+      propertySchema = &jsonSchema{}
+    }
+    object[key.Value] = validator.validate(value, propertySchema, childPath)
+  }
+  for _, required := range schema.Required {
+    if _, ok := object[required]; !ok {
+      validator.fail(node, path, "missing required key %q", required)
+    }
+  }
+  return object
+}
+
+// This method will validate a sequence node against an array schema.
+//
+// Args:
+//    node : YAML node.
+//    schema : Array schema.
+//    path : Path of the node.
+// Returns:
+//    interface{} : JSON array, nil if the node is not a sequence.
+func (validator *validator) validateArray(node *yaml.Node,
+  schema *jsonSchema, path string) (interface{}) {
+  if (node.Kind != yaml.SequenceNode) {
+    validator.fail(node, path, "must be a list")
+    return nil
+  }
+  if (len(node.Content) < schema.MinItems) {
+    validator.fail(node, path, "must have at least %d item(s)",
+                   schema.MinItems)
+  }
+  items := schema.Items
+  if (items == nil) {
+    items = &jsonSchema{}
+  }
+  array := make([]interface{}, 0, len(node.Content))
+  for i, item := range node.Content {
+    array = append(array, validator.validate(item, items,
+      fmt.Sprintf("%s[%d]", path, i)))
+  }
+  return array
+}
+
+// This method will return the property names of an object schema, sorted.
+//
+// Args:
+//    schema : Object schema.
+// Returns:
+//    []string : Property names.
+func propertyNames(schema *jsonSchema) ([]string) {
+  names := make([]string, 0, len(schema.Properties))
+  for name := range schema.Properties {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  return names
+}
+
+// This method will convert a decoded YAML value into a JSON value, YAML
+// mappings having keys of any type.
+//
+// Args:
+//    value : Decoded YAML value.
+// Returns:
+//    interface{} : JSON value.
+func jsonValue(value interface{}) (interface{}) {
+  switch typed := value.(type) {
+  case map[string]interface{}:
+    for key, item := range typed {
+      typed[key] = jsonValue(item)
+    }
+  case map[interface{}]interface{}:
+    object := map[string]interface{}{}
+    for key, item := range typed {
+      object[fmt.Sprint(key)] = jsonValue(item)
+    }
+    return object
+  case []interface{}:
+    for i, item := range typed {
+      typed[i] = jsonValue(item)
+    }
+  }
+  return value
+}
+
+// This method will check if a list contains a value.
+//
+// Args:
+//    list : List of values.
+//    value : Value to find.
+// Returns:
+//    bool : True if the list contains the value.
+func contains(list []string, value string) (bool) {
+  for _, item := range list {
+    if (item == value) {
+      return true
+    }
+  }
+  return false
+}
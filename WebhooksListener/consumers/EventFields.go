@@ -0,0 +1,62 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+
+// Key fields of the events, shared by the built-in consumers.
+
+package consumers
+
+import (
+  "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/schemas"
+)
+
+type EventFields struct {
+  // Key fields of an event. The VM fields are empty for the other kinds.
+  Kind string
+  Name string
+  UUID string
+  IPs []string
+  Category string // Network function provider category.
+  Categories map[string]string
+}
+
+// This method will extract the key fields of the event.
+//
+// Args:
+//    event : Event object.
+// Returns:
+//    EventFields : Key fields of the event.
+func eventFields(event schema.Event) (EventFields) {
+  fields := EventFields{Kind: lib.EventKind(event),
+                        UUID: event.EntityReference.UUID}
+  metadata := event.Data.Metadata
+  if (event.Payload != nil) {
+    if vmMetadata, ok := event.Payload.(*schema.EventMetadata); ok {
+      metadata = *vmMetadata
+    }
+  }
+  fields.Categories = metadata.SubMetadata.Categories
+  fields.Category = fields.Categories[lib.NetworkFunctionProviderCategory]
+  if (fields.Kind != lib.VMKind) {
+    return fields
+  }
+
+  fields.Name = metadata.Status.Name
+  if (fields.Name == "") {
+    fields.Name = metadata.Spec.Name
+  }
+  if (metadata.SubMetadata.UUID != "") {
+    fields.UUID = metadata.SubMetadata.UUID
+  }
+  nics := metadata.Status.Resources.NICList
+  if (len(nics) == 0) {
+    nics = metadata.Spec.Resources.NICList
+  }
+  for _, nic := range nics {
+    for _, endpoint := range nic.IPEndPointList {
+      if (endpoint.IPAddress != "") {
+        fields.IPs = append(fields.IPs, endpoint.IPAddress)
+      }
+    }
+  }
+  return fields
+}
@@ -0,0 +1,278 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+
+// Implementation of the event consumer interface running a command per
+// event, for automations written as scripts.
+//
+// Description:
+//   1) The command configured for the event type is run with the event JSON
+//      on its stdin & the key fields of the event in its environment:
+//        NUTANIX_EVENT_TYPE   Event type, e.g. VM.ON.
+//        NUTANIX_ENTITY_KIND  Entity kind, e.g. vm.
+//        NUTANIX_ENTITY_UUID  UUID of the entity.
+//        NUTANIX_VM_NAME      Name of the VM.
+//        NUTANIX_VM_UUID      UUID of the VM.
+//        NUTANIX_VM_IPS       IP addresses of the VM, space separated.
+//        NUTANIX_CATEGORY     Network function provider category of the VM.
+//        NUTANIX_CATEGORIES   Categories of the VM, as key=value, space
+//                             separated.
+//      The NUTANIX_VM_* variables are set for the VM events only.
+//   2) Exit code 0 is a success. The retryable exit codes, 75 (EX_TEMPFAIL)
+//      by default, & timeouts are retried as per the listener's retry
+//      policy. Other exit codes are failures which are not retried.
+//
+
+package consumers
+
+import (
+  "aplos/partners/WebhooksListener/interfaces"
+  "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/logger"
+  "aplos/partners/WebhooksListener/registry"
+  "aplos/partners/WebhooksListener/schemas"
+  "bytes"
+  "context"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "os"
+  "os/exec"
+  "sort"
+  "strings"
+  "sync/atomic"
+  "time"
+)
+
+type ExecEventConsumer struct {
+  // Type that implements the EventConsumer interface.
+
+  // Current configuration, shared by the copies of the consumer.
+  current *atomic.Value
+}
+
+const (
+  // Consumer type of the exec consumer in the listener configuration.
+  ExecConsumerType = "exec"
+
+  // Event type of the command run for the event types without their own.
+  anyEventType = "*"
+
+  // Output of the command kept for the logs & errors.
+  maxExecOutput = 4 << 10
+)
+
+func init() {
+  registry.Register(ExecConsumerType,
+    func(config []byte) (interfaces.EventConsumer, error) {
+      return NewExecEventConsumer(config)
+    })
+}
+
+// This method will parse & check the configuration block of the consumer.
+//
+// Args:
+//    data : Configuration block, as JSON.
+// Returns:
+//    ExecConsumerConfig : Consumer configuration.
+//    error : Error if the block is invalid.
+func ParseExecConfig(data []byte) (schema.ExecConsumerConfig, error) {
+  var execConfig schema.ExecConsumerConfig
+  if (len(data) == 0) {
+    return execConfig, errors.New("commands are required")
+  }
+  err := json.Unmarshal(data, &execConfig)
+  if (err != nil) {
+    return execConfig, err
+  }
+  if (len(execConfig.Commands) == 0) {
+    return execConfig, errors.New("commands are required")
+  }
+  for eventType, command := range execConfig.Commands {
+    if (eventType != anyEventType && !lib.IsKnownEventType(eventType)) {
+      return execConfig, fmt.Errorf("commands: unknown event type %q",
+                                    eventType)
+    }
+    if (len(command) == 0 || command[0] == "") {
+      return execConfig, fmt.Errorf("commands: empty command for %s",
+                                    eventType)
+    }
+  }
+  return execConfig, nil
+}
+
+// This method will create the consumer with the given configuration block.
+//
+// Args:
+//    data : Configuration block of the consumer, as JSON.
+// Returns:
+//    ExecEventConsumer : Event consumer.
+//    error : Error if the configuration is invalid.
+func NewExecEventConsumer(data []byte) (ExecEventConsumer, error) {
+  execEventConsumer := ExecEventConsumer{current: &atomic.Value{}}
+  err := execEventConsumer.Reconfigure(data)
+  return execEventConsumer, err
+}
+
+// This method will replace the configuration of the consumer, keeping the
+// current one if the new one is invalid.
+//
+// Args:
+//    data : Configuration block of the consumer, as JSON.
+// Returns:
+//    error : Error if the configuration is invalid.
+func (execEventConsumer ExecEventConsumer) Reconfigure(data []byte) (error) {
+  if (execEventConsumer.current == nil) {
+    return errors.New("consumer was not created by NewExecEventConsumer")
+  }
+  execConfig, err := ParseExecConfig(data)
+  if (err != nil) {
+    return err
+  }
+  execEventConsumer.current.Store(execConfig)
+  return nil
+}
+
+// This method will return the name of the consumer.
+//
+// Args:
+//    None.
+// Returns:
+//    string : Consumer name.
+func (execEventConsumer ExecEventConsumer) Name() (string) {
+  return ExecConsumerType
+}
+
+// This method will run the command configured for the event type.
+//
+// Args:
+//    event : Event object containing the event data sent by the listener.
+// Returns:
+//    error : Error if the command fails, retryable for the retryable exit
+//            codes & timeouts.
+func (execEventConsumer ExecEventConsumer) OnEvent(
+  event schema.Event) (error) {
+  if (execEventConsumer.current == nil) {
+    return errors.New("consumer was not created by NewExecEventConsumer")
+  }
+  execConfig := execEventConsumer.current.Load().(schema.ExecConsumerConfig)
+  log := logger.With(logger.ConsumerKey, ExecConsumerType,
+                     logger.EventTypeKey, event.Event_Type,
+                     logger.VMUUIDKey, event.EntityReference.UUID)
+  command, ok := execConfig.Commands[event.Event_Type]
+  if (!ok) {
+    command, ok = execConfig.Commands[anyEventType]
+  }
+  if (!ok) {
+    log.Debug("No command for the event type.")
+    return nil
+  }
+  input := []byte(event.Raw)
+  if (len(input) == 0) {
+    encoded, err := json.Marshal(event)
+    if (err != nil) {
+      return err
+    }
+    input = encoded
+  }
+
+  timeout := time.Duration(execConfig.Timeout)
+  if (timeout <= 0) {
+    timeout = lib.DefaultExecTimeout
+  }
+  ctx, cancel := context.WithTimeout(event.Context(), timeout)
+  defer cancel()
+  process := exec.CommandContext(ctx, command[0], command[1:]...)
+  process.Env = append(append(os.Environ(), execConfig.Env...),
+                       eventEnv(event)...)
+  process.Dir = execConfig.Dir
+  process.Stdin = bytes.NewReader(input)
+  output := &limitedBuffer{limit: maxExecOutput}
+  process.Stdout = output
+  process.Stderr = output
+  // Do not wait for the children keeping the output open past the timeout.
+  process.WaitDelay = time.Second
+
+  log.Info("Running command.", "command", command[0])
+  start := time.Now()
+  err := process.Run()
+  log = log.With("command", command[0], "duration", time.Since(start),
+                 "output", output.String())
+  if (err == nil) {
+    log.Info("Command succeeded.")
+    return nil
+  }
+  if (ctx.Err() == context.DeadlineExceeded) {
+    err = fmt.Errorf("%s timed out after %s", command[0], timeout)
+    log.Error("Command timed out.", logger.ErrorKey, err)
+    return lib.Retryable(err)
+  }
+  var exitErr *exec.ExitError
+  if (!errors.As(err, &exitErr)) {
+    log.Error("Failed to run command.", logger.ErrorKey, err)
+    return err
+  }
+  exitCode := exitErr.ExitCode()
+  err = fmt.Errorf("%s exited with status %d: %s", command[0], exitCode,
+                   strings.TrimSpace(output.String()))
+  log.Error("Command failed.", logger.ErrorKey, err)
+  retryableExitCodes := execConfig.RetryableExitCodes
+  if (len(retryableExitCodes) == 0) {
+    retryableExitCodes = []int{lib.ExecRetryableExitCode}
+  }
+  for _, retryableExitCode := range retryableExitCodes {
+    if (exitCode == retryableExitCode) {
+      return lib.Retryable(err)
+    }
+  }
+  return err
+}
+
+// This method will return the environment variables carrying the key
+// fields of the event.
+//
+// Args:
+//    event : Event object.
+// Returns:
+//    []string : Environment variables, as KEY=value.
+func eventEnv(event schema.Event) ([]string) {
+  fields := eventFields(event)
+  var categories []string
+  for key, value := range fields.Categories {
+    categories = append(categories, key + "=" + value)
+  }
+  sort.Strings(categories)
+
+  env := []string{
+    "NUTANIX_EVENT_TYPE=" + event.Event_Type,
+    "NUTANIX_ENTITY_KIND=" + fields.Kind,
+    "NUTANIX_ENTITY_UUID=" + event.EntityReference.UUID,
+    "NUTANIX_CATEGORY=" + fields.Category,
+    "NUTANIX_CATEGORIES=" + strings.Join(categories, " "),
+  }
+  if (fields.Kind == lib.VMKind) {
+    env = append(env, "NUTANIX_VM_NAME=" + fields.Name,
+                 "NUTANIX_VM_UUID=" + fields.UUID,
+                 "NUTANIX_VM_IPS=" + strings.Join(fields.IPs, " "))
+  }
+  return env
+}
+
+type limitedBuffer struct {
+  // Writer keeping the first bytes of the output of a command.
+  limit int
+  buffer bytes.Buffer
+}
+
+func (limitedBuffer *limitedBuffer) Write(data []byte) (int, error) {
+  if room := limitedBuffer.limit - limitedBuffer.buffer.Len(); (room > 0) {
+    if (len(data) > room) {
+      limitedBuffer.buffer.Write(data[:room])
+    } else {
+      limitedBuffer.buffer.Write(data)
+    }
+  }
+  return len(data), nil
+}
+
+func (limitedBuffer *limitedBuffer) String() (string) {
+  return limitedBuffer.buffer.String()
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+//
+// This test package apply unit tests on the exec consumer.
+//
+
+package consumers
+
+import (
+  "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/schemas"
+  "encoding/json"
+  "strings"
+  "testing"
+  "time"
+)
+
+const vmEvent = `{
+  "event_type": "VM.ON",
+  "entity_reference": {"kind": "vm", "uuid": "vm-1"},
+  "data": {"metadata": {
+    "status": {"name": "web-1", "resources": {"nic_list": [
+      {"ip_endpoint_list": [{"ip": "10.1.1.1"}, {"ip": "10.1.1.2"}]}]}},
+    "metadata": {"uuid": "vm-1",
+                 "categories": {"network_function_provider": "pafw"}}}}
+}`
+
+// This method will return the event of the JSON.
+//
+// Args:
+//    t : Test.
+//    data : Event JSON.
+// Returns:
+//    Event : Event object.
+func parseEvent(t *testing.T, data string) (schema.Event) {
+  var event schema.Event
+  err := json.Unmarshal([]byte(data), &event)
+  if (err == nil) {
+    err = lib.DecodePayload(&event)
+  }
+  if (err != nil) {
+    t.Fatalf("Failed to parse event: %s", err)
+  }
+  return event
+}
+
+// Test to verify the command gets the event & its exit code is mapped to
+// success or failure.
+func TestExecEventConsumer(t *testing.T) {
+  _, err := NewExecEventConsumer([]byte(`{"commands": {"VM.BOOT": ["true"]}}`))
+  if (err == nil || !strings.Contains(err.Error(), "unknown event type")) {
+    t.Errorf("Expected an unknown event type error, got %v", err)
+  }
+
+  script := func(body string, timeout time.Duration) ([]byte) {
+    config, _ := json.Marshal(schema.ExecConsumerConfig{
+      Commands: map[string][]string{"*": {"/bin/sh", "-c", body}},
+      Timeout: schema.Duration(timeout),
+    })
+    return config
+  }
+  execEventConsumer, err := NewExecEventConsumer(script(
+    `test "$NUTANIX_VM_NAME $NUTANIX_VM_IPS $NUTANIX_CATEGORY" = ` +
+    `"web-1 10.1.1.1 10.1.1.2 pafw" && grep -q '"uuid": "vm-1"'`,
+    5 * time.Second))
+  if (err != nil) {
+    t.Fatalf("Failed to create consumer: %s", err)
+  }
+  event := parseEvent(t, vmEvent)
+  if err = execEventConsumer.OnEvent(event); (err != nil) {
+    t.Errorf("Expected the command to get the event, got %s", err)
+  }
+
+  cases := []struct {
+    body string
+    timeout time.Duration
+    retryable bool
+    message string
+  }{
+    {"echo appliance unreachable; exit 75", 5 * time.Second, true,
+     "status 75"},
+    {"echo unknown VM >&2; exit 1", 5 * time.Second, false, "unknown VM"},
+    {"sleep 5", 200 * time.Millisecond, true, "timed out"},
+  }
+  for _, testCase := range cases {
+    err = execEventConsumer.Reconfigure(script(testCase.body,
+                                                     testCase.timeout))
+    if (err != nil) {
+      t.Fatalf("Failed to reconfigure consumer: %s", err)
+    }
+    err = execEventConsumer.OnEvent(event)
+    if (err == nil || lib.IsRetryable(err) != testCase.retryable ||
+        !strings.Contains(err.Error(), testCase.message)) {
+      t.Errorf("%s: unexpected error %v", testCase.body, err)
+    }
+  }
+}
@@ -0,0 +1,539 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+
+// Implementation of the event consumer interface sending HTTP calls per
+// event, for the appliances driven by a REST API.
+//
+// Description:
+//   1) Each event type maps to an ordered list of steps, "*" for the other
+//      event types. A step is an HTTP call whose URL, headers & body are Go
+//      templates over:
+//        .Event     Event object, e.g. {{.Event.Event_Type}}.
+//        .Entity    Key fields of the event, e.g. {{.Entity.Name}},
+//                   {{index .Entity.IPs 0}} or {{.Entity.Category}}.
+//        .Vars      Vars of the configuration & values captured by the
+//                   previous steps, e.g. {{.Vars.token}}.
+//      The templates may use the json, join, lower & upper functions.
+//   2) A step with a when template is skipped unless the template renders
+//      true, e.g. when: '{{eq .Entity.Category "web"}}'.
+//   3) The response of a step must have one of the expected statuses, any
+//      2xx by default. Its capture templates then render values from .Status
+//      & .Response, the response body decoded from JSON, into the vars of
+//      the next steps, e.g. token: '{{.Response.token}}'.
+//   4) Connection failures, timeouts, 429 & 5xx statuses are retried as per
+//      the listener's retry policy, from the first step. Once a step which
+//      is not idempotent, e.g. a POST, has succeeded, a failed step is
+//      retried in place instead, & the event is not retried from the first
+//      step if it still fails. Other unexpected statuses are failures which
+//      are not retried.
+//
+
+package consumers
+
+import (
+  "aplos/partners/WebhooksListener/interfaces"
+  "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/logger"
+  "aplos/partners/WebhooksListener/middleware"
+  "aplos/partners/WebhooksListener/registry"
+  "aplos/partners/WebhooksListener/schemas"
+  "context"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "net/http"
+  "strings"
+  "sync/atomic"
+  "text/template"
+  "time"
+)
+
+type RESTEventConsumer struct {
+  // Type that implements the EventConsumer interface.
+
+  // Current configuration with its templates, shared by the copies of the
+  // consumer.
+  current *atomic.Value
+}
+
+// Data the templates of the steps are executed with.
+type RESTTemplateData struct {
+  Event schema.Event
+  Entity EventFields
+  Vars map[string]string
+  Status int // Status of the step, for the captures.
+  Response interface{} // Body of the step, decoded from JSON if it is.
+}
+
+type restStep struct {
+  // Step with its templates.
+  name string
+  method string
+  expectStatus []int
+  when *template.Template
+  url *template.Template
+  body *template.Template
+  headers map[string]*template.Template
+  capture map[string]*template.Template
+}
+
+type restState struct {
+  // Configuration with its templates.
+  config schema.RESTConsumerConfig
+  headers map[string]*template.Template
+  events map[string][]restStep
+}
+
+const (
+  // Consumer type of the REST consumer in the listener configuration.
+  RESTConsumerType = "rest"
+
+  // Response body kept for the captures & errors.
+  maxRESTResponse = 1 << 20
+)
+
+// Retries of a failed step once a step which is not idempotent has
+// succeeded, the listener's defaults for the zero values.
+var stepRetryPolicy = schema.RetryPolicy{}
+
+// Functions available to the templates.
+var templateFuncs = template.FuncMap{
+  "json": func(value interface{}) (string, error) {
+    encoded, err := json.Marshal(value)
+    return string(encoded), err
+  },
+  "join": strings.Join,
+  "lower": strings.ToLower,
+  "upper": strings.ToUpper,
+}
+
+func init() {
+  registry.Register(RESTConsumerType,
+    func(config []byte) (interfaces.EventConsumer, error) {
+      return NewRESTEventConsumer(config)
+    })
+}
+
+// This method will create the consumer with the given configuration block.
+//
+// Args:
+//    data : Configuration block of the consumer, as JSON.
+// Returns:
+//    RESTEventConsumer : Event consumer.
+//    error : Error if the configuration or a template is invalid.
+func NewRESTEventConsumer(data []byte) (RESTEventConsumer, error) {
+  restEventConsumer := RESTEventConsumer{current: &atomic.Value{}}
+  err := restEventConsumer.Reconfigure(data)
+  return restEventConsumer, err
+}
+
+// This method will replace the configuration of the consumer, keeping the
+// current one if the new one is invalid.
+//
+// Args:
+//    data : Configuration block of the consumer, as JSON.
+// Returns:
+//    error : Error if the configuration or a template is invalid.
+func (restEventConsumer RESTEventConsumer) Reconfigure(data []byte) (error) {
+  if (restEventConsumer.current == nil) {
+    return errors.New("consumer was not created by NewRESTEventConsumer")
+  }
+  state, err := parseRESTConfig(data)
+  if (err != nil) {
+    return err
+  }
+  restEventConsumer.current.Store(state)
+  return nil
+}
+
+// This method will return the name of the consumer.
+//
+// Args:
+//    None.
+// Returns:
+//    string : Consumer name.
+func (restEventConsumer RESTEventConsumer) Name() (string) {
+  return RESTConsumerType
+}
+
+// This method will run the steps of the event type, in order.
+//
+// Args:
+//    event : Event object containing the event data sent by the listener.
+// Returns:
+//    error : Error of the failed step, retryable if the step may succeed
+//            when retried.
+func (restEventConsumer RESTEventConsumer) OnEvent(
+  event schema.Event) (error) {
+  if (restEventConsumer.current == nil) {
+    return errors.New("consumer was not created by NewRESTEventConsumer")
+  }
+  state := restEventConsumer.current.Load().(*restState)
+  log := logger.With(logger.ConsumerKey, RESTConsumerType,
+                     logger.EventTypeKey, event.Event_Type,
+                     logger.VMUUIDKey, event.EntityReference.UUID)
+  steps, ok := state.events[event.Event_Type]
+  if (!ok) {
+    steps, ok = state.events[anyEventType]
+  }
+  if (!ok) {
+    log.Debug("No steps for the event type.")
+    return nil
+  }
+
+  data := &RESTTemplateData{Event: event, Entity: eventFields(event),
+                            Vars: map[string]string{}}
+  for name, value := range state.config.Vars {
+    data.Vars[name] = value
+  }
+  // Last step which succeeded & is not idempotent. Retrying the event from
+  // the first step would run it again.
+  committed := ""
+  for _, step := range steps {
+    run, err := step.enabled(data)
+    if (err == nil && run) {
+      if (committed == "") {
+        err = state.runStep(event.Context(), step, data)
+      } else {
+        err = state.retryStep(event, step, data)
+      }
+    }
+    if (err != nil) {
+      if (committed != "" && lib.IsRetryable(err)) {
+        err = fmt.Errorf("step %s: %s, not retried as step %s succeeded",
+                         step.name, err, committed)
+      } else {
+        err = fmt.Errorf("step %s: %w", step.name, err)
+      }
+      log.Error("Step failed.", logger.ErrorKey, err)
+      return err
+    }
+    if (run && !idempotent(step.method)) {
+      committed = step.name
+    }
+  }
+  return nil
+}
+
+// This method will check if a step is to run, as per its when template.
+//
+// Args:
+//    data : Template data.
+// Returns:
+//    bool : True unless the when template renders false.
+//    error : Error if the template fails.
+func (step restStep) enabled(data *RESTTemplateData) (bool, error) {
+  if (step.when == nil) {
+    return true, nil
+  }
+  when, err := render(step.when, data)
+  if (err != nil) {
+    return false, err
+  }
+  if (!isTrue(when)) {
+    logger.Info("Skipping step.", logger.ConsumerKey, RESTConsumerType,
+                "step", step.name, "when", when)
+    return false, nil
+  }
+  return true, nil
+}
+
+// This method will run a step, retrying it in place as per the step retry
+// policy if it may succeed when retried.
+//
+// Args:
+//    event : Event object, whose context bounds the retries.
+//    step : Step to run.
+//    data : Template data, receiving the captured values.
+// Returns:
+//    error : Error of the last attempt.
+func (state *restState) retryStep(event schema.Event, step restStep,
+  data *RESTTemplateData) (error) {
+  retry := middleware.Retry(stepRetryPolicy)
+  return retry(middleware.ConsumerFunc(func(event schema.Event) (error) {
+    return state.runStep(event.Context(), step, data)
+  })).OnEvent(event)
+}
+
+// This method will run a step & capture the values of its response.
+//
+// Args:
+//    ctx : Context of the event handling.
+//    step : Step to run.
+//    data : Template data, receiving the captured values.
+// Returns:
+//    error : Error, retryable if the step may succeed when retried.
+func (state *restState) runStep(ctx context.Context, step restStep,
+  data *RESTTemplateData) (error) {
+  log := logger.With(logger.ConsumerKey, RESTConsumerType, "step", step.name)
+  requestURL, err := render(step.url, data)
+  if (err != nil) {
+    return err
+  }
+  body := ""
+  if (step.body != nil) {
+    body, err = render(step.body, data)
+    if (err != nil) {
+      return err
+    }
+  }
+  header := http.Header{}
+  for _, headers := range []map[string]*template.Template{state.headers,
+                                                           step.headers} {
+    for name, headerTemplate := range headers {
+      value, err := render(headerTemplate, data)
+      if (err != nil) {
+        return err
+      }
+      header.Set(name, value)
+    }
+  }
+
+  timeout := time.Duration(state.config.Timeout)
+  if (timeout <= 0) {
+    timeout = lib.DefaultRESTTimeout
+  }
+  ctx, cancel := context.WithTimeout(ctx, timeout)
+  defer cancel()
+  request := lib.PrepareRequestWithProxy(requestURL, state.config.Username,
+    state.config.Password, step.method, state.config.Proxy)
+  request.Context = ctx
+  request.RequestData = body
+  request.Header = header
+  log.Debug("Running step.", "method", step.method, logger.URLKey,
+            lib.RedactURL(requestURL), "body", lib.RedactBody(body))
+  response, err := lib.DoRequest(request)
+  if (err != nil) {
+    return lib.Retryable(err)
+  }
+  defer response.Body.Close()
+  responseData, err := ioutil.ReadAll(io.LimitReader(response.Body,
+                                                 maxRESTResponse))
+  if (err != nil) {
+    return lib.Retryable(err)
+  }
+
+  if (!expectedStatus(step.expectStatus, response.StatusCode)) {
+    err = fmt.Errorf("%s %s: unexpected status %d: %s", step.method,
+                     lib.RedactURL(requestURL), response.StatusCode,
+                     lib.RedactBody(strings.TrimSpace(string(responseData))))
+    if (response.StatusCode == http.StatusTooManyRequests ||
+        response.StatusCode >= 500) {
+      return lib.Retryable(err)
+    }
+    return err
+  }
+  if (len(step.capture) == 0) {
+    return nil
+  }
+  data.Status = response.StatusCode
+  data.Response = string(responseData)
+  var decoded interface{}
+  if (json.Unmarshal(responseData, &decoded) == nil) {
+    data.Response = decoded
+  }
+  captured := map[string]string{}
+  for name, captureTemplate := range step.capture {
+    value, err := render(captureTemplate, data)
+    if (err != nil) {
+      return err
+    }
+    captured[name] = value
+  }
+  for name, value := range captured {
+    data.Vars[name] = value
+  }
+  data.Status = 0
+  data.Response = nil
+  return nil
+}
+
+// This method will parse the configuration block & its templates.
+//
+// Args:
+//    data : Configuration block, as JSON.
+// Returns:
+//    restState : Configuration with its templates.
+//    error : Error if the configuration or a template is invalid.
+func parseRESTConfig(data []byte) (*restState, error) {
+  var config schema.RESTConsumerConfig
+  if (len(data) == 0) {
+    return nil, errors.New("events are required")
+  }
+  err := json.Unmarshal(data, &config)
+  if (err != nil) {
+    return nil, err
+  }
+  if (len(config.Events) == 0) {
+    return nil, errors.New("events are required")
+  }
+  state := &restState{config: config,
+                      events: map[string][]restStep{}}
+  state.headers, err = parseTemplates("headers", config.Headers)
+  if (err != nil) {
+    return nil, err
+  }
+  for eventType, steps := range config.Events {
+    if (eventType != anyEventType && !lib.IsKnownEventType(eventType)) {
+      return nil, fmt.Errorf("events: unknown event type %q", eventType)
+    }
+    for i, step := range steps {
+      name := step.Name
+      if (name == "") {
+        name = fmt.Sprintf("%s[%d]", eventType, i)
+      }
+      parsed, err := parseStep(name, step)
+      if (err != nil) {
+        return nil, fmt.Errorf("step %s: %s", name, err)
+      }
+      state.events[eventType] = append(state.events[eventType], parsed)
+    }
+  }
+  return state, nil
+}
+
+// This method will parse the templates of a step.
+//
+// Args:
+//    name : Name of the step.
+//    step : Step configuration.
+// Returns:
+//    restStep : Step with its templates.
+//    error : Error if the step or a template is invalid.
+func parseStep(name string, step schema.RESTStep) (restStep, error) {
+  parsed := restStep{name: name, expectStatus: step.ExpectStatus,
+                     method: strings.ToUpper(step.Method)}
+  if (parsed.method == "") {
+    parsed.method = "GET"
+    if (step.Body != "") {
+      parsed.method = "POST"
+    }
+  }
+  if (step.URL == "") {
+    return parsed, errors.New("url is required")
+  }
+  var err error
+  parsed.url, err = parseTemplate("url", step.URL)
+  if (err != nil) {
+    return parsed, err
+  }
+  if (step.When != "") {
+    parsed.when, err = parseTemplate("when", step.When)
+    if (err != nil) {
+      return parsed, err
+    }
+  }
+  if (step.Body != "") {
+    parsed.body, err = parseTemplate("body", step.Body)
+    if (err != nil) {
+      return parsed, err
+    }
+  }
+  parsed.headers, err = parseTemplates("headers", step.Headers)
+  if (err != nil) {
+    return parsed, err
+  }
+  parsed.capture, err = parseTemplates("capture", step.Capture)
+  return parsed, err
+}
+
+// This method will parse a map of templates.
+//
+// Args:
+//    field : Name of the field, for the errors.
+//    texts : Templates by name.
+// Returns:
+//    map[string]*template.Template : Parsed templates by name.
+//    error : Error of the first invalid template.
+func parseTemplates(field string,
+  texts map[string]string) (map[string]*template.Template, error) {
+  templates := map[string]*template.Template{}
+  for name, text := range texts {
+    parsed, err := parseTemplate(field + "." + name, text)
+    if (err != nil) {
+      return nil, err
+    }
+    templates[name] = parsed
+  }
+  return templates, nil
+}
+
+// This method will parse a template. Missing map keys render empty.
+//
+// Args:
+//    name : Name of the template, for the errors.
+//    text : Template.
+// Returns:
+//    Template : Parsed template.
+//    error : Error if the template is invalid.
+func parseTemplate(name string, text string) (*template.Template, error) {
+  return template.New(name).Funcs(templateFuncs).
+    Option("missingkey=zero").Parse(text)
+}
+
+// This method will execute a template.
+//
+// Args:
+//    parsed : Template.
+//    data : Template data.
+// Returns:
+//    string : Rendered text.
+//    error : Error if the template fails.
+func render(parsed *template.Template, data *RESTTemplateData) (string,
+  error) {
+  var text strings.Builder
+  err := parsed.Execute(&text, data)
+  if (err != nil) {
+    return "", err
+  }
+  return text.String(), nil
+}
+
+// This method will check if a rendered condition is true.
+//
+// Args:
+//    text : Rendered condition.
+// Returns:
+//    bool : False if the condition is empty, false, 0 or no value.
+func isTrue(text string) (bool) {
+  switch strings.ToLower(strings.TrimSpace(text)) {
+  case "", "false", "0", "no", "<no value>":
+    return false
+  }
+  return true
+}
+
+// This method will check if running a step twice has the same effect as
+// running it once, as per its HTTP method.
+//
+// Args:
+//    method : HTTP method of the step.
+// Returns:
+//    bool : True for GET, HEAD, OPTIONS, PUT & DELETE.
+func idempotent(method string) (bool) {
+  switch method {
+  case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+    return true
+  }
+  return false
+}
+
+// This method will check the status of a response.
+//
+// Args:
+//    expectStatus : Expected statuses, any 2xx if empty.
+//    status : Status of the response.
+// Returns:
+//    bool : True if the status is expected.
+func expectedStatus(expectStatus []int, status int) (bool) {
+  if (len(expectStatus) == 0) {
+    return status >= 200 && status < 300
+  }
+  for _, expected := range expectStatus {
+    if (status == expected) {
+      return true
+    }
+  }
+  return false
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+//
+// This test package apply unit tests on the REST consumer.
+//
+
+package consumers
+
+import (
+  "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/schemas"
+  "fmt"
+  "io/ioutil"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+  "time"
+)
+
+// Test to verify the steps are rendered from the event, conditioned &
+// chained through the captured values.
+func TestRESTEventConsumer(t *testing.T) {
+  var calls []string
+  server := httptest.NewServer(http.HandlerFunc(
+    func(writer http.ResponseWriter, request *http.Request) {
+      body, _ := ioutil.ReadAll(request.Body)
+      calls = append(calls, fmt.Sprintf("%s %s %s %s", request.Method,
+        request.URL.Path, request.Header.Get("X-Token"), body))
+      switch request.URL.Path {
+      case "/login":
+        fmt.Fprint(writer, `{"token": "abc"}`)
+      case "/busy":
+        writer.WriteHeader(http.StatusServiceUnavailable)
+      case "/missing":
+        writer.WriteHeader(http.StatusNotFound)
+      }
+    }))
+  defer server.Close()
+
+  config := strings.NewReplacer("URL", server.URL).Replace(`{
+    "vars": {"pool": "web"},
+    "events": {
+      "VM.ON": [
+        {"name": "login", "method": "POST", "url": "URL/login",
+         "capture": {"token": "{{.Response.token}}"}},
+        {"name": "skipped", "url": "URL/skipped",
+         "when": "{{eq .Entity.Category \"db\"}}"},
+        {"name": "add", "url": "URL/pools/{{.Vars.pool}}/members",
+         "headers": {"X-Token": "{{.Vars.token}}"},
+         "body": "{\"name\": {{json .Entity.Name}}, \"address\": \"{{index .Entity.IPs 0}}\"}",
+         "expect_status": [200, 201]}
+      ],
+      "VM.OFF": [{"url": "URL/busy"}],
+      "*": [{"method": "DELETE", "url": "URL/missing"}]
+    }
+  }`)
+  restEventConsumer, err := NewRESTEventConsumer([]byte(config))
+  if (err != nil) {
+    t.Fatalf("Failed to create consumer: %s", err)
+  }
+  event := parseEvent(t, vmEvent)
+  if err = restEventConsumer.OnEvent(event); (err != nil) {
+    t.Fatalf("Expected the steps to succeed, got %s", err)
+  }
+  expected := []string{
+    "POST /login  ",
+    `POST /pools/web/members abc {"name": "web-1", "address": "10.1.1.1"}`,
+  }
+  if (strings.Join(calls, "\n") != strings.Join(expected, "\n")) {
+    t.Errorf("Unexpected calls:\n%s", strings.Join(calls, "\n"))
+  }
+
+  event.Event_Type = lib.VM_OFF
+  err = restEventConsumer.OnEvent(event)
+  if (err == nil || !lib.IsRetryable(err)) {
+    t.Errorf("Expected a retryable error for 503, got %v", err)
+  }
+  event.Event_Type = lib.VM_DELETE
+  err = restEventConsumer.OnEvent(event)
+  if (err == nil || lib.IsRetryable(err) ||
+      !strings.Contains(err.Error(), "unexpected status 404")) {
+    t.Errorf("Expected a permanent error for 404, got %v", err)
+  }
+
+  // Invalid templates are reported & the current configuration kept.
+  err = restEventConsumer.Reconfigure([]byte(
+    `{"events": {"VM.ON": [{"url": "{{.Vars.pool"}]}}`))
+  if (err == nil || !strings.Contains(err.Error(), "step VM.ON[0]")) {
+    t.Errorf("Expected a template error, got %v", err)
+  }
+}
+
+// Test to verify a failed step is retried in place once a step which is not
+// idempotent succeeded, & the event is not retried from the first step.
+func TestRESTEventConsumerRetry(t *testing.T) {
+  defer func(policy schema.RetryPolicy) {
+    stepRetryPolicy = policy
+  }(stepRetryPolicy)
+  stepRetryPolicy = schema.RetryPolicy{MaxAttempts: 3,
+                                       InitialBackoff: time.Millisecond}
+  var calls []string
+  flaky := 0
+  server := httptest.NewServer(http.HandlerFunc(
+    func(writer http.ResponseWriter, request *http.Request) {
+      calls = append(calls, request.Method + " " + request.URL.Path)
+      switch request.URL.Path {
+      case "/flaky":
+        flaky++
+        if (flaky < 3) {
+          writer.WriteHeader(http.StatusServiceUnavailable)
+        }
+      case "/busy":
+        writer.WriteHeader(http.StatusServiceUnavailable)
+      }
+    }))
+  defer server.Close()
+
+  config := strings.NewReplacer("URL", server.URL).Replace(`{
+    "events": {
+      "VM.ON": [
+        {"method": "POST", "url": "URL/members"},
+        {"method": "PUT", "url": "URL/flaky"}
+      ],
+      "VM.OFF": [
+        {"method": "POST", "url": "URL/members"},
+        {"url": "URL/busy"}
+      ]
+    }
+  }`)
+  restEventConsumer, err := NewRESTEventConsumer([]byte(config))
+  if (err != nil) {
+    t.Fatalf("Failed to create consumer: %s", err)
+  }
+  event := parseEvent(t, vmEvent)
+  if err = restEventConsumer.OnEvent(event); (err != nil) {
+    t.Fatalf("Expected the flaky step to succeed, got %s", err)
+  }
+  expected := "POST /members PUT /flaky PUT /flaky PUT /flaky"
+  if (strings.Join(calls, " ") != expected) {
+    t.Errorf("Expected calls %q, got %q", expected, strings.Join(calls, " "))
+  }
+
+  calls = nil
+  event.Event_Type = lib.VM_OFF
+  err = restEventConsumer.OnEvent(event)
+  if (err == nil || lib.IsRetryable(err)) {
+    t.Errorf("Expected a permanent error after the POST, got %v", err)
+  }
+  expected = "POST /members GET /busy GET /busy GET /busy"
+  if (strings.Join(calls, " ") != expected) {
+    t.Errorf("Expected calls %q, got %q", expected, strings.Join(calls, " "))
+  }
+}
@@ -0,0 +1,24 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+
+// This interface may optionally be implemented by the event consumer in
+// order to report its own health (e.g. whether the networking appliance is
+// reachable). The listener includes the reported health in its readiness
+// endpoint.
+//
+// Functionality provided by the interface is as follows -
+// 1. Report consumer health.
+
+package interfaces
+
+type HealthChecker interface {
+  // Interface for the consumer health check.
+
+  // This method will be invoked by the listener whenever its readiness is
+  // queried. It should return quickly.
+  //
+  // Args:
+  //    None.
+  // Returns:
+  //    error : nil when healthy, otherwise the reason for being unhealthy.
+  Health() (error)
+}
@@ -8,6 +8,7 @@
 // Functionality provided by the interface is as follows -
 // 1. Register for events by creating corresponding webhook.
 // 2. Listen for events.
+// 3. Unregister from events, deleting the webhook once no event remains.
 
 package interfaces
 
@@ -36,10 +37,12 @@ type Listener interface {
   //
   // Args:
   //    events : The list of events that the caller is interested in.
-  //             Must be one of:
+  //             Must be registered in lib, by default one of:
   //             [
   //               "VM.CREATE", "VM.DELETE", "VM.ON", "VM.OFF", "VM.UPDATE",
-  //               "VM.MIGRATE", "VM.NIC_PLUG", "VM.NIC_UNPLUG"
+  //               "VM.MIGRATE", "VM.NIC_PLUG", "VM.NIC_UNPLUG",
+  //               "SUBNET.CREATE", "SUBNET.UPDATE", "SUBNET.DELETE",
+  //               "HOST.CREATE", "HOST.UPDATE", "HOST.DELETE", "CLUSTER.UPDATE"
   //             ]
   //    eventConsumer :
   //             Interface reference to the event consumer. This interface will
@@ -49,4 +52,23 @@ type Listener interface {
   // Returns:
   //    error : Error, if any.
   RegisterForEvents(events []string, eventConsumer EventConsumer) (error)
+
+  // This method allows the event consumer to unsubscribe from some of its
+  // events. The webhook is deleted when no event remains.
+  //
+  // Args:
+  //    events : The list of events that the caller is no longer interested
+  //             in.
+  // Returns:
+  //    error : Error, if any.
+  UnregisterForEvents(events []string) (error)
+
+  // This method allows the event consumer to replace the events it is
+  // subscribed to.
+  //
+  // Args:
+  //    events : The list of events that the caller is interested in.
+  // Returns:
+  //    error : Error, if any.
+  UpdateSubscriptions(events []string) (error)
 }
@@ -0,0 +1,25 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+
+// This interface may optionally be implemented by the event consumer in
+// order to take a new configuration block without a restart, when the
+// listener configuration file is reloaded.
+//
+// Functionality provided by the interface is as follows -
+// 1. Validate & apply a new consumer configuration.
+
+package interfaces
+
+type Reconfigurable interface {
+  // Interface for the consumer configuration reload.
+
+  // This method will be invoked by the listener with the configuration
+  // block of the consumer. The consumer must apply the new configuration
+  // atomically, or keep its current configuration & return an error if the
+  // block is invalid.
+  //
+  // Args:
+  //    config : Configuration block of the consumer, as JSON.
+  // Returns:
+  //    error : Error if the configuration is invalid.
+  Reconfigure(config []byte) (error)
+}
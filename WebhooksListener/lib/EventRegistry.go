@@ -0,0 +1,328 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+
+// Registry of the event types the listener can subscribe to, with the
+// entity kind & the minimum AOS version of each event type, and a payload
+// decoder per kind. The VM, subnet, host & cluster events are registered by
+// default; newer event types & kinds are added through RegisterEventType &
+// RegisterKindDecoder.
+//
+// The decoded payload is set on the event for the consumers. Events of a
+// kind without decoder carry their data object as raw JSON.
+
+package lib
+
+import (
+  "encoding/json"
+  "errors"
+  "fmt"
+  "aplos/partners/WebhooksListener/schemas"
+  "sort"
+  "strconv"
+  "strings"
+  "sync"
+)
+
+// Entity kinds of the events.
+const (
+  VMKind = "vm"
+  SubnetKind = "subnet"
+  HostKind = "host"
+  ClusterKind = "cluster"
+)
+
+// Non-VM events.
+const (
+  SUBNET_CREATE = "SUBNET.CREATE"
+  SUBNET_UPDATE = "SUBNET.UPDATE"
+  SUBNET_DELETE = "SUBNET.DELETE"
+  HOST_CREATE = "HOST.CREATE"
+  HOST_UPDATE = "HOST.UPDATE"
+  HOST_DELETE = "HOST.DELETE"
+  CLUSTER_UPDATE = "CLUSTER.UPDATE"
+)
+
+// Decoder of the payload of an entity kind. It receives the data object of
+// the event & returns the typed payload.
+type EventDecoder func(data json.RawMessage) (interface{}, error)
+
+type eventTypeInfo struct {
+  // Registration of an event type.
+  kind string
+  minVersion string
+}
+
+var (
+  registryLock sync.RWMutex
+  eventKinds = map[string]eventTypeInfo{}
+  kindDecoders = map[string]EventDecoder{}
+)
+
+func init() {
+  // The v3 webhooks & the VM events came with AOS 5.5, the NIC events with
+  // AOS 5.10, the events of the other entities with AOS 5.20.
+  for _, eventType := range []string{VM_CREATE, VM_DELETE, VM_ON, VM_OFF,
+    VM_UPDATE, VM_MIGRATE} {
+    RegisterEventTypeSince(eventType, VMKind, "5.5")
+  }
+  RegisterEventTypeSince(VM_NIC_PLUG, VMKind, "5.10")
+  RegisterEventTypeSince(VM_NIC_UNPLUG, VMKind, "5.10")
+  for _, eventType := range []string{SUBNET_CREATE, SUBNET_UPDATE,
+    SUBNET_DELETE} {
+    RegisterEventTypeSince(eventType, SubnetKind, "5.20")
+  }
+  for _, eventType := range []string{HOST_CREATE, HOST_UPDATE, HOST_DELETE} {
+    RegisterEventTypeSince(eventType, HostKind, "5.20")
+  }
+  RegisterEventTypeSince(CLUSTER_UPDATE, ClusterKind, "5.20")
+
+  RegisterKindDecoder(VMKind, metadataDecoder(func() interface{} {
+    return &schema.EventMetadata{}
+  }))
+  RegisterKindDecoder(SubnetKind, metadataDecoder(func() interface{} {
+    return &schema.SubnetMetadata{}
+  }))
+  RegisterKindDecoder(HostKind, metadataDecoder(func() interface{} {
+    return &schema.HostMetadata{}
+  }))
+  RegisterKindDecoder(ClusterKind, metadataDecoder(func() interface{} {
+    return &schema.ClusterMetadata{}
+  }))
+}
+
+// This method will register an event type & the entity kind it reports on.
+//
+// Args:
+//    eventType : Event type, e.g. "SUBNET.CREATE".
+//    kind : Entity kind of the event, e.g. "subnet".
+// Returns:
+//    None.
+func RegisterEventType(eventType string, kind string) {
+  RegisterEventTypeSince(eventType, kind, "")
+}
+
+// This method will register an event type, the entity kind it reports on &
+// the first AOS version emitting it.
+//
+// Args:
+//    eventType : Event type, e.g. "SUBNET.CREATE".
+//    kind : Entity kind of the event, e.g. "subnet".
+//    minVersion : Minimum AOS version, e.g. "5.20", empty if any.
+// Returns:
+//    None.
+func RegisterEventTypeSince(eventType string, kind string,
+  minVersion string) {
+  registryLock.Lock()
+  defer registryLock.Unlock()
+  eventKinds[eventType] = eventTypeInfo{kind: kind, minVersion: minVersion}
+}
+
+// This method will register the payload decoder of an entity kind,
+// replacing the existing one.
+//
+// Args:
+//    kind : Entity kind, e.g. "subnet".
+//    decoder : Decoder of the payload of the kind.
+// Returns:
+//    None.
+func RegisterKindDecoder(kind string, decoder EventDecoder) {
+  registryLock.Lock()
+  defer registryLock.Unlock()
+  kindDecoders[kind] = decoder
+}
+
+// This method will check if the event type is registered.
+//
+// Args:
+//    eventType : Event type.
+// Returns:
+//    bool : True if the event type is registered.
+func IsKnownEventType(eventType string) (bool) {
+  registryLock.RLock()
+  defer registryLock.RUnlock()
+  _, ok := eventKinds[eventType]
+  return ok
+}
+
+// This method will return the registered event types, sorted.
+//
+// Args:
+//    None.
+// Returns:
+//    []string : Registered event types.
+func EventTypes() ([]string) {
+  registryLock.RLock()
+  defer registryLock.RUnlock()
+  eventTypes := make([]string, 0, len(eventKinds))
+  for eventType := range eventKinds {
+    eventTypes = append(eventTypes, eventType)
+  }
+  sort.Strings(eventTypes)
+  return eventTypes
+}
+
+// This method will check that the event types are registered & emitted by
+// the given AOS version.
+//
+// Args:
+//    eventTypes : Event types to subscribe to.
+//    version : AOS version of the cluster, empty if unknown in which case
+//              the version is not checked. So are versions not starting
+//              with a number, e.g. "pc.2022.6" on Prism Central.
+// Returns:
+//    error : Error naming the first unknown or unsupported event type.
+func ValidateEventTypes(eventTypes []string, version string) (error) {
+  if (len(eventTypes) == 0) {
+    return errors.New("no event types given")
+  }
+  if (len(versionParts(version)) == 0) {
+    version = ""
+  }
+  registryLock.RLock()
+  defer registryLock.RUnlock()
+  for _, eventType := range eventTypes {
+    info, ok := eventKinds[eventType]
+    if (!ok) {
+      known := make([]string, 0, len(eventKinds))
+      for knownType := range eventKinds {
+        known = append(known, knownType)
+      }
+      sort.Strings(known)
+      return fmt.Errorf("unknown event type %q, must be one of: %s",
+                        eventType, strings.Join(known, ", "))
+    }
+    if (version != "" && info.minVersion != "" &&
+        CompareVersions(version, info.minVersion) < 0) {
+      return fmt.Errorf("event type %s requires AOS %s or later, the " +
+                        "cluster runs AOS %s", eventType, info.minVersion,
+                        version)
+    }
+  }
+  return nil
+}
+
+// This method will compare two dotted versions, e.g. "5.10.2" & "5.5".
+// Trailing non-numeric parts of the version, e.g. "-LTS", are ignored.
+//
+// Args:
+//    a : First version.
+//    b : Second version.
+// Returns:
+//    int : -1 if a < b, 0 if a == b, 1 if a > b.
+func CompareVersions(a string, b string) (int) {
+  partsA := versionParts(a)
+  partsB := versionParts(b)
+  for i := 0; i < len(partsA) || i < len(partsB); i++ {
+    var partA, partB int
+    if (i < len(partsA)) {
+      partA = partsA[i]
+    }
+    if (i < len(partsB)) {
+      partB = partsB[i]
+    }
+    if (partA != partB) {
+      if (partA < partB) {
+        return -1
+      }
+      return 1
+    }
+  }
+  return 0
+}
+
+// This method will return the numeric parts of a dotted version.
+//
+// Args:
+//    version : Dotted version.
+// Returns:
+//    []int : Numeric parts, up to the first non-numeric one.
+func versionParts(version string) ([]int) {
+  var parts []int
+  for _, field := range strings.Split(version, ".") {
+    digits := 0
+    for digits < len(field) && field[digits] >= '0' && field[digits] <= '9' {
+      digits++
+    }
+    if (digits == 0) {
+      break
+    }
+    part, _ := strconv.Atoi(field[:digits])
+    parts = append(parts, part)
+    if (digits < len(field)) {
+      break
+    }
+  }
+  return parts
+}
+
+// This method will return the entity kind of an event: the kind of its
+// entity reference, else the registered kind of its event type, else the
+// lowercase prefix of its event type.
+//
+// Args:
+//    event : Event object.
+// Returns:
+//    string : Entity kind of the event.
+func EventKind(event schema.Event) (string) {
+  if (event.EntityReference.KIND != "") {
+    return strings.ToLower(event.EntityReference.KIND)
+  }
+  registryLock.RLock()
+  info, ok := eventKinds[event.Event_Type]
+  registryLock.RUnlock()
+  if (ok && info.kind != "") {
+    return info.kind
+  }
+  return strings.ToLower(strings.SplitN(event.Event_Type, ".", 2)[0])
+}
+
+// This method will decode the payload of the event with the decoder of its
+// entity kind & set it on the event. For a kind without decoder, the
+// payload is the data object of the event as raw JSON.
+//
+// Args:
+//    event : Event object, unmarshalled from the event JSON.
+// Returns:
+//    error : Error, if the payload cannot be decoded.
+func DecodePayload(event *schema.Event) (error) {
+  var envelope struct {
+    Data json.RawMessage `json:"data"`
+  }
+  if (len(event.Raw) > 0) {
+    err := json.Unmarshal(event.Raw, &envelope)
+    if (err != nil) {
+      return err
+    }
+  }
+  kind := EventKind(*event)
+  registryLock.RLock()
+  decoder, ok := kindDecoders[kind]
+  registryLock.RUnlock()
+  if (!ok || len(envelope.Data) == 0) {
+    event.Payload = envelope.Data
+    return nil
+  }
+  payload, err := decoder(envelope.Data)
+  if (err != nil) {
+    return fmt.Errorf("failed to decode %s payload: %s", kind, err)
+  }
+  event.Payload = payload
+  return nil
+}
+
+// This method will return a decoder unmarshalling the metadata object of
+// the event data into the value returned by newPayload.
+//
+// Args:
+//    newPayload : Function returning a pointer to a new payload.
+// Returns:
+//    EventDecoder : Payload decoder.
+func metadataDecoder(newPayload func() interface{}) (EventDecoder) {
+  return func(data json.RawMessage) (interface{}, error) {
+    payload := newPayload()
+    envelope := struct {
+      Metadata interface{} `json:"metadata"`
+    }{Metadata: payload}
+    err := json.Unmarshal(data, &envelope)
+    return payload, err
+  }
+}
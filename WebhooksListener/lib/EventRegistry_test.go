@@ -0,0 +1,131 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+//
+// This test package apply unit tests on the event type registry & payload
+// decoders.
+//
+
+package lib
+
+import (
+  "encoding/json"
+  "aplos/partners/WebhooksListener/schemas"
+  "testing"
+)
+
+// This method will unmarshal the event JSON & decode its payload.
+func decodeEvent(t *testing.T, eventJSON string) (schema.Event) {
+  var event schema.Event
+  err := json.Unmarshal([]byte(eventJSON), &event)
+  if (err == nil) {
+    err = DecodePayload(&event)
+  }
+  if (err != nil) {
+    t.Fatalf("Failed to decode event %s: %s", eventJSON, err)
+  }
+  return event
+}
+
+// This method will restore the event type registry at the end of the test,
+// for the types registered by the test not to leak into the other tests.
+func restoreRegistry(t *testing.T) {
+  registryLock.Lock()
+  defer registryLock.Unlock()
+  savedKinds := map[string]eventTypeInfo{}
+  for eventType, info := range eventKinds {
+    savedKinds[eventType] = info
+  }
+  savedDecoders := map[string]EventDecoder{}
+  for kind, decoder := range kindDecoders {
+    savedDecoders[kind] = decoder
+  }
+  t.Cleanup(func() {
+    registryLock.Lock()
+    defer registryLock.Unlock()
+    eventKinds = savedKinds
+    kindDecoders = savedDecoders
+  })
+}
+
+// Test to verify the typed payloads of the registered kinds.
+func TestDecodePayload(t *testing.T) {
+  event := decodeEvent(t, `{"event_type": "VM.ON",
+    "entity_reference": {"kind": "vm", "uuid": "vm-1"},
+    "data": {"metadata": {"status": {"name": "web-1"}}}}`)
+  vm, ok := event.Payload.(*schema.EventMetadata)
+  if (!ok || vm.Status.Name != "web-1") {
+    t.Errorf("Unexpected VM payload %#v", event.Payload)
+  }
+
+  // The subnet resources do not fit the VM view of the data.
+  event = decodeEvent(t, `{"event_type": "SUBNET.UPDATE",
+    "entity_reference": {"kind": "subnet", "uuid": "s-1"},
+    "data": {"metadata": {"status": {"name": "vlan10",
+      "resources": {"vlan_id": 10, "nic_list": "none"}}}}}`)
+  subnet, ok := event.Payload.(*schema.SubnetMetadata)
+  if (!ok || subnet.Status.Resources.VLANID != 10) {
+    t.Errorf("Unexpected subnet payload %#v", event.Payload)
+  }
+}
+
+// Test to verify unknown kinds & the registration of new event types.
+func TestRegisterEventType(t *testing.T) {
+  restoreRegistry(t)
+  event := decodeEvent(t, `{"event_type": "VPC.CREATE",
+    "entity_reference": {"kind": "vpc", "uuid": "vpc-1"},
+    "data": {"metadata": {"spec": {"name": "vpc1"}}}}`)
+  raw, ok := event.Payload.(json.RawMessage)
+  if (!ok || len(raw) == 0) {
+    t.Errorf("Expected raw payload, got %#v", event.Payload)
+  }
+  if (IsKnownEventType("VPC.CREATE")) {
+    t.Errorf("Expected VPC.CREATE to be unknown")
+  }
+
+  type vpc struct {
+    Metadata struct {
+      Spec struct {
+        Name string `json:"name"`
+      } `json:"spec"`
+    } `json:"metadata"`
+  }
+  RegisterEventType("VPC.CREATE", "vpc")
+  RegisterKindDecoder("vpc", func(data json.RawMessage) (interface{}, error) {
+    var payload vpc
+    err := json.Unmarshal(data, &payload)
+    return payload, err
+  })
+  event = decodeEvent(t, `{"event_type": "VPC.CREATE",
+    "data": {"metadata": {"spec": {"name": "vpc1"}}}}`)
+  payload, ok := event.Payload.(vpc)
+  if (!IsKnownEventType("VPC.CREATE") || !ok ||
+      payload.Metadata.Spec.Name != "vpc1") {
+    t.Errorf("Unexpected registered payload %#v", event.Payload)
+  }
+}
+
+// Test to verify the event types are checked against the registry & the
+// AOS version of the cluster.
+func TestValidateEventTypes(t *testing.T) {
+  tests := []struct {
+    events []string
+    version string
+    valid bool
+  }{
+    {[]string{VM_ON, VM_OFF}, "5.5", true},
+    {[]string{VM_ON, VM_OFF}, "", true},
+    {[]string{VM_ON, SUBNET_CREATE}, "pc.2022.6", true},
+    {[]string{"VM.POWER_ON"}, "6.5", false},
+    {[]string{}, "6.5", false},
+    {[]string{VM_NIC_PLUG}, "5.9.1", false},
+    {[]string{VM_NIC_PLUG}, "5.10.2-LTS", true},
+    {[]string{SUBNET_CREATE}, "5.15", false},
+    {[]string{SUBNET_CREATE}, "6.5.1", true},
+  }
+  for _, test := range tests {
+    err := ValidateEventTypes(test.events, test.version)
+    if ((err == nil) != test.valid) {
+      t.Errorf("Unexpected validation of %v on %q: %v", test.events,
+        test.version, err)
+    }
+  }
+}
@@ -4,6 +4,8 @@
 
 package lib
 
+import "time"
+
 const (
   // Webhook URLs
   CreateWebhook = "/api/nutanix/v3/webhooks"
@@ -15,12 +17,35 @@ const (
   // Auth Check URL
   GetCurrentUser = "/api/nutanix/v3/users/me"
 
+  // Cluster details URL, reporting the AOS version.
+  GetClusterInfo = "/PrismGateway/services/rest/v2.0/cluster/"
+
   // Listener Defaults
   DefaultListenerPort = "8080"
+  DefaultPrismPort = "9440"
   ListenerCallbackURL = "/listener/callback"
-  EventConsumerCallbackMethod = "OnEvent"
+  MetricsURL = "/metrics"
+  HealthzURL = "/healthz"
+  ReadyzURL = "/readyz"
   WebhookNamePrefix = "Nutanix_Listener_Webhook_"
+  WebhookDescriptionPrefix = "Managed by the Nutanix webhooks listener."
+  // Directory of the listener ID files, one per listener port or socket.
+  DefaultListenerStateDir = "/var/lib/nutanix_listener"
+  ListenerClosedState = "Listener Closed" // Last ListenerState message.
   WebhookKind = "webhook"
+  WebhookListPageSize = 100
+
+  // Proxy URL value that bypasses any proxy, including the environment one.
+  ProxyDirect = "direct"
+
+  // Subscription modes. In the merge mode, the webhook gets the union of its
+  // events & the registered ones. In the replace mode, exactly the
+  // registered ones.
+  SubscriptionMerge = "merge"
+  SubscriptionReplace = "replace"
+
+  // Category assigning a VM to the networking appliance serving it.
+  NetworkFunctionProviderCategory = "network_function_provider"
 
   // Events (Can be taken from the YAML config later)
   VM_CREATE = "VM.CREATE"
@@ -32,3 +57,57 @@ const (
   VM_NIC_PLUG = "VM.NIC_PLUG"
   VM_NIC_UNPLUG = "VM.NIC_UNPLUG"
 )
+
+const (
+  // Interval at which the listener refreshes its webhook state from Prism.
+  DefaultWatchdogInterval = 60 * time.Second
+
+  // Time a failed Prism endpoint is tried last, before being retried.
+  EndpointRetryInterval = 30 * time.Second
+
+  // Interval at which the listener configuration file is checked for
+  // changes, to be reloaded.
+  ConfigPollInterval = 5 * time.Second
+
+  // Timeout of the calls to an out of process consumer.
+  DefaultPluginCallTimeout = 30 * time.Second
+
+  // Backoff between the restarts of an out of process consumer that
+  // exited, doubled up to the maximum while it keeps exiting.
+  PluginRestartBackoff = 1 * time.Second
+  MaxPluginRestartBackoff = 30 * time.Second
+
+  // Timeout of a command run by the exec consumer, & its exit code for a
+  // retryable failure (EX_TEMPFAIL).
+  DefaultExecTimeout = 30 * time.Second
+  ExecRetryableExitCode = 75
+
+  // Timeout of an HTTP call of the REST consumer.
+  DefaultRESTTimeout = 30 * time.Second
+
+  // Default retry policy of the event dispatch to the consumers.
+  DefaultMaxAttempts = 3
+  DefaultInitialBackoff = 2 * time.Second
+  DefaultMaxBackoff = 30 * time.Second
+
+  // Number of received events buffered for dispatch. Events received while
+  // the buffer is full are rejected with 503 for Prism to retry.
+  DefaultQueueSize = 100
+
+  // Maximum size in bytes of a received event.
+  DefaultMaxEventSize = 1 << 20
+
+  // Default limits of the callback HTTP server.
+  DefaultReadHeaderTimeout = 10 * time.Second
+  DefaultReadTimeout = 30 * time.Second
+  DefaultWriteTimeout = 30 * time.Second
+  DefaultIdleTimeout = 120 * time.Second
+  DefaultMaxHeaderBytes = 64 << 10
+  DefaultMaxConnections = 256
+
+  // Timeout of the reachability probe of a webhook post_url.
+  DefaultProbeTimeout = 5 * time.Second
+
+  // Header carrying the token of a callback reachability probe.
+  ProbeHeader = "X-Nutanix-Listener-Probe"
+)
@@ -0,0 +1,93 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+
+// Error types shared by the listener & event consumers. An event consumer
+// returns a retryable error (see Retryable) when handling the event again
+// later may succeed, e.g. when the networking appliance is unreachable. All
+// other errors are reported without retrying.
+
+package lib
+
+import (
+  "errors"
+  "fmt"
+)
+
+type RetryableError struct {
+  // Error of a failure that may succeed when retried.
+  Err error
+}
+
+func (retryableError *RetryableError) Error() (string) {
+  return retryableError.Err.Error()
+}
+
+func (retryableError *RetryableError) Unwrap() (error) {
+  return retryableError.Err
+}
+
+func (retryableError *RetryableError) Retryable() (bool) {
+  return true
+}
+
+type PanicError struct {
+  // Error reported when an event consumer panics while handling an event.
+  Value interface{}
+  Stack []byte
+}
+
+func (panicError *PanicError) Error() (string) {
+  return fmt.Sprintf("event consumer panicked: %v", panicError.Value)
+}
+
+// A panic is usually caused by unexpected event data, but may also be a
+// transient condition, so the event is retried.
+func (panicError *PanicError) Retryable() (bool) {
+  return true
+}
+
+type ReachabilityError struct {
+  // Error reported when the callback URL cannot be reached by the probe.
+  URL string
+  Probe string // "loopback" or "external".
+  Err error
+}
+
+func (reachabilityError *ReachabilityError) Error() (string) {
+  return fmt.Sprintf("callback URL %s is not reachable (%s probe): %s. " +
+    "Check that the listener port is open in the firewall & that the URL " +
+    "is the address Prism reaches the listener at (see AdvertiseURL)",
+    reachabilityError.URL, reachabilityError.Probe, reachabilityError.Err)
+}
+
+func (reachabilityError *ReachabilityError) Unwrap() (error) {
+  return reachabilityError.Err
+}
+
+// This method will mark the given error as retryable.
+//
+// Args:
+//    err : Error to mark.
+// Returns:
+//    error : Retryable error, nil if err is nil.
+func Retryable(err error) (error) {
+  if (err == nil) {
+    return nil
+  }
+  return &RetryableError{Err: err}
+}
+
+// This method will check if the failure reported by the given error may
+// succeed when retried. Errors providing a Retryable() bool method anywhere
+// in their chain are honored.
+//
+// Args:
+//    err : Error to check.
+// Returns:
+//    bool : True if the error is retryable.
+func IsRetryable(err error) (bool) {
+  var retryable interface{ Retryable() bool }
+  if (errors.As(err, &retryable)) {
+    return retryable.Retryable()
+  }
+  return false
+}
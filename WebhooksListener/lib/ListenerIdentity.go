@@ -0,0 +1,142 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+
+// Persistent identity of a listener. The listener ID is generated once &
+// stored on disk, so that the listener finds its webhook again after a
+// restart on another address. The ID is carried in the webhook name and
+// description.
+
+package lib
+
+import (
+  "crypto/rand"
+  "errors"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "regexp"
+  "strings"
+)
+
+var (
+  // Listener ID, & the listener ID in the webhook description.
+  validListenerID = regexp.MustCompile(`^[0-9A-Za-z_.-]+$`)
+  listenerIDPattern = regexp.MustCompile(`listener_id=([0-9A-Za-z_.-]+)`)
+  // Characters replaced in the key of a listener ID file name.
+  invalidFileNameChars = regexp.MustCompile(`[^0-9A-Za-z_.-]`)
+)
+
+// This method will return the default file storing the ID of a listener,
+// in the listener state directory. The file is named after the port or the
+// Unix socket of the listener, so listeners of the same host keep distinct
+// IDs whatever their working directory.
+//
+// Args:
+//    key : Port or Unix socket path of the listener.
+// Returns:
+//    string : Path of the listener ID file.
+func DefaultListenerIDFile(key string) (string) {
+  return filepath.Join(DefaultListenerStateDir, "listener_id_" +
+                       invalidFileNameChars.ReplaceAllString(key, "_"))
+}
+
+// This method will return the listener ID stored in the given file, or
+// generate one & store it if the file does not exist.
+//
+// Args:
+//    path : File storing the listener ID.
+// Returns:
+//    string : Listener ID.
+//    error : Error, if any.
+func LoadOrCreateListenerID(path string) (string, error) {
+  content, err := ioutil.ReadFile(path)
+  if (err == nil) {
+    listenerID := strings.TrimSpace(string(content))
+    if (!ValidListenerID(listenerID)) {
+      return "", fmt.Errorf("invalid listener ID %q in %s", listenerID, path)
+    }
+    return listenerID, nil
+  }
+  if (!os.IsNotExist(err)) {
+    return "", err
+  }
+
+  listenerID, err := NewListenerID()
+  if (err != nil) {
+    return "", err
+  }
+  err = os.MkdirAll(filepath.Dir(path), 0700)
+  if (err == nil) {
+    err = ioutil.WriteFile(path, []byte(listenerID + "\n"), 0600)
+  }
+  if (err != nil) {
+    return "", fmt.Errorf("failed to store listener ID in %s: %s", path, err)
+  }
+  return listenerID, nil
+}
+
+// This method will generate a random listener ID, in the UUID format.
+//
+// Args:
+//    None.
+// Returns:
+//    string : Listener ID.
+//    error : Error, if any.
+func NewListenerID() (string, error) {
+  var uuid [16]byte
+  _, err := rand.Read(uuid[:])
+  if (err != nil) {
+    return "", err
+  }
+  uuid[6] = (uuid[6] & 0x0f) | 0x40
+  uuid[8] = (uuid[8] & 0x3f) | 0x80
+  return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8],
+                     uuid[8:10], uuid[10:]), nil
+}
+
+// This method will check that a listener ID can be carried in the webhook
+// name & description.
+//
+// Args:
+//    listenerID : Listener ID.
+// Returns:
+//    bool : True if the listener ID is valid.
+func ValidListenerID(listenerID string) (bool) {
+  return validListenerID.MatchString(listenerID)
+}
+
+// This method will return the webhook name of the listener.
+//
+// Args:
+//    listenerID : Listener ID.
+// Returns:
+//    string : Webhook name.
+func WebhookName(listenerID string) (string) {
+  return WebhookNamePrefix + listenerID
+}
+
+// This method will return the webhook description of the listener.
+//
+// Args:
+//    listenerID : Listener ID.
+// Returns:
+//    string : Webhook description.
+func WebhookDescription(listenerID string) (string) {
+  return fmt.Sprintf("%s listener_id=%s", WebhookDescriptionPrefix,
+                     listenerID)
+}
+
+// This method will return the listener ID carried in a webhook description.
+//
+// Args:
+//    description : Webhook description.
+// Returns:
+//    string : Listener ID.
+//    error : Error if the description carries no listener ID.
+func ListenerIDFromDescription(description string) (string, error) {
+  match := listenerIDPattern.FindStringSubmatch(description)
+  if (match == nil) {
+    return "", errors.New("no listener ID in webhook description")
+  }
+  return match[1], nil
+}
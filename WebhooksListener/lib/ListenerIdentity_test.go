@@ -0,0 +1,58 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+//
+// This test package apply unit tests on the persistent listener identity.
+//
+
+package lib
+
+import (
+  "io/ioutil"
+  "path/filepath"
+  "testing"
+)
+
+// Test to verify the listener ID is generated once & kept on disk.
+func TestLoadOrCreateListenerID(t *testing.T) {
+  path := filepath.Join(t.TempDir(), "state", "listener_id")
+  listenerID, err := LoadOrCreateListenerID(path)
+  if (err != nil || !ValidListenerID(listenerID)) {
+    t.Fatalf("Failed to create listener ID %q: %v", listenerID, err)
+  }
+  reloaded, err := LoadOrCreateListenerID(path)
+  if (err != nil || reloaded != listenerID) {
+    t.Errorf("Expected listener ID %s, got %s: %v", listenerID, reloaded, err)
+  }
+
+  ioutil.WriteFile(path, []byte("not a valid id\n"), 0600)
+  _, err = LoadOrCreateListenerID(path)
+  if (err == nil) {
+    t.Errorf("Expected invalid listener ID to be rejected")
+  }
+}
+
+// Test to verify the default listener ID files are absolute & distinct per
+// port or socket.
+func TestDefaultListenerIDFile(t *testing.T) {
+  tests := map[string]string{
+    "8080": "/var/lib/nutanix_listener/listener_id_8080",
+    "/run/listener.sock":
+      "/var/lib/nutanix_listener/listener_id__run_listener.sock",
+  }
+  for key, expected := range tests {
+    if path := DefaultListenerIDFile(key); (path != expected) {
+      t.Errorf("Expected %s for %s, got %s", expected, key, path)
+    }
+  }
+}
+
+// Test to verify the listener ID is found in the webhook description.
+func TestListenerIDFromDescription(t *testing.T) {
+  listenerID, err := ListenerIDFromDescription(WebhookDescription("abc-1"))
+  if (err != nil || listenerID != "abc-1") {
+    t.Errorf("Unexpected listener ID %q: %v", listenerID, err)
+  }
+  _, err = ListenerIDFromDescription("Created by hand")
+  if (err == nil) {
+    t.Errorf("Expected no listener ID in description")
+  }
+}
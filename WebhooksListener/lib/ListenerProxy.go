@@ -0,0 +1,84 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+
+// Outbound HTTP proxy support for the requests made by the listener &
+// event consumer.
+
+package lib
+
+import (
+  "crypto/tls"
+  "fmt"
+  "golang.org/x/net/http/httpproxy"
+  "aplos/partners/WebhooksListener/schemas"
+  "net/http"
+  "net/url"
+)
+
+// This method will build the HTTP transport used for requests to a target,
+// routed through the given proxy configuration.
+//
+// Args:
+//    proxy : Proxy configuration of the request target.
+// Returns:
+//    Transport : HTTP transport for the target.
+func NewTransport(proxy schema.ProxyConfig) (*http.Transport) {
+  return &http.Transport{
+    TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+    Proxy: ProxyFunc(proxy),
+  }
+}
+
+// This method will return the proxy selection function for the given proxy
+// configuration, suitable for http.Transport.
+//
+// Args:
+//    proxy : Proxy configuration of the request target.
+// Returns:
+//    func : Function returning the proxy URL to use for a request, or nil
+//           for a direct connection.
+func ProxyFunc(proxy schema.ProxyConfig) (
+  func(*http.Request) (*url.URL, error)) {
+  return func(request *http.Request) (*url.URL, error) {
+    return ResolveProxy(proxy, request.URL.String())
+  }
+}
+
+// This method will resolve the proxy to be used for the given target URL.
+// An empty proxy URL falls back to the HTTPS_PROXY, HTTP_PROXY and NO_PROXY
+// environment variables, while ProxyDirect disables proxying for the target.
+//
+// Args:
+//    proxy : Proxy configuration of the request target.
+//    targetURL : URL of the request target.
+// Returns:
+//    URL : Proxy URL including credentials, nil for a direct connection.
+//    error : Error, if any.
+func ResolveProxy(proxy schema.ProxyConfig,
+  targetURL string) (*url.URL, error) {
+  if (proxy.URL == ProxyDirect) {
+    return nil, nil
+  }
+  target, err := url.Parse(targetURL)
+  if (err != nil) {
+    return nil, err
+  }
+
+  proxyConfig := httpproxy.FromEnvironment()
+  if (proxy.URL != "") {
+    proxyConfig.HTTPProxy = proxy.URL
+    proxyConfig.HTTPSProxy = proxy.URL
+  }
+  if (proxy.NoProxy != "") {
+    proxyConfig.NoProxy = proxy.NoProxy
+  }
+  proxyURL, err := proxyConfig.ProxyFunc()(target)
+  if (err != nil) {
+    return nil, fmt.Errorf("invalid proxy configuration: %s", err)
+  }
+  if (proxyURL != nil && proxy.Username != "") {
+    // Go's transport sends the URL user info as Proxy-Authorization, also
+    // on the CONNECT request for HTTPS targets.
+    proxyURL.User = url.UserPassword(proxy.Username, proxy.Password)
+  }
+  return proxyURL, nil
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+//
+// This test package apply unit tests on the proxy resolution provided by
+// listener library.
+//
+
+package lib
+
+import (
+  "aplos/partners/WebhooksListener/schemas"
+  "testing"
+)
+
+// Test to verify configured proxy with credentials & no_proxy exclusions.
+func TestResolveProxy(t *testing.T) {
+  t.Setenv("HTTPS_PROXY", "")
+  t.Setenv("NO_PROXY", "")
+  proxy := schema.ProxyConfig{
+    URL: "http://proxy.example.com:3128",
+    Username: "user",
+    Password: "secret",
+    NoProxy: "10.0.0.0/8,prism.example.com",
+  }
+  proxyURL, err := ResolveProxy(proxy, "https://firewall.example.com/api/")
+  if (err != nil || proxyURL == nil) {
+    t.Fatalf("Failed to resolve proxy. URL: %v, Error: %v", proxyURL, err)
+  }
+  if (proxyURL.Host != "proxy.example.com:3128") {
+    t.Errorf("Unexpected proxy host: %s", proxyURL.Host)
+  }
+  password, _ := proxyURL.User.Password()
+  if (proxyURL.User.Username() != "user" || password != "secret") {
+    t.Errorf("Proxy credentials not set: %v", proxyURL.User)
+  }
+  for _, target := range []string{"https://10.1.2.3:9440/",
+                                  "https://prism.example.com:9440/"} {
+    proxyURL, err = ResolveProxy(proxy, target)
+    if (err != nil || proxyURL != nil) {
+      t.Errorf("Expected direct connection for %s, got %v (%v)", target,
+        proxyURL, err)
+    }
+  }
+}
+
+// Test to verify the environment proxy is honored & can be bypassed.
+func TestResolveProxyEnvironment(t *testing.T) {
+  t.Setenv("HTTPS_PROXY", "http://envproxy.example.com:8080")
+  t.Setenv("NO_PROXY", "")
+  proxyURL, err := ResolveProxy(schema.ProxyConfig{},
+                                "https://firewall.example.com/api/")
+  if (err != nil || proxyURL == nil ||
+      proxyURL.Host != "envproxy.example.com:8080") {
+    t.Errorf("Environment proxy not honored. URL: %v, Error: %v",
+      proxyURL, err)
+  }
+  proxyURL, err = ResolveProxy(schema.ProxyConfig{URL: ProxyDirect},
+                               "https://firewall.example.com/api/")
+  if (err != nil || proxyURL != nil) {
+    t.Errorf("Direct proxy setting not honored. URL: %v", proxyURL)
+  }
+}
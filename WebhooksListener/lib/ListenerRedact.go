@@ -0,0 +1,237 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+
+// Redaction of secrets (credentials, API keys, tokens) from URLs, headers
+// and payloads before they are written to the logs.
+
+package lib
+
+import (
+  "encoding/json"
+  "errors"
+  "net/http"
+  "net/url"
+  "regexp"
+  "strings"
+  "sync"
+)
+
+// Replacement text for redacted values.
+const RedactedValue = "*****"
+
+var (
+  redactLock sync.RWMutex
+
+  // Query parameters carrying secrets, e.g. the PAN-OS keygen password and
+  // the API key of subsequent calls.
+  secretParams = map[string]bool{
+    "password": true, "passwd": true, "key": true, "api_key": true,
+    "apikey": true, "token": true, "access_token": true, "secret": true,
+  }
+
+  // HTTP headers carrying secrets.
+  secretHeaders = map[string]bool{
+    "authorization": true, "proxy-authorization": true, "cookie": true,
+    "set-cookie": true, "x-auth-token": true, "x-api-key": true,
+  }
+
+  // JSON fields (and XML elements) carrying secrets.
+  secretFields = map[string]bool{
+    "password": true, "passwd": true, "secret": true, "token": true,
+    "api_key": true, "apikey": true, "access_token": true,
+  }
+
+  // Matches XML elements such as <key>...</key> in appliance responses.
+  secretElementRegexp = buildElementRegexp()
+)
+
+// This method will add query parameters to be redacted from logged URLs.
+//
+// Args:
+//    names : Query parameter names, case insensitive.
+// Returns:
+//    None.
+func AddRedactedParams(names ...string) {
+  redactLock.Lock()
+  defer redactLock.Unlock()
+  for _, name := range names {
+    secretParams[strings.ToLower(name)] = true
+  }
+  secretElementRegexp = buildElementRegexp()
+}
+
+// This method will add HTTP headers to be redacted from logged headers.
+//
+// Args:
+//    names : Header names, case insensitive.
+// Returns:
+//    None.
+func AddRedactedHeaders(names ...string) {
+  redactLock.Lock()
+  defer redactLock.Unlock()
+  for _, name := range names {
+    secretHeaders[strings.ToLower(name)] = true
+  }
+}
+
+// This method will add JSON fields to be redacted from logged payloads.
+//
+// Args:
+//    names : JSON field names, case insensitive.
+// Returns:
+//    None.
+func AddRedactedFields(names ...string) {
+  redactLock.Lock()
+  defer redactLock.Unlock()
+  for _, name := range names {
+    secretFields[strings.ToLower(name)] = true
+  }
+  secretElementRegexp = buildElementRegexp()
+}
+
+// This method will mask the secret query parameters & the user info password
+// of the given URL. The rest of the URL is kept byte for byte so that
+// appliance URLs with XPath expressions stay readable.
+//
+// Args:
+//    rawURL : URL to redact.
+// Returns:
+//    string : Redacted URL.
+func RedactURL(rawURL string) (string) {
+  redactLock.RLock()
+  defer redactLock.RUnlock()
+
+  base, query, hasQuery := strings.Cut(rawURL, "?")
+  if parsed, err := url.Parse(base); (err == nil && parsed.User != nil) {
+    if _, hasPassword := parsed.User.Password(); hasPassword {
+      parsed.User = url.UserPassword(parsed.User.Username(), RedactedValue)
+      base = parsed.String()
+    }
+  }
+  if (!hasQuery) {
+    return base
+  }
+  params := strings.Split(query, "&")
+  for index, param := range params {
+    name, _, hasValue := strings.Cut(param, "=")
+    if (!hasValue) {
+      continue
+    }
+    if unescaped, err := url.QueryUnescape(name); (err == nil) {
+      name = unescaped
+    }
+    if (secretParams[strings.ToLower(name)]) {
+      params[index] = param[:strings.Index(param, "=") + 1] + RedactedValue
+    }
+  }
+  return base + "?" + strings.Join(params, "&")
+}
+
+// This method will mask the secrets in the URL carried by errors returned
+// from net/http, which include the full request URL in their message.
+//
+// Args:
+//    err : Error to redact.
+// Returns:
+//    error : Redacted error.
+func RedactError(err error) (error) {
+  var urlErr *url.Error
+  if (errors.As(err, &urlErr)) {
+    urlErr.URL = RedactURL(urlErr.URL)
+  }
+  return err
+}
+
+// This method will return a copy of the given headers with secret values
+// masked.
+//
+// Args:
+//    header : HTTP headers to redact.
+// Returns:
+//    Header : Redacted copy of the headers.
+func RedactHeaders(header http.Header) (http.Header) {
+  redactLock.RLock()
+  defer redactLock.RUnlock()
+  redacted := header.Clone()
+  for name := range redacted {
+    if (secretHeaders[strings.ToLower(name)]) {
+      redacted[name] = []string{RedactedValue}
+    }
+  }
+  return redacted
+}
+
+// This method will mask the secret fields of a request or event payload.
+// JSON payloads are masked field by field at any depth, any other payload
+// (e.g. XML appliance responses) has its secret elements masked.
+//
+// Args:
+//    body : Payload to redact.
+// Returns:
+//    string : Redacted payload.
+func RedactBody(body string) (string) {
+  redactLock.RLock()
+  defer redactLock.RUnlock()
+
+  var data interface{}
+  trimmed := strings.TrimSpace(body)
+  if (strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")) {
+    decoder := json.NewDecoder(strings.NewReader(trimmed))
+    // Keep numbers such as entity versions exactly as received.
+    decoder.UseNumber()
+    if err := decoder.Decode(&data); (err == nil) {
+      redactedBytes, err := json.Marshal(redactValue(data))
+      if (err == nil) {
+        return string(redactedBytes)
+      }
+    }
+  }
+  return secretElementRegexp.ReplaceAllString(body,
+    "${1}" + RedactedValue + "${3}")
+}
+
+// This method will recursively mask the secret fields of a decoded JSON
+// value.
+//
+// Args:
+//    value : Decoded JSON value.
+// Returns:
+//    interface{} : Redacted JSON value.
+func redactValue(value interface{}) (interface{}) {
+  switch typedValue := value.(type) {
+  case map[string]interface{}:
+    for field, fieldValue := range typedValue {
+      if (secretFields[strings.ToLower(field)]) {
+        typedValue[field] = RedactedValue
+      } else {
+        typedValue[field] = redactValue(fieldValue)
+      }
+    }
+  case []interface{}:
+    for index, item := range typedValue {
+      typedValue[index] = redactValue(item)
+    }
+  }
+  return value
+}
+
+// This method will build the regular expression matching the XML elements
+// that carry secrets. Must be called with redactLock held.
+//
+// Args:
+//    None.
+// Returns:
+//    Regexp : Compiled regular expression.
+func buildElementRegexp() (*regexp.Regexp) {
+  var names []string
+  for name := range secretParams {
+    names = append(names, regexp.QuoteMeta(name))
+  }
+  for name := range secretFields {
+    if (!secretParams[name]) {
+      names = append(names, regexp.QuoteMeta(name))
+    }
+  }
+  elements := strings.Join(names, "|")
+  return regexp.MustCompile("(?i)(<(?:" + elements + ")(?:\\s[^>]*)?>)" +
+    "([^<]*)(</(?:" + elements + ")>)")
+}
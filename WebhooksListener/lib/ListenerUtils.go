@@ -6,17 +6,21 @@ package lib
 
 import (
   "bytes"
-  "crypto/tls"
+  "context"
   "fmt"
-  "github.com/golang/glog"
   "io/ioutil"
+  "aplos/partners/WebhooksListener/logger"
+  "aplos/partners/WebhooksListener/metrics"
   "aplos/partners/WebhooksListener/schemas"
+  "aplos/partners/WebhooksListener/tracing"
   "net/http"
   "net"
   "strings"
+  "time"
 )
 
-// This is a generic method to prepare HTTP requests.
+// This is a generic method to prepare HTTP requests. The proxy is taken
+// from the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables.
 //
 // Args:
 //    requestUrl : Url for request.
@@ -27,14 +31,30 @@ import (
 //    Request : Partially prepared http request.
 func PrepareRequest(requestURL string, userName string, password string,
                     httpMethod string) (schema.Request) {
+  return PrepareRequestWithProxy(requestURL, userName, password, httpMethod,
+                                 schema.ProxyConfig{})
+}
+
+// This is a generic method to prepare HTTP requests that are routed through
+// the given proxy.
+//
+// Args:
+//    requestUrl : Url for request.
+//    userName : Authorized user name to make request.
+//    password : Password for authorized user to make request.
+//    httpMethod : Type of http request. For e.g., PUT, GET, POST
+//    proxy : Proxy configuration of the request target.
+// Returns:
+//    Request : Partially prepared http request.
+func PrepareRequestWithProxy(requestURL string, userName string,
+  password string, httpMethod string,
+  proxy schema.ProxyConfig) (schema.Request) {
   var request schema.Request
   request.Method = httpMethod
   request.URL = requestURL
   request.Credentials.Username = userName
   request.Credentials.Password = password
-  request.Transport = &http.Transport{
-    TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-  }
+  request.Transport = NewTransport(proxy)
   return request
 }
 
@@ -48,35 +68,61 @@ func PrepareRequest(requestURL string, userName string, password string,
 func DoRequest(request schema.Request) (*http.Response, error) {
   var err error
   var resp *http.Response
-  glog.Info("Processing http web request :", request.URL)
+  log := logger.With(logger.URLKey, RedactURL(request.URL))
+  log.Info("Processing http web request.", "method", request.Method)
 
+  ctx := request.Context
+  if (ctx == nil) {
+    ctx = context.Background()
+  }
   requestDataBytes := []byte(request.RequestData)
-  req, err := http.NewRequest(
+  req, err := http.NewRequestWithContext(ctx,
     request.Method, request.URL, bytes.NewBuffer(requestDataBytes))
   if (err != nil) {
-    glog.Error("Failed to create http web request. Error:- ", err)
+    err = RedactError(err)
+    log.Error("Failed to create http web request.", logger.ErrorKey, err)
     return resp, err
   }
   req.Header.Set("Content-Type", "application/json")
   req.SetBasicAuth(request.Credentials.Username, request.Credentials.Password)
+  for name, values := range request.Header {
+    req.Header[http.CanonicalHeaderKey(name)] = values
+  }
+  log.Debug("Request headers.", "headers", RedactHeaders(req.Header))
+  req, span := tracing.StartRequestSpan(req, RedactURL(request.URL))
 
   httpClient := http.Client{}
   httpClient.Transport = request.Transport
+  start := time.Now()
   resp, err = httpClient.Do(req)
-  if (err != nil || resp.StatusCode != 200) {
+  statusCode := 0
+  if (resp != nil) {
+    statusCode = resp.StatusCode
+  }
+  metrics.ObserveOutboundRequest(req.URL.Host, request.Method, statusCode,
+                                 start)
+  if (err != nil) {
+    err = RedactError(err)
+  }
+  tracing.EndRequestSpan(span, statusCode, err)
+  if (err != nil) {
+    // Connection level failure, e.g. proxy refused the CONNECT request.
+    log.Error("Request failed.", logger.ErrorKey, err)
+    return resp, err
+  }
+  if (resp.StatusCode != 200) {
     if(resp.StatusCode != 202) {
-      glog.Error("Request failed. Error:- ", err)
-      glog.Error("HTTP status code :", resp.StatusCode)
       // Extract body content from HTTP response.
       respData, _ := ioutil.ReadAll(resp.Body)
       // For Returning, Response data copied back to HTTP response body.
       resp.Body = ioutil.NopCloser(bytes.NewBuffer(respData))
-      glog.Error(string(respData))
+      log.Error("Request failed.", logger.StatusCodeKey, resp.StatusCode,
+        "response", RedactBody(string(respData)))
       return resp, err
     }
   }
 
-  glog.Info("Request successful.", resp.StatusCode)
+  log.Info("Request successful.", logger.StatusCodeKey, resp.StatusCode)
   return resp, err
 }
 
@@ -93,16 +139,17 @@ func DoRequest(request schema.Request) (*http.Response, error) {
 func CheckOutboundConnectivity(remoteIp string,
   remotePort string) (string, error) {
   connParam := fmt.Sprintf("%s:%s", remoteIp, remotePort)
-  glog.Infof("Checking connectivity with %s", connParam)
+  logger.Info("Checking connectivity.", "address", connParam)
   conn, err := net.Dial("tcp", connParam)
   if (err != nil) {
-    glog.Errorf("Error while connecting to %s. %s.", connParam, err)
+    logger.Error("Error while connecting.", "address", connParam,
+      logger.ErrorKey, err)
     return "", err
   }
   localIp := conn.LocalAddr().String()
   // Take IP from "IP:Port"
   localIp = strings.Split(localIp, ":")[0]
-  glog.Infof("Connectivity successfully verified using local IP %s.", localIp)
+  logger.Info("Connectivity successfully verified.", "local_ip", localIp)
   return localIp, err
 }
 
@@ -114,20 +161,35 @@ func CheckOutboundConnectivity(remoteIp string,
 //    error : Error, if any.
 func CheckPortAvailability(port string) (error) {
   var err error
-  glog.Infof("Checking if port %s is available.", port)
+  logger.Info("Checking if port is available.", "port", port)
   testSocket, err := net.Listen("tcp", ":" + port)
   if (err != nil) {
-    glog.Error("Cannot connect to port. Error:- ", err)
+    logger.Error("Cannot connect to port.", "port", port, logger.ErrorKey, err)
     return err
   }
   err = testSocket.Close()
   if (err != nil) {
-    glog.Error("Failed to close the socket. Error:- ", err)
+    logger.Error("Failed to close the socket.", logger.ErrorKey, err)
     return err
   }
   return err
 }
 
+// This method will return the name identifying an event consumer in logs &
+// metrics. Consumers can provide it through a Name() method, otherwise the
+// type name is used.
+//
+// Args:
+//    eventConsumer : Event consumer.
+// Returns:
+//    string : Consumer name.
+func ConsumerName(eventConsumer interface{}) (string) {
+  if named, ok := eventConsumer.(interface{ Name() string }); ok {
+    return named.Name()
+  }
+  return fmt.Sprintf("%T", eventConsumer)
+}
+
 // This method will remove duplicate entry from a list of strings.
 //
 // Args:
@@ -145,3 +207,24 @@ func RemoveDuplicates(list []string) ([]string) {
   }
   return uniqueItems
 }
+
+// This method will remove the given items from the list.
+//
+// Args:
+//    list : List of items.
+//    items : Items to remove.
+// Returns:
+//    []string : List without the items, in the original order.
+func RemoveItems(list []string, items []string) ([]string) {
+  var remainingItems []string
+  removed := make(map[string]bool)
+  for _, item := range items {
+    removed[item] = true
+  }
+  for _, item := range list {
+    if (!removed[item]) {
+      remainingItems = append(remainingItems, item)
+    }
+  }
+  return remainingItems
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+//
+// Main package of listenerctl, the administration tool of the webhooks
+// listener. Subcommands:
+//
+//   gc : List the listener webhooks of a cluster whose post_url is
+//        unreachable, and delete them with -delete, after confirmation.
+//   validate : Validate listener configuration files.
+//   schema : Print the JSON schema of the listener configuration file.
+package main
+
+import (
+  "bufio"
+  "flag"
+  "fmt"
+  "aplos/partners/WebhooksListener/config"
+  "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/logger"
+  "aplos/partners/WebhooksListener/schemas"
+  "aplos/partners/WebhooksListener/webhook"
+  "os"
+  "strings"
+  "text/tabwriter"
+)
+
+func usage() {
+  fmt.Fprintf(os.Stderr, "usage: listenerctl gc -cluster=<ip> " +
+    "-username=<user> [options]\n       listenerctl validate <config>...\n" +
+    "       listenerctl schema\n\nRun 'listenerctl gc -h' for the " +
+    "options.\n")
+  os.Exit(2)
+}
+
+func main() {
+  if (len(os.Args) < 2) {
+    usage()
+  }
+  switch os.Args[1] {
+  case "gc":
+    os.Exit(runGC(os.Args[2:]))
+  case "validate":
+    os.Exit(runValidate(os.Args[2:]))
+  case "schema":
+    os.Stdout.Write(config.Schema())
+  default:
+    usage()
+  }
+}
+
+// This method runs the gc subcommand.
+//
+// Args:
+//    args : Arguments of the subcommand.
+// Returns:
+//    int : Exit code.
+func runGC(args []string) (int) {
+  flags := flag.NewFlagSet("gc", flag.ExitOnError)
+  clusterIp := flags.String("cluster", "", "IP address of the cluster.")
+  clusterPort := flags.String("port", lib.DefaultPrismPort, "Prism port of the cluster.")
+  endpoints := flags.String("endpoints", "",
+    "Comma separated other Prism addresses of the cluster, e.g. CVM IPs.")
+  username := flags.String("username", "", "Prism username.")
+  password := flags.String("password", "",
+    "Prism password, defaults to $NUTANIX_PASSWORD.")
+  namePattern := flags.String("name", lib.WebhookNamePrefix + "*",
+    "Glob on the names of the webhooks to consider.")
+  minAge := flags.Duration("min-age", 0,
+    "Keep the webhooks created more recently, e.g. 720h.")
+  probeTimeout := flags.Duration("timeout", lib.DefaultProbeTimeout,
+    "Timeout of the post_url reachability probe.")
+  keepListenerID := flags.String("keep-listener-id", "",
+    "Never collect the webhook of this listener ID.")
+  deleteOrphans := flags.Bool("delete", false,
+    "Delete the orphaned webhooks. Without it, they are only listed.")
+  assumeYes := flags.Bool("yes", false,
+    "Delete without confirmation. The post_url is probed from this host " +
+    "only: a listener only reachable from the cluster network looks " +
+    "orphaned.")
+  verbose := flags.Bool("v", false, "Log the operations to stderr.")
+  flags.Parse(args)
+
+  if (*clusterIp == "" || *username == "") {
+    fmt.Fprintln(os.Stderr, "-cluster and -username are required.")
+    flags.Usage()
+    return 2
+  }
+  if (*password == "") {
+    *password = os.Getenv("NUTANIX_PASSWORD")
+  }
+  if (!*verbose) {
+    logger.SetLogger(nil)
+  }
+
+  // The tool has no identity of its own: use a throwaway listener ID
+  // unless one is protected.
+  var webhooksListener WebhooksListener.WebhooksListener
+  webhooksListener.ListenerID = *keepListenerID
+  if (*endpoints != "") {
+    webhooksListener.ClusterEndpoints = strings.Split(*endpoints, ",")
+  }
+  if (webhooksListener.ListenerID == "") {
+    webhooksListener.ListenerID, _ = lib.NewListenerID()
+  }
+  webhooksListener, err := webhooksListener.Initialize(*clusterIp,
+    *clusterPort, *username, *password)
+  if (err != nil) {
+    fmt.Fprintf(os.Stderr, "Failed to connect to cluster: %s\n", err)
+    return 1
+  }
+
+  options := schema.WebhookGCOptions{
+    NamePattern: *namePattern,
+    MinAge: *minAge,
+    ProbeTimeout: *probeTimeout,
+    Delete: *deleteOrphans,
+  }
+  if (!*assumeYes) {
+    options.Confirm = confirmDeletion(bufio.NewReader(os.Stdin))
+  }
+  results, err := webhooksListener.CollectOrphanedWebhooks(options)
+  writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+  fmt.Fprintln(writer, "UUID\tNAME\tPOST URL\tCREATED\tACTION\tREASON")
+  orphans := 0
+  for _, result := range results {
+    action := "keep"
+    if (result.Deleted) {
+      action = "deleted"
+    } else if (result.Declined) {
+      action = "kept"
+    } else if (result.Orphaned && *deleteOrphans) {
+      action = "failed"
+    } else if (result.Orphaned) {
+      action = "would delete"
+    }
+    if (result.Orphaned) {
+      orphans++
+    }
+    fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n", result.UUID, result.Name,
+      result.PostURL, result.CreationTime, action, result.Reason)
+  }
+  writer.Flush()
+  if (err != nil) {
+    fmt.Fprintf(os.Stderr, "Failed to collect orphaned webhooks: %s\n", err)
+    return 1
+  }
+  if (orphans > 0 && !*deleteOrphans) {
+    fmt.Printf("\n%d orphaned webhook(s). Run again with -delete to " +
+      "delete them.\n", orphans)
+  }
+  return 0
+}
+
+// This method will return the confirmation of the webhook deletions,
+// asking the operator on stderr & reading the answer from the given input.
+//
+// Args:
+//    input : Input of the answers.
+// Returns:
+//    func : Confirmation, true if the operator answered yes.
+func confirmDeletion(input *bufio.Reader) (
+  func(result schema.WebhookGCResult) (bool)) {
+  return func(result schema.WebhookGCResult) (bool) {
+    fmt.Fprintf(os.Stderr, "Delete webhook %s (%s) posting to %s, %s? " +
+      "[y/N] ", result.Name, result.UUID, result.PostURL, result.Reason)
+    answer, _ := input.ReadString('\n')
+    answer = strings.ToLower(strings.TrimSpace(answer))
+    return (answer == "y" || answer == "yes")
+  }
+}
+
+// This method runs the validate subcommand.
+//
+// Args:
+//    args : Configuration files to validate.
+// Returns:
+//    int : Exit code, 1 if a file is invalid.
+func runValidate(args []string) (int) {
+  if (len(args) == 0) {
+    usage()
+  }
+  exitCode := 0
+  for _, path := range args {
+    _, err := config.Load(path)
+    if (err != nil) {
+      fmt.Fprintln(os.Stderr, err)
+      exitCode = 1
+      continue
+    }
+    fmt.Printf("%s: OK\n", path)
+  }
+  return exitCode
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+
+// The Logger interface is used by the listener library, its utilities and
+// the event consumers for all their logging. Messages are accompanied by
+// key/value fields (event type, VM UUID, cluster, consumer etc.) so that
+// structured backends can index them.
+//
+// The process wide logger defaults to the standard log/slog default logger
+// and can be replaced by the embedding program through SetLogger, e.g. with
+// the glog adapter in the glogger package or with NewNopLogger.
+
+package logger
+
+import (
+  "log/slog"
+  "sync"
+)
+
+type Logger interface {
+  // Interface for the logger.
+
+  // Logging methods for the different severities.
+  //
+  // Args:
+  //    msg : Log message.
+  //    keysAndValues : Alternating field keys & values, e.g.
+  //                    "event_type", "VM.ON", "vm_uuid", uuid.
+  // Returns:
+  //    None.
+  Debug(msg string, keysAndValues ...interface{})
+  Info(msg string, keysAndValues ...interface{})
+  Warn(msg string, keysAndValues ...interface{})
+  Error(msg string, keysAndValues ...interface{})
+
+  // This method returns a logger that adds the given fields to every
+  // message.
+  //
+  // Args:
+  //    keysAndValues : Alternating field keys & values.
+  // Returns:
+  //    Logger : Logger with the fields attached.
+  With(keysAndValues ...interface{}) (Logger)
+}
+
+// Common field keys.
+const (
+  EventTypeKey = "event_type"
+  VMUUIDKey = "vm_uuid"
+  ClusterKey = "cluster"
+  ConsumerKey = "consumer"
+  URLKey = "url"
+  StatusCodeKey = "status_code"
+  ErrorKey = "error"
+)
+
+var (
+  loggerLock sync.RWMutex
+  currentLogger Logger = NewSlogLogger(slog.Default())
+)
+
+// This method will replace the process wide logger.
+//
+// Args:
+//    logger : Logger to use, nil selects the no-op logger.
+// Returns:
+//    None.
+func SetLogger(logger Logger) {
+  if (logger == nil) {
+    logger = NewNopLogger()
+  }
+  loggerLock.Lock()
+  defer loggerLock.Unlock()
+  currentLogger = logger
+}
+
+// This method will return the process wide logger.
+//
+// Args:
+//    None.
+// Returns:
+//    Logger : Current logger.
+func Get() (Logger) {
+  loggerLock.RLock()
+  defer loggerLock.RUnlock()
+  return currentLogger
+}
+
+// Shorthands logging through the process wide logger.
+func Debug(msg string, keysAndValues ...interface{}) {
+  Get().Debug(msg, keysAndValues...)
+}
+
+func Info(msg string, keysAndValues ...interface{}) {
+  Get().Info(msg, keysAndValues...)
+}
+
+func Warn(msg string, keysAndValues ...interface{}) {
+  Get().Warn(msg, keysAndValues...)
+}
+
+func Error(msg string, keysAndValues ...interface{}) {
+  Get().Error(msg, keysAndValues...)
+}
+
+func With(keysAndValues ...interface{}) (Logger) {
+  return Get().With(keysAndValues...)
+}
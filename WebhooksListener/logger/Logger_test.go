@@ -0,0 +1,43 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+//
+// This test package apply unit tests on the logger adapters.
+//
+
+package logger
+
+import (
+  "bytes"
+  "encoding/json"
+  "log/slog"
+  "testing"
+)
+
+// Test to verify fields are passed through to the slog adapter.
+func TestSlogLogger(t *testing.T) {
+  var output bytes.Buffer
+  previous := Get()
+  defer SetLogger(previous)
+  SetLogger(NewSlogLogger(slog.New(slog.NewJSONHandler(&output, nil))))
+
+  With(ClusterKey, "10.1.1.1").Info("Received event.", EventTypeKey, "VM.ON",
+    VMUUIDKey, "uuid-1")
+  var record map[string]interface{}
+  if err := json.Unmarshal(output.Bytes(), &record); (err != nil) {
+    t.Fatalf("Failed to parse log record %q: %s", output.String(), err)
+  }
+  if (record["msg"] != "Received event." || record["cluster"] != "10.1.1.1" ||
+      record["event_type"] != "VM.ON" || record["vm_uuid"] != "uuid-1") {
+    t.Errorf("Unexpected log record: %v", record)
+  }
+}
+
+// Test to verify a nil logger selects the no-op logger.
+func TestSetNilLogger(t *testing.T) {
+  previous := Get()
+  defer SetLogger(previous)
+  SetLogger(nil)
+  if _, ok := Get().(nopLogger); !ok {
+    t.Errorf("Expected no-op logger, got %T", Get())
+  }
+  Error("Discarded.", ErrorKey, "none")
+}
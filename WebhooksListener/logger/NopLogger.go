@@ -0,0 +1,31 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+
+// Logger that discards all messages.
+
+package logger
+
+type nopLogger struct {
+  // Type that implements the Logger interface & discards everything.
+}
+
+// This method will create a logger that discards all messages.
+//
+// Args:
+//    None.
+// Returns:
+//    Logger : No-op logger.
+func NewNopLogger() (Logger) {
+  return nopLogger{}
+}
+
+func (l nopLogger) Debug(msg string, keysAndValues ...interface{}) {}
+
+func (l nopLogger) Info(msg string, keysAndValues ...interface{}) {}
+
+func (l nopLogger) Warn(msg string, keysAndValues ...interface{}) {}
+
+func (l nopLogger) Error(msg string, keysAndValues ...interface{}) {}
+
+func (l nopLogger) With(keysAndValues ...interface{}) (Logger) {
+  return l
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+
+// Logger adapter for the standard log/slog package. Use it with a JSON
+// handler to get the listener logs in the same format as the embedding
+// service.
+
+package logger
+
+import (
+  "context"
+  "log/slog"
+)
+
+type slogLogger struct {
+  // Type that implements the Logger interface over slog.
+  logger *slog.Logger
+}
+
+// This method will create a logger writing to the given slog logger.
+//
+// Args:
+//    logger : slog logger, nil selects slog.Default().
+// Returns:
+//    Logger : Logger adapter.
+func NewSlogLogger(logger *slog.Logger) (Logger) {
+  if (logger == nil) {
+    logger = slog.Default()
+  }
+  return slogLogger{logger: logger}
+}
+
+func (l slogLogger) Debug(msg string, keysAndValues ...interface{}) {
+  l.log(slog.LevelDebug, msg, keysAndValues)
+}
+
+func (l slogLogger) Info(msg string, keysAndValues ...interface{}) {
+  l.log(slog.LevelInfo, msg, keysAndValues)
+}
+
+func (l slogLogger) Warn(msg string, keysAndValues ...interface{}) {
+  l.log(slog.LevelWarn, msg, keysAndValues)
+}
+
+func (l slogLogger) Error(msg string, keysAndValues ...interface{}) {
+  l.log(slog.LevelError, msg, keysAndValues)
+}
+
+func (l slogLogger) With(keysAndValues ...interface{}) (Logger) {
+  return slogLogger{logger: l.logger.With(keysAndValues...)}
+}
+
+func (l slogLogger) log(level slog.Level, msg string,
+  keysAndValues []interface{}) {
+  l.logger.Log(context.Background(), level, msg, keysAndValues...)
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+
+// Logger adapter for github.com/golang/glog. It is kept in its own package
+// since importing glog registers its command line flags, which embedding
+// programs may not want.
+//
+// Fields are appended to the message as key=value pairs & debug messages
+// are written at verbosity level 1 (-v=1).
+
+package glogger
+
+import (
+  "aplos/partners/WebhooksListener/logger"
+  "fmt"
+  "github.com/golang/glog"
+  "strings"
+)
+
+type glogLogger struct {
+  // Type that implements the Logger interface over glog.
+  fields []interface{}
+}
+
+// Verbosity level of debug messages.
+const debugLevel = 1
+
+// This method will create a logger writing through glog.
+//
+// Args:
+//    None.
+// Returns:
+//    Logger : Logger adapter.
+func New() (logger.Logger) {
+  return glogLogger{}
+}
+
+func (l glogLogger) Debug(msg string, keysAndValues ...interface{}) {
+  if (glog.V(debugLevel)) {
+    glog.InfoDepth(1, l.format(msg, keysAndValues))
+  }
+}
+
+func (l glogLogger) Info(msg string, keysAndValues ...interface{}) {
+  glog.InfoDepth(1, l.format(msg, keysAndValues))
+}
+
+func (l glogLogger) Warn(msg string, keysAndValues ...interface{}) {
+  glog.WarningDepth(1, l.format(msg, keysAndValues))
+}
+
+func (l glogLogger) Error(msg string, keysAndValues ...interface{}) {
+  glog.ErrorDepth(1, l.format(msg, keysAndValues))
+}
+
+func (l glogLogger) With(keysAndValues ...interface{}) (logger.Logger) {
+  fields := make([]interface{}, 0, len(l.fields) + len(keysAndValues))
+  fields = append(fields, l.fields...)
+  fields = append(fields, keysAndValues...)
+  return glogLogger{fields: fields}
+}
+
+// This method will append the fields to the message as key=value pairs.
+//
+// Args:
+//    msg : Log message.
+//    keysAndValues : Fields of the message.
+// Returns:
+//    string : Formatted message.
+func (l glogLogger) format(msg string,
+  keysAndValues []interface{}) (string) {
+  var builder strings.Builder
+  builder.WriteString(msg)
+  fields := append(l.fields[:len(l.fields):len(l.fields)], keysAndValues...)
+  for index := 0; index < len(fields); index += 2 {
+    if (index + 1 < len(fields)) {
+      fmt.Fprintf(&builder, " %v=%v", fields[index], fields[index + 1])
+    } else {
+      fmt.Fprintf(&builder, " %v", fields[index])
+    }
+  }
+  return builder.String()
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+
+// Prometheus metrics of the listener, the shared request layer and the event
+// consumers. All metrics are kept in a registry of their own which the
+// listener exposes on its metrics URL. Event consumers can add their own
+// collectors to it through Register.
+
+package metrics
+
+import (
+  "github.com/prometheus/client_golang/prometheus"
+  "github.com/prometheus/client_golang/prometheus/collectors"
+  "github.com/prometheus/client_golang/prometheus/promhttp"
+  "net/http"
+  "strconv"
+  "time"
+)
+
+// Prefix of all the listener metric names.
+const Namespace = "nutanix_listener"
+
+// Registry holding the listener & consumer metrics.
+var Registry = prometheus.NewRegistry()
+
+var (
+  // Webhook events received on the callback URL.
+  EventsReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+    Namespace: Namespace,
+    Name: "events_received_total",
+    Help: "Webhook events received, by event type and cluster.",
+  }, []string{"event_type", "cluster"})
+
+  // Time taken by the consumers to handle an event.
+  DispatchDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+    Namespace: Namespace,
+    Name: "dispatch_duration_seconds",
+    Help: "Time taken by a consumer OnEvent call, by consumer and event type.",
+    Buckets: []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+  }, []string{"consumer", "event_type"})
+
+  // Failed consumer calls.
+  ConsumerErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+    Namespace: Namespace,
+    Name: "consumer_errors_total",
+    Help: "Errors returned by consumers, by consumer and event type.",
+  }, []string{"consumer", "event_type"})
+
+  // Retried consumer calls.
+  Retries = prometheus.NewCounterVec(prometheus.CounterOpts{
+    Namespace: Namespace,
+    Name: "retries_total",
+    Help: "Retried consumer calls, by consumer and event type.",
+  }, []string{"consumer", "event_type"})
+
+  // Events received but not yet handled by all the consumers.
+  QueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+    Namespace: Namespace,
+    Name: "queue_depth",
+    Help: "Events waiting to be handled by the consumers, by cluster.",
+  }, []string{"cluster"})
+
+  // Latency of the requests made to Prism & the appliances.
+  OutboundRequestDuration = prometheus.NewHistogramVec(
+    prometheus.HistogramOpts{
+      Namespace: Namespace,
+      Name: "outbound_request_duration_seconds",
+      Help: "Latency of outbound HTTP requests, by target, method and " +
+        "status code (\"error\" for transport failures).",
+      Buckets: prometheus.DefBuckets,
+    }, []string{"target", "method", "code"})
+
+  // Webhook registration state with the cluster.
+  WebhookRegistered = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+    Namespace: Namespace,
+    Name: "webhook_registered",
+    Help: "1 when the cluster webhook is registered and COMPLETE, else 0.",
+  }, []string{"cluster"})
+
+  // Time taken by the stages of the consumer pipelines, see the Timing
+  // middleware.
+  StageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+    Namespace: Namespace,
+    Name: "stage_duration_seconds",
+    Help: "Time taken by a consumer pipeline stage, by stage, event type " +
+      "and result.",
+    Buckets: []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+  }, []string{"stage", "event_type", "result"})
+)
+
+func init() {
+  Registry.MustRegister(EventsReceived, DispatchDuration, ConsumerErrors,
+    Retries, QueueDepth, OutboundRequestDuration, WebhookRegistered,
+    StageDuration,
+    collectors.NewGoCollector(),
+    collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+}
+
+// This method allows the event consumers to expose their own metrics
+// through the listener.
+//
+// Args:
+//    collector : Prometheus collector to add.
+// Returns:
+//    error : Error, if any (e.g. a metric of the same name exists).
+func Register(collector prometheus.Collector) (error) {
+  return Registry.Register(collector)
+}
+
+// This method will return the HTTP handler serving the metrics.
+//
+// Args:
+//    None.
+// Returns:
+//    Handler : HTTP handler in the Prometheus exposition format.
+func Handler() (http.Handler) {
+  return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// This method will record the latency of an outbound request.
+//
+// Args:
+//    target : Host of the request target.
+//    method : HTTP method.
+//    statusCode : HTTP status code, 0 for transport failures.
+//    start : Time the request was started.
+// Returns:
+//    None.
+func ObserveOutboundRequest(target string, method string, statusCode int,
+  start time.Time) {
+  code := "error"
+  if (statusCode > 0) {
+    code = strconv.Itoa(statusCode)
+  }
+  OutboundRequestDuration.WithLabelValues(target, method, code).Observe(
+    time.Since(start).Seconds())
+}
+
+// This method will record the webhook registration state of a cluster.
+//
+// Args:
+//    cluster : Cluster address.
+//    registered : Whether the webhook is registered and COMPLETE.
+// Returns:
+//    None.
+func SetWebhookRegistered(cluster string, registered bool) {
+  value := 0.0
+  if (registered) {
+    value = 1
+  }
+  WebhookRegistered.WithLabelValues(cluster).Set(value)
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+
+// Event consumer middlewares. A middleware wraps an event consumer into
+// another one adding a cross cutting behavior, e.g. logging or a timeout,
+// around the business logic of the consumer. Pipelines are assembled with
+// Chain:
+//
+//   eventConsumer := middleware.Chain(
+//     middleware.Route(map[string]interfaces.EventConsumer{
+//       lib.VM_ON: middleware.ConsumerFunc(onVmOn),
+//       lib.VM_OFF: middleware.ConsumerFunc(onVmOff),
+//     }),
+//     middleware.Recover(),
+//     middleware.Logging("appliance"),
+//     middleware.Timeout(30 * time.Second),
+//   )
+//
+// The wrapped consumers keep the name, the health check & the reload of the
+// consumer they wrap.
+
+package middleware
+
+import (
+  "aplos/partners/WebhooksListener/interfaces"
+  "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/schemas"
+  "fmt"
+)
+
+// Middleware wrapping an event consumer.
+type Middleware func(interfaces.EventConsumer) (interfaces.EventConsumer)
+
+// Event consumer of a function.
+type ConsumerFunc func(event schema.Event) (error)
+
+func (consumerFunc ConsumerFunc) OnEvent(event schema.Event) (error) {
+  return consumerFunc(event)
+}
+
+type wrapped struct {
+  // Event consumer wrapped by a middleware.
+  next interfaces.EventConsumer
+  onEvent func(event schema.Event) (error)
+}
+
+// This method will wrap the event consumer with the middlewares, the first
+// middleware being the outermost one, i.e. the first to get the events.
+//
+// Args:
+//    eventConsumer : Event consumer, e.g. the business logic.
+//    middlewares : Middlewares to apply.
+// Returns:
+//    EventConsumer : Wrapped event consumer.
+func Chain(eventConsumer interfaces.EventConsumer,
+  middlewares ...Middleware) (interfaces.EventConsumer) {
+  for i := len(middlewares) - 1; i >= 0; i-- {
+    eventConsumer = middlewares[i](eventConsumer)
+  }
+  return eventConsumer
+}
+
+// This method will return an event consumer handing each event to the
+// consumer of its type, or of "*" for the other types. Events without
+// consumer are ignored.
+//
+// Args:
+//    routes : Event consumers by event type.
+// Returns:
+//    EventConsumer : Routing event consumer.
+func Route(routes map[string]interfaces.EventConsumer) (
+  interfaces.EventConsumer) {
+  return ConsumerFunc(func(event schema.Event) (error) {
+    eventConsumer, ok := routes[event.Event_Type]
+    if (!ok) {
+      eventConsumer, ok = routes["*"]
+    }
+    if (!ok) {
+      return nil
+    }
+    return eventConsumer.OnEvent(event)
+  })
+}
+
+// This method will wrap the event consumer with a new event callback.
+//
+// Args:
+//    next : Wrapped event consumer.
+//    onEvent : Event callback of the wrapping consumer.
+// Returns:
+//    EventConsumer : Wrapping event consumer.
+func wrap(next interfaces.EventConsumer,
+  onEvent func(event schema.Event) (error)) (interfaces.EventConsumer) {
+  return &wrapped{next: next, onEvent: onEvent}
+}
+
+func (wrapped *wrapped) OnEvent(event schema.Event) (error) {
+  return wrapped.onEvent(event)
+}
+
+// This method will return the name of the wrapped consumer.
+//
+// Args:
+//    None.
+// Returns:
+//    string : Consumer name.
+func (wrapped *wrapped) Name() (string) {
+  return lib.ConsumerName(wrapped.next)
+}
+
+// This method will report the health of the wrapped consumer, healthy if
+// it has no health check.
+//
+// Args:
+//    None.
+// Returns:
+//    error : nil when healthy, otherwise the reason for being unhealthy.
+func (wrapped *wrapped) Health() (error) {
+  if healthChecker, ok := wrapped.next.(interfaces.HealthChecker); ok {
+    return healthChecker.Health()
+  }
+  return nil
+}
+
+// This method will reconfigure the wrapped consumer.
+//
+// Args:
+//    config : Configuration block of the consumer, as JSON.
+// Returns:
+//    error : Error if the configuration is invalid or the wrapped consumer
+//            does not support reload.
+func (wrapped *wrapped) Reconfigure(config []byte) (error) {
+  if reconfigurable, ok := wrapped.next.(interfaces.Reconfigurable); ok {
+    return reconfigurable.Reconfigure(config)
+  }
+  return fmt.Errorf("consumer %s does not support reload, restart to apply",
+                    lib.ConsumerName(wrapped.next))
+}
+
+// This method will return the wrapped consumer.
+//
+// Args:
+//    None.
+// Returns:
+//    EventConsumer : Wrapped event consumer.
+func (wrapped *wrapped) Unwrap() (interfaces.EventConsumer) {
+  return wrapped.next
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+//
+// This test package apply unit tests on the consumer middlewares.
+//
+
+package middleware
+
+import (
+  "aplos/partners/WebhooksListener/interfaces"
+  "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/schemas"
+  "context"
+  "errors"
+  "strings"
+  "testing"
+  "time"
+)
+
+// Middleware recording the order in which it is called.
+func record(calls *[]string, name string) (Middleware) {
+  return func(next interfaces.EventConsumer) (interfaces.EventConsumer) {
+    return wrap(next, func(event schema.Event) (error) {
+      *calls = append(*calls, name)
+      return next.OnEvent(event)
+    })
+  }
+}
+
+type reconfigurableConsumer struct {
+  config string
+}
+
+func (consumer *reconfigurableConsumer) OnEvent(event schema.Event) (error) {
+  return nil
+}
+
+func (consumer *reconfigurableConsumer) Name() (string) {
+  return "inner"
+}
+
+func (consumer *reconfigurableConsumer) Reconfigure(config []byte) (error) {
+  consumer.config = string(config)
+  return nil
+}
+
+// Test to verify the chain order, the routing & the filtering, and that the
+// wrapped consumers keep the name & the reload of the inner consumer.
+func TestChain(t *testing.T) {
+  var calls []string
+  eventConsumer := Chain(Route(map[string]interfaces.EventConsumer{
+    lib.VM_ON: ConsumerFunc(func(event schema.Event) (error) {
+      calls = append(calls, "on")
+      return nil
+    }),
+    "*": ConsumerFunc(func(event schema.Event) (error) {
+      calls = append(calls, "other")
+      return nil
+    }),
+  }), record(&calls, "outer"), Filter(lib.VM_ON, lib.VM_OFF),
+    record(&calls, "inner"))
+  for _, eventType := range []string{lib.VM_ON, lib.VM_DELETE, lib.VM_OFF} {
+    if err := eventConsumer.OnEvent(
+      schema.Event{Event_Type: eventType}); (err != nil) {
+      t.Fatalf("Unexpected error: %s", err)
+    }
+  }
+  expected := "outer inner on outer outer inner other"
+  if (strings.Join(calls, " ") != expected) {
+    t.Errorf("Expected calls %q, got %q", expected, strings.Join(calls, " "))
+  }
+
+  inner := &reconfigurableConsumer{}
+  eventConsumer = Chain(inner, Recover(), Timing("test"))
+  if (lib.ConsumerName(eventConsumer) != "inner") {
+    t.Errorf("Expected the inner name, got %s",
+             lib.ConsumerName(eventConsumer))
+  }
+  err := eventConsumer.(interfaces.Reconfigurable).Reconfigure([]byte("{}"))
+  if (err != nil || inner.config != "{}") {
+    t.Errorf("Expected the inner consumer to be reconfigured, got %v", err)
+  }
+  eventConsumer = Chain(ConsumerFunc(func(event schema.Event) (error) {
+    return nil
+  }), Recover())
+  err = eventConsumer.(interfaces.Reconfigurable).Reconfigure([]byte("{}"))
+  if (err == nil ||
+      !strings.Contains(err.Error(), "does not support reload")) {
+    t.Errorf("Expected a reload error, got %v", err)
+  }
+}
+
+// Test to verify the retries, the timeout & the panic recovery.
+func TestRetryTimeoutRecover(t *testing.T) {
+  attempts := 0
+  eventConsumer := Chain(ConsumerFunc(func(event schema.Event) (error) {
+    attempts++
+    if (attempts < 3) {
+      return lib.Retryable(errors.New("busy"))
+    }
+    return nil
+  }), Retry(schema.RetryPolicy{MaxAttempts: 3,
+                               InitialBackoff: time.Millisecond}))
+  if err := eventConsumer.OnEvent(schema.Event{}); (err != nil) {
+    t.Errorf("Expected the third attempt to succeed, got %s", err)
+  }
+
+  attempts = 0
+  eventConsumer = Chain(ConsumerFunc(func(event schema.Event) (error) {
+    attempts++
+    return errors.New("invalid")
+  }), Retry(schema.RetryPolicy{InitialBackoff: time.Millisecond}))
+  if err := eventConsumer.OnEvent(schema.Event{}); (err == nil ||
+      attempts != 1) {
+    t.Errorf("Expected a permanent error without retry, got %v after %d",
+             err, attempts)
+  }
+
+  // The timeout waits for the consumer to return, for a retry not to run
+  // alongside it.
+  returned := false
+  eventConsumer = Chain(ConsumerFunc(func(event schema.Event) (error) {
+    <-event.Context().Done()
+    time.Sleep(10 * time.Millisecond)
+    returned = true
+    return event.Context().Err()
+  }), Timeout(10 * time.Millisecond))
+  err := eventConsumer.OnEvent(schema.Event{})
+  if (err == nil || !lib.IsRetryable(err) ||
+      !errors.Is(err, context.DeadlineExceeded) || !returned) {
+    t.Errorf("Expected a retryable timeout error once returned, got %v", err)
+  }
+
+  eventConsumer = Chain(ConsumerFunc(func(event schema.Event) (error) {
+    panic("boom")
+  }), Recover())
+  err = eventConsumer.OnEvent(schema.Event{})
+  var panicError *lib.PanicError
+  if (!errors.As(err, &panicError) || !lib.IsRetryable(err)) {
+    t.Errorf("Expected a retryable panic error, got %v", err)
+  }
+}
+
+// Test to verify the rate limit lets the burst through, gives up on the
+// events whose context is done & rejects the rates that are not positive.
+func TestRateLimit(t *testing.T) {
+  eventConsumer := Chain(ConsumerFunc(func(event schema.Event) (error) {
+    return nil
+  }), RateLimit(1, 2))
+  for i := 0; i < 2; i++ {
+    if err := eventConsumer.OnEvent(schema.Event{}); (err != nil) {
+      t.Fatalf("Expected the burst to pass, got %s", err)
+    }
+  }
+  ctx, cancel := context.WithTimeout(context.Background(),
+                                     10 * time.Millisecond)
+  defer cancel()
+  err := eventConsumer.OnEvent(schema.Event{}.WithContext(ctx))
+  if (err == nil || !lib.IsRetryable(err)) {
+    t.Errorf("Expected a retryable rate limit error, got %v", err)
+  }
+
+  for _, eventsPerSecond := range []float64{0, -1} {
+    func() {
+      defer func() {
+        if (recover() == nil) {
+          t.Errorf("Expected a panic for a rate of %v", eventsPerSecond)
+        }
+      }()
+      RateLimit(eventsPerSecond, 1)
+    }()
+  }
+}
@@ -0,0 +1,263 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+
+// Stock middlewares: filtering, logging, timing, retry, timeout, rate
+// limiting & panic recovery.
+
+package middleware
+
+import (
+  "aplos/partners/WebhooksListener/interfaces"
+  "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/logger"
+  "aplos/partners/WebhooksListener/metrics"
+  "aplos/partners/WebhooksListener/schemas"
+  "context"
+  "fmt"
+  "runtime/debug"
+  "sync"
+  "time"
+)
+
+// This method will return a middleware passing on the events of the given
+// types only. The other events are ignored.
+//
+// Args:
+//    eventTypes : Event types to pass on.
+// Returns:
+//    Middleware : Filtering middleware.
+func Filter(eventTypes ...string) (Middleware) {
+  kept := map[string]bool{}
+  for _, eventType := range eventTypes {
+    kept[eventType] = true
+  }
+  return FilterFunc(func(event schema.Event) (bool) {
+    return kept[event.Event_Type]
+  })
+}
+
+// This method will return a middleware passing on the events accepted by
+// the given function. The other events are ignored.
+//
+// Args:
+//    keep : Function accepting the events to pass on.
+// Returns:
+//    Middleware : Filtering middleware.
+func FilterFunc(keep func(event schema.Event) (bool)) (Middleware) {
+  return func(next interfaces.EventConsumer) (interfaces.EventConsumer) {
+    return wrap(next, func(event schema.Event) (error) {
+      if (!keep(event)) {
+        return nil
+      }
+      return next.OnEvent(event)
+    })
+  }
+}
+
+// This method will return a middleware logging the events & their result.
+//
+// Args:
+//    name : Name of the consumer in the logs.
+// Returns:
+//    Middleware : Logging middleware.
+func Logging(name string) (Middleware) {
+  return func(next interfaces.EventConsumer) (interfaces.EventConsumer) {
+    return wrap(next, func(event schema.Event) (error) {
+      log := logger.With(logger.ConsumerKey, name,
+                         logger.EventTypeKey, event.Event_Type,
+                         logger.VMUUIDKey, event.EntityReference.UUID)
+      log.Info("Received event.")
+      start := time.Now()
+      err := next.OnEvent(event)
+      if (err != nil) {
+        log.Error("Failed to process event.", logger.ErrorKey, err,
+                  "duration", time.Since(start))
+        return err
+      }
+      log.Info("Processed event.", "duration", time.Since(start))
+      return nil
+    })
+  }
+}
+
+// This method will return a middleware recording the time taken by the
+// wrapped consumer in the stage_duration_seconds metric.
+//
+// Args:
+//    stage : Name of the stage in the metric.
+// Returns:
+//    Middleware : Timing middleware.
+func Timing(stage string) (Middleware) {
+  return func(next interfaces.EventConsumer) (interfaces.EventConsumer) {
+    return wrap(next, func(event schema.Event) (error) {
+      start := time.Now()
+      err := next.OnEvent(event)
+      result := "success"
+      if (err != nil) {
+        result = "error"
+      }
+      metrics.StageDuration.WithLabelValues(stage, event.Event_Type,
+        result).Observe(time.Since(start).Seconds())
+      return err
+    })
+  }
+}
+
+// This method will return a middleware retrying the retryable failures of
+// the wrapped consumer, with an exponential backoff. The listener retries
+// the whole pipeline as per its own retry policy on top of it.
+//
+// Args:
+//    policy : Retry policy, the listener's defaults for the zero values.
+// Returns:
+//    Middleware : Retrying middleware.
+func Retry(policy schema.RetryPolicy) (Middleware) {
+  if (policy.MaxAttempts <= 0) {
+    policy.MaxAttempts = lib.DefaultMaxAttempts
+  }
+  if (policy.InitialBackoff <= 0) {
+    policy.InitialBackoff = lib.DefaultInitialBackoff
+  }
+  if (policy.MaxBackoff <= 0) {
+    policy.MaxBackoff = lib.DefaultMaxBackoff
+  }
+  return func(next interfaces.EventConsumer) (interfaces.EventConsumer) {
+    return wrap(next, func(event schema.Event) (error) {
+      var err error
+      backoff := policy.InitialBackoff
+      for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+        if (attempt > 1) {
+          select {
+          case <-event.Context().Done():
+            return err
+          case <-time.After(backoff):
+          }
+          backoff *= 2
+          if (backoff > policy.MaxBackoff) {
+            backoff = policy.MaxBackoff
+          }
+        }
+        err = next.OnEvent(event)
+        if (err == nil || !lib.IsRetryable(err)) {
+          return err
+        }
+      }
+      return err
+    })
+  }
+}
+
+// This method will return a middleware failing the events the wrapped
+// consumer does not handle in time, with a retryable error. The event
+// context is cancelled on timeout & the middleware waits for the wrapped
+// consumer to return, for a retry never to run alongside it. The wrapped
+// consumer must honour event.Context(), the timeout does not bound it
+// otherwise.
+//
+// Args:
+//    timeout : Time given to the wrapped consumer per event.
+// Returns:
+//    Middleware : Timeout middleware.
+func Timeout(timeout time.Duration) (Middleware) {
+  return func(next interfaces.EventConsumer) (interfaces.EventConsumer) {
+    return wrap(next, func(event schema.Event) (error) {
+      ctx, cancel := context.WithTimeout(event.Context(), timeout)
+      defer cancel()
+      result := make(chan error, 1)
+      go func() {
+        result <- invoke(next, event.WithContext(ctx))
+      }()
+      select {
+      case err := <-result:
+        return err
+      case <-ctx.Done():
+      }
+      // The event may be processed just as the context is done.
+      if err := <-result; (err == nil) {
+        return nil
+      }
+      return lib.Retryable(fmt.Errorf("event not processed within %s: %w",
+                                      timeout, ctx.Err()))
+    })
+  }
+}
+
+// This method will return a middleware limiting the rate of the events
+// passed on, with a token bucket. Events wait for their turn, or fail with
+// a retryable error if their context is done first.
+//
+// Args:
+//    eventsPerSecond : Sustained rate of the events, positive.
+//    burst : Number of events that may be passed on at once, at least 1.
+// Returns:
+//    Middleware : Rate limiting middleware.
+func RateLimit(eventsPerSecond float64, burst int) (Middleware) {
+  if (!(eventsPerSecond > 0)) {
+    panic(fmt.Sprintf("rate limit of %v events per second, must be positive",
+                      eventsPerSecond))
+  }
+  if (burst < 1) {
+    burst = 1
+  }
+  interval := time.Duration(float64(time.Second) / eventsPerSecond)
+  var lock sync.Mutex
+  // Time at which the bucket is full again.
+  full := time.Now()
+  return func(next interfaces.EventConsumer) (interfaces.EventConsumer) {
+    return wrap(next, func(event schema.Event) (error) {
+      // Take a token, possibly ahead of time, & wait until it is due.
+      lock.Lock()
+      now := time.Now()
+      if (full.Before(now)) {
+        full = now
+      }
+      full = full.Add(interval)
+      wait := full.Sub(now) - time.Duration(burst) * interval
+      lock.Unlock()
+      if (wait > 0) {
+        select {
+        case <-event.Context().Done():
+          lock.Lock()
+          full = full.Add(-interval)
+          lock.Unlock()
+          return lib.Retryable(fmt.Errorf("rate limited: %w",
+                                          event.Context().Err()))
+        case <-time.After(wait):
+        }
+      }
+      return next.OnEvent(event)
+    })
+  }
+}
+
+// This method will return a middleware turning a panic of the wrapped
+// consumer into a retryable PanicError carrying the stack trace.
+//
+// Args:
+//    None.
+// Returns:
+//    Middleware : Recovering middleware.
+func Recover() (Middleware) {
+  return func(next interfaces.EventConsumer) (interfaces.EventConsumer) {
+    return wrap(next, func(event schema.Event) (error) {
+      return invoke(next, event)
+    })
+  }
+}
+
+// This method will invoke the event consumer, converting a panic into a
+// PanicError.
+//
+// Args:
+//    eventConsumer : Event consumer to invoke.
+//    event : Event object to pass.
+// Returns:
+//    error : Error returned by the consumer or PanicError.
+func invoke(eventConsumer interfaces.EventConsumer,
+  event schema.Event) (err error) {
+  defer func() {
+    if value := recover(); (value != nil) {
+      err = &lib.PanicError{Value: value, Stack: debug.Stack()}
+    }
+  }()
+  return eventConsumer.OnEvent(event)
+}
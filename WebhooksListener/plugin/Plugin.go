@@ -0,0 +1,514 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+
+// Listener side of the plugins. A Plugin is an event consumer forwarding
+// the events to its plugin process. A plugin that exits or drops the
+// connection is restarted with a backoff; the events it did not answer
+// fail with retryable errors, and are retried as per the listener's retry
+// policy as for the consumers compiled in.
+
+package plugin
+
+import (
+  "aplos/partners/WebhooksListener/interfaces"
+  "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/logger"
+  "aplos/partners/WebhooksListener/registry"
+  "aplos/partners/WebhooksListener/schemas"
+  "aplos/partners/WebhooksListener/tracing"
+  "bytes"
+  "context"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io"
+  "net"
+  "net/http"
+  "os"
+  "os/exec"
+  "path/filepath"
+  "reflect"
+  "strings"
+  "sync"
+  "sync/atomic"
+  "time"
+)
+
+// Consumer type of the plugins in the listener configuration.
+const ConsumerType = "plugin"
+
+// Time given to a plugin process to exit once its stdin is closed.
+const shutdownTimeout = 5 * time.Second
+
+type Plugin struct {
+  // Type that implements the EventConsumer interface for a plugin.
+  name string
+  nextID atomic.Uint64
+
+  lock sync.Mutex
+  config schema.PluginConfig
+  conn *connection // Nil while the plugin is restarted.
+  closed bool
+  stop chan struct{}
+}
+
+type connection struct {
+  // Connection to a running plugin.
+  codec *codec
+  closer io.Closer
+  process *exec.Cmd // Nil for a plugin on a unix socket.
+  started time.Time
+
+  pendingLock sync.Mutex
+  pending map[uint64]chan *schema.RPCResponse
+
+  done chan struct{} // Closed when the connection ends.
+  err error // Reason of the end of the connection.
+}
+
+func init() {
+  registry.Register(ConsumerType,
+    func(config []byte) (interfaces.EventConsumer, error) {
+      var pluginConfig schema.PluginConfig
+      err := json.Unmarshal(config, &pluginConfig)
+      if (err != nil) {
+        return nil, err
+      }
+      return New(pluginConfig)
+    })
+}
+
+// This method will launch or connect to the plugin & perform the
+// handshake.
+//
+// Args:
+//    config : Configuration of the plugin.
+// Returns:
+//    Plugin : Event consumer of the plugin.
+//    error : Error if the plugin cannot be started.
+func New(config schema.PluginConfig) (*Plugin, error) {
+  if ((len(config.Command) == 0) == (config.Socket == "")) {
+    return nil, errors.New("exactly one of command & socket is required")
+  }
+  name := config.Socket
+  if (len(config.Command) > 0) {
+    name = config.Command[0]
+  }
+  plugin := &Plugin{name: "plugin:" + filepath.Base(name), config: config,
+                    stop: make(chan struct{})}
+  conn, err := plugin.connect()
+  if (err != nil) {
+    return nil, err
+  }
+  plugin.conn = conn
+  go plugin.supervise(conn)
+  return plugin, nil
+}
+
+// This method will return the name of the plugin, for the logs & metrics.
+//
+// Args:
+//    None.
+// Returns:
+//    string : Consumer name.
+func (plugin *Plugin) Name() (string) {
+  return plugin.name
+}
+
+// This method will forward the event to the plugin.
+//
+// Args:
+//    event : Event object.
+// Returns:
+//    error : Error replied by the plugin, retryable if the plugin said so,
+//            or if it did not reply.
+func (plugin *Plugin) OnEvent(event schema.Event) (error) {
+  params := schema.EventParams{Event: event.Raw}
+  if (len(params.Event) == 0) {
+    encoded, err := json.Marshal(event)
+    if (err != nil) {
+      return err
+    }
+    params.Event = encoded
+  }
+  header := http.Header{}
+  tracing.Inject(event.Context(), header)
+  if (len(header) > 0) {
+    params.TraceContext = map[string]string{}
+    for key := range header {
+      params.TraceContext[strings.ToLower(key)] = header.Get(key)
+    }
+  }
+  return plugin.call(event.Context(), MethodOnEvent, params, nil)
+}
+
+// This method will query the health of the plugin.
+//
+// Args:
+//    None.
+// Returns:
+//    error : nil when healthy, otherwise the reason for being unhealthy.
+func (plugin *Plugin) Health() (error) {
+  return plugin.call(context.Background(), MethodHealth, nil, nil)
+}
+
+// This method will send the new configuration block of the plugin. The
+// command & the socket of the plugin cannot change without a restart.
+//
+// Args:
+//    config : Configuration block of the consumer, as JSON.
+// Returns:
+//    error : Error if the block is invalid or rejected by the plugin.
+func (plugin *Plugin) Reconfigure(config []byte) (error) {
+  var pluginConfig schema.PluginConfig
+  err := json.Unmarshal(config, &pluginConfig)
+  if (err != nil) {
+    return err
+  }
+  current := plugin.currentConfig()
+  if (!reflect.DeepEqual(pluginConfig.Command, current.Command) ||
+      !reflect.DeepEqual(pluginConfig.Env, current.Env) ||
+      pluginConfig.Dir != current.Dir ||
+      pluginConfig.Socket != current.Socket) {
+    return errors.New("the plugin command or socket changed, restart to " +
+                      "apply")
+  }
+  if (!bytes.Equal(pluginConfig.Config, current.Config)) {
+    err = plugin.call(context.Background(), MethodReconfigure,
+      schema.ReconfigureParams{Config: pluginConfig.Config}, nil)
+    if (err != nil) {
+      return err
+    }
+  }
+  plugin.lock.Lock()
+  defer plugin.lock.Unlock()
+  plugin.config = pluginConfig
+  return nil
+}
+
+// This method will ask the plugin to shut down & stop restarting it.
+//
+// Args:
+//    None.
+// Returns:
+//    None.
+func (plugin *Plugin) Close() {
+  plugin.lock.Lock()
+  if (plugin.closed) {
+    plugin.lock.Unlock()
+    return
+  }
+  plugin.closed = true
+  close(plugin.stop)
+  conn := plugin.conn
+  plugin.conn = nil
+  plugin.lock.Unlock()
+  if (conn != nil) {
+    conn.codec.write(schema.RPCRequest{JSONRPC: jsonRPCVersion,
+                                       Method: MethodShutdown})
+    conn.close()
+  }
+}
+
+// This method will return the current configuration of the plugin.
+//
+// Args:
+//    None.
+// Returns:
+//    PluginConfig : Configuration of the plugin.
+func (plugin *Plugin) currentConfig() (schema.PluginConfig) {
+  plugin.lock.Lock()
+  defer plugin.lock.Unlock()
+  return plugin.config
+}
+
+// This method will return the call timeout with the default applied.
+//
+// Args:
+//    None.
+// Returns:
+//    Duration : Call timeout.
+func (plugin *Plugin) callTimeout() (time.Duration) {
+  if timeout := time.Duration(plugin.currentConfig().CallTimeout);
+      (timeout > 0) {
+    return timeout
+  }
+  return lib.DefaultPluginCallTimeout
+}
+
+// This method will launch or connect to the plugin & perform the
+// handshake.
+//
+// Args:
+//    None.
+// Returns:
+//    connection : Connection to the plugin.
+//    error : Error if the plugin cannot be started.
+func (plugin *Plugin) connect() (*connection, error) {
+  config := plugin.currentConfig()
+  conn := &connection{started: time.Now(),
+                      pending: map[uint64]chan *schema.RPCResponse{},
+                      done: make(chan struct{})}
+  if (config.Socket != "") {
+    netConn, err := net.Dial("unix", config.Socket)
+    if (err != nil) {
+      return nil, fmt.Errorf("%s: %s", plugin.name, err)
+    }
+    conn.codec = newCodec(netConn, netConn)
+    conn.closer = netConn
+  } else {
+    process := exec.Command(config.Command[0], config.Command[1:]...)
+    process.Env = append(os.Environ(), config.Env...)
+    process.Dir = config.Dir
+    process.Stderr = &outputLogger{name: plugin.name}
+    stdin, err := process.StdinPipe()
+    if (err != nil) {
+      return nil, err
+    }
+    stdout, err := process.StdoutPipe()
+    if (err != nil) {
+      return nil, err
+    }
+    err = process.Start()
+    if (err != nil) {
+      return nil, fmt.Errorf("%s: %s", plugin.name, err)
+    }
+    conn.codec = newCodec(stdout, stdin)
+    conn.closer = stdin
+    conn.process = process
+  }
+  go conn.receive()
+
+  params := schema.HandshakeParams{ProtocolVersion: ProtocolVersion,
+                                   Name: plugin.name, Config: config.Config}
+  var result schema.HandshakeResult
+  ctx, cancel := context.WithTimeout(context.Background(),
+                                     plugin.callTimeout())
+  defer cancel()
+  err := plugin.invoke(ctx, conn, MethodHandshake, params, &result)
+  if (err == nil && result.ProtocolVersion != ProtocolVersion) {
+    err = fmt.Errorf("unsupported protocol version %d, expected %d",
+                     result.ProtocolVersion, ProtocolVersion)
+  }
+  if (err != nil) {
+    conn.close()
+    return nil, fmt.Errorf("%s: handshake failed: %s", plugin.name, err)
+  }
+  logger.Info("Plugin started.", logger.ConsumerKey, plugin.name,
+              "plugin_name", result.Name)
+  return conn, nil
+}
+
+// This method will restart the plugin whenever its connection ends, until
+// the plugin is closed.
+//
+// Args:
+//    conn : Connection to the running plugin.
+// Returns:
+//    None.
+func (plugin *Plugin) supervise(conn *connection) {
+  backoff := lib.PluginRestartBackoff
+  for {
+    select {
+    case <-plugin.stop:
+      return
+    case <-conn.done:
+    }
+    logger.Error("Plugin stopped.", logger.ConsumerKey, plugin.name,
+                 logger.ErrorKey, conn.err)
+    plugin.lock.Lock()
+    plugin.conn = nil
+    plugin.lock.Unlock()
+    if (time.Since(conn.started) > lib.MaxPluginRestartBackoff) {
+      backoff = lib.PluginRestartBackoff
+    }
+
+    for {
+      select {
+      case <-plugin.stop:
+        return
+      case <-time.After(backoff):
+      }
+      backoff *= 2
+      if (backoff > lib.MaxPluginRestartBackoff) {
+        backoff = lib.MaxPluginRestartBackoff
+      }
+      next, err := plugin.connect()
+      if (err != nil) {
+        logger.Error("Failed to restart plugin.", logger.ConsumerKey,
+                     plugin.name, logger.ErrorKey, err)
+        continue
+      }
+      plugin.lock.Lock()
+      closed := plugin.closed
+      if (!closed) {
+        plugin.conn = next
+      }
+      plugin.lock.Unlock()
+      if (closed) {
+        next.close()
+        return
+      }
+      conn = next
+      break
+    }
+  }
+}
+
+// This method will call a method of the plugin, with the call timeout.
+//
+// Args:
+//    ctx : Context of the call.
+//    method : Method to call.
+//    params : Parameters of the method, encoded as JSON.
+//    result : Result to decode, may be nil.
+// Returns:
+//    error : Error replied by the plugin, or retryable error if the plugin
+//            is not running or did not reply.
+func (plugin *Plugin) call(ctx context.Context, method string,
+  params interface{}, result interface{}) (error) {
+  plugin.lock.Lock()
+  conn := plugin.conn
+  plugin.lock.Unlock()
+  if (conn == nil) {
+    return lib.Retryable(fmt.Errorf("%s is not running", plugin.name))
+  }
+  ctx, cancel := context.WithTimeout(ctx, plugin.callTimeout())
+  defer cancel()
+  return plugin.invoke(ctx, conn, method, params, result)
+}
+
+// This method will send a request on the connection & wait for its reply.
+//
+// Args:
+//    ctx : Context of the call.
+//    conn : Connection to the plugin.
+//    method : Method to call.
+//    params : Parameters of the method, encoded as JSON.
+//    result : Result to decode, may be nil.
+// Returns:
+//    error : Error replied by the plugin, or retryable error if the
+//            connection ended or the call timed out.
+func (plugin *Plugin) invoke(ctx context.Context, conn *connection,
+  method string, params interface{}, result interface{}) (error) {
+  request := schema.RPCRequest{JSONRPC: jsonRPCVersion, Method: method}
+  if (params != nil) {
+    encoded, err := json.Marshal(params)
+    if (err != nil) {
+      return err
+    }
+    request.Params = encoded
+  }
+  id := plugin.nextID.Add(1)
+  request.ID = &id
+  replies := make(chan *schema.RPCResponse, 1)
+  conn.pendingLock.Lock()
+  conn.pending[id] = replies
+  conn.pendingLock.Unlock()
+  defer func() {
+    conn.pendingLock.Lock()
+    delete(conn.pending, id)
+    conn.pendingLock.Unlock()
+  }()
+
+  err := conn.codec.write(request)
+  if (err != nil) {
+    return lib.Retryable(fmt.Errorf("%s: %s", plugin.name, err))
+  }
+  select {
+  case reply := <-replies:
+    if (reply.Error != nil) {
+      return replyError(reply.Error)
+    }
+    if (result != nil && len(reply.Result) > 0) {
+      return json.Unmarshal(reply.Result, result)
+    }
+    return nil
+  case <-conn.done:
+    return lib.Retryable(fmt.Errorf("%s: %s", plugin.name, conn.err))
+  case <-ctx.Done():
+    return lib.Retryable(fmt.Errorf("%s: %s call: %s", plugin.name, method,
+                                    ctx.Err()))
+  }
+}
+
+// This method will read the replies of the plugin until the connection
+// ends, then reap the plugin process.
+//
+// Args:
+//    None.
+// Returns:
+//    None.
+func (conn *connection) receive() {
+  var err error
+  for {
+    var data []byte
+    data, err = conn.codec.read()
+    if (err != nil) {
+      break
+    }
+    var reply schema.RPCResponse
+    if err := json.Unmarshal(data, &reply); (err != nil || reply.ID == nil) {
+      logger.Warn("Ignoring invalid plugin reply.", "reply", string(data))
+      continue
+    }
+    conn.pendingLock.Lock()
+    replies, ok := conn.pending[*reply.ID]
+    conn.pendingLock.Unlock()
+    if (ok) {
+      replies <- &reply
+    }
+  }
+  if (err == io.EOF) {
+    err = errors.New("connection closed by the plugin")
+  }
+  if (conn.process != nil) {
+    err = conn.process.Wait()
+    if (err == nil) {
+      err = errors.New("plugin exited")
+    } else {
+      err = fmt.Errorf("plugin exited: %s", err)
+    }
+  }
+  conn.err = err
+  close(conn.done)
+}
+
+// This method will close the connection, letting the plugin process exit
+// on its own before killing it.
+//
+// Args:
+//    None.
+// Returns:
+//    None.
+func (conn *connection) close() {
+  conn.closer.Close()
+  if (conn.process == nil) {
+    return
+  }
+  select {
+  case <-conn.done:
+  case <-time.After(shutdownTimeout):
+    conn.process.Process.Kill()
+    <-conn.done
+  }
+}
+
+type outputLogger struct {
+  // Writer logging the stderr of a plugin, line by line.
+  name string
+  buffer []byte
+}
+
+func (outputLogger *outputLogger) Write(data []byte) (int, error) {
+  outputLogger.buffer = append(outputLogger.buffer, data...)
+  for {
+    i := bytes.IndexByte(outputLogger.buffer, '\n')
+    if (i < 0) {
+      break
+    }
+    logger.Info("Plugin output.", logger.ConsumerKey, outputLogger.name,
+                "line", string(outputLogger.buffer[:i]))
+    outputLogger.buffer = outputLogger.buffer[i + 1:]
+  }
+  return len(data), nil
+}
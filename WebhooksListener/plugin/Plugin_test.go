@@ -0,0 +1,165 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+//
+// This test package apply unit tests on the plugins. The test binary itself
+// serves as the plugin process when PLUGIN_TEST_HELPER is set.
+//
+
+package plugin
+
+import (
+  "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/schemas"
+  "errors"
+  "net"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+  "time"
+)
+
+// Consumer of the test plugin.
+type helperConsumer struct {
+  config string
+}
+
+func (consumer *helperConsumer) OnEvent(event schema.Event) (error) {
+  switch event.Event_Type {
+  case lib.VM_OFF:
+    return lib.Retryable(errors.New("appliance unreachable"))
+  case lib.VM_DELETE:
+    return errors.New("unknown VM")
+  case lib.VM_MIGRATE:
+    os.Exit(3)
+  case lib.VM_UPDATE:
+    return errors.New("config " + consumer.config)
+  }
+  return nil
+}
+
+func (consumer *helperConsumer) Reconfigure(config []byte) (error) {
+  if (strings.Contains(string(config), "invalid")) {
+    return errors.New("invalid config")
+  }
+  consumer.config = string(config)
+  return nil
+}
+
+func TestMain(m *testing.M) {
+  if (os.Getenv("PLUGIN_TEST_HELPER") != "") {
+    Serve(&helperConsumer{})
+    os.Exit(0)
+  }
+  os.Exit(m.Run())
+}
+
+// This method will return an event of the given type.
+//
+// Args:
+//    eventType : Event type.
+// Returns:
+//    Event : Event object.
+func testEvent(eventType string) (schema.Event) {
+  return schema.Event{Event_Type: eventType,
+                      EntityReference: schema.Reference{KIND: "vm"}}
+}
+
+// Test to verify the events & their errors go through a launched plugin,
+// which is restarted when it exits.
+func TestLaunchedPlugin(t *testing.T) {
+  plugin, err := New(schema.PluginConfig{Command: []string{os.Args[0]},
+    Env: []string{"PLUGIN_TEST_HELPER=1"},
+    CallTimeout: schema.Duration(5 * time.Second)})
+  if (err != nil) {
+    t.Fatalf("Failed to start plugin: %s", err)
+  }
+  defer plugin.Close()
+
+  if err = plugin.OnEvent(testEvent(lib.VM_ON)); (err != nil) {
+    t.Errorf("Expected no error, got %s", err)
+  }
+  if err = plugin.Health(); (err != nil) {
+    t.Errorf("Expected a healthy plugin, got %s", err)
+  }
+  err = plugin.OnEvent(testEvent(lib.VM_OFF))
+  if (err == nil || !lib.IsRetryable(err) ||
+      !strings.Contains(err.Error(), "appliance unreachable")) {
+    t.Errorf("Expected a retryable error, got %v", err)
+  }
+  err = plugin.OnEvent(testEvent(lib.VM_DELETE))
+  if (err == nil || lib.IsRetryable(err)) {
+    t.Errorf("Expected a permanent error, got %v", err)
+  }
+
+  // The events fail with retryable errors until the plugin is restarted.
+  err = plugin.OnEvent(testEvent(lib.VM_MIGRATE))
+  if (err == nil || !lib.IsRetryable(err)) {
+    t.Errorf("Expected a retryable error for the crash, got %v", err)
+  }
+  deadline := time.Now().Add(10 * time.Second)
+  for {
+    err = plugin.OnEvent(testEvent(lib.VM_ON))
+    if (err == nil || time.Now().After(deadline)) {
+      break
+    }
+    if (!lib.IsRetryable(err)) {
+      t.Fatalf("Expected a retryable error while restarting, got %s", err)
+    }
+    time.Sleep(100 * time.Millisecond)
+  }
+  if (err != nil) {
+    t.Errorf("Plugin was not restarted: %s", err)
+  }
+}
+
+// Test to verify a plugin on a unix socket gets its configuration in the
+// handshake & on reconfiguration.
+func TestSocketPlugin(t *testing.T) {
+  socket := filepath.Join(t.TempDir(), "plugin.sock")
+  listener, err := net.Listen("unix", socket)
+  if (err != nil) {
+    t.Fatalf("Failed to listen: %s", err)
+  }
+  defer listener.Close()
+  go func() {
+    for {
+      conn, err := listener.Accept()
+      if (err != nil) {
+        return
+      }
+      go ServeConn(conn, conn, &helperConsumer{})
+    }
+  }()
+
+  plugin, err := New(schema.PluginConfig{Socket: socket,
+                                         Config: []byte(`{"mode":"a"}`)})
+  if (err != nil) {
+    t.Fatalf("Failed to connect to plugin: %s", err)
+  }
+  defer plugin.Close()
+  if (plugin.Name() != "plugin:plugin.sock") {
+    t.Errorf("Unexpected plugin name %s", plugin.Name())
+  }
+  err = plugin.OnEvent(testEvent(lib.VM_UPDATE))
+  if (err == nil || !strings.Contains(err.Error(), `{"mode":"a"}`)) {
+    t.Errorf("Expected the handshake config, got %v", err)
+  }
+  err = plugin.Reconfigure([]byte(`{"socket":"` + socket +
+                                  `","config":{"mode":"b"}}`))
+  if (err != nil) {
+    t.Fatalf("Failed to reconfigure plugin: %s", err)
+  }
+  err = plugin.OnEvent(testEvent(lib.VM_UPDATE))
+  if (err == nil || !strings.Contains(err.Error(), `{"mode":"b"}`)) {
+    t.Errorf("Expected the new config, got %v", err)
+  }
+  err = plugin.Reconfigure([]byte(`{"socket":"` + socket +
+                                  `","config":{"mode":"invalid"}}`))
+  if (err == nil) {
+    t.Errorf("Expected the plugin to reject the config")
+  }
+  err = plugin.Reconfigure([]byte(`{"command":["other"]}`))
+  if (err == nil || !strings.Contains(err.Error(), "restart")) {
+    t.Errorf("Expected a restart error, got %v", err)
+  }
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+
+// Out of process consumers. A plugin is an executable launched by the
+// listener, or a process listening on a unix socket, which handles the
+// events as an event consumer would. The listener & the plugin exchange
+// JSON-RPC 2.0 messages, one per line, over the stdin & stdout of the
+// plugin or over the socket. The protocol is described in PROTOCOL.md.
+//
+// Plugins written in Go serve the protocol with Serve & an ordinary
+// EventConsumer. The listener runs plugins through the "plugin" consumer
+// type of the registry.
+
+package plugin
+
+import (
+  "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/schemas"
+  "bufio"
+  "encoding/json"
+  "fmt"
+  "io"
+  "sync"
+)
+
+// Version of the protocol, agreed on in the handshake.
+const ProtocolVersion = 1
+
+// Methods of the protocol.
+const (
+  MethodHandshake = "handshake"
+  MethodOnEvent = "on_event"
+  MethodHealth = "health"
+  MethodReconfigure = "reconfigure"
+  MethodShutdown = "shutdown"
+)
+
+// Error codes of the protocol, as per JSON-RPC 2.0.
+const (
+  CodeParseError = -32700
+  CodeInvalidRequest = -32600
+  CodeMethodNotFound = -32601
+  CodeInvalidParams = -32602
+  CodeConsumerError = -32000
+)
+
+const (
+  jsonRPCVersion = "2.0"
+
+  // Maximum size of a message, i.e. of a line.
+  maxMessageSize = 16 << 20
+)
+
+type codec struct {
+  // Reader & writer of the messages, one per line.
+  scanner *bufio.Scanner
+  writeLock sync.Mutex
+  writer io.Writer
+}
+
+// This method will create the codec of a connection.
+//
+// Args:
+//    reader : Reader of the incoming messages.
+//    writer : Writer of the outgoing messages.
+// Returns:
+//    codec : Codec of the connection.
+func newCodec(reader io.Reader, writer io.Writer) (*codec) {
+  scanner := bufio.NewScanner(reader)
+  scanner.Buffer(make([]byte, 64 * 1024), maxMessageSize)
+  return &codec{scanner: scanner, writer: writer}
+}
+
+// This method will read the next message. It must not be called
+// concurrently.
+//
+// Args:
+//    None.
+// Returns:
+//    []byte : Message, without the line feed.
+//    error : io.EOF at the end of the connection, or the read error.
+func (codec *codec) read() ([]byte, error) {
+  if (!codec.scanner.Scan()) {
+    if err := codec.scanner.Err(); (err != nil) {
+      return nil, err
+    }
+    return nil, io.EOF
+  }
+  return codec.scanner.Bytes(), nil
+}
+
+// This method will write a message on a line of its own.
+//
+// Args:
+//    message : Message to encode as JSON.
+// Returns:
+//    error : Error, if any.
+func (codec *codec) write(message interface{}) (error) {
+  data, err := json.Marshal(message)
+  if (err != nil) {
+    return err
+  }
+  codec.writeLock.Lock()
+  defer codec.writeLock.Unlock()
+  _, err = codec.writer.Write(append(data, '\n'))
+  return err
+}
+
+// This method will convert an error reply into an error, retryable if the
+// plugin said so.
+//
+// Args:
+//    rpcError : Error reply of the plugin.
+// Returns:
+//    error : Error.
+func replyError(rpcError *schema.RPCError) (error) {
+  err := fmt.Errorf("%s (code %d)", rpcError.Message, rpcError.Code)
+  if (rpcError.Data != nil && rpcError.Data.Retryable) {
+    return lib.Retryable(err)
+  }
+  return err
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+
+// Plugin side of the protocol, for plugins written in Go. The plugin main
+// passes its event consumer to Serve, which answers the requests of the
+// listener until the listener shuts the plugin down.
+
+package plugin
+
+import (
+  "aplos/partners/WebhooksListener/interfaces"
+  "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/schemas"
+  "aplos/partners/WebhooksListener/tracing"
+  "encoding/json"
+  "fmt"
+  "io"
+  "net/http"
+  "os"
+)
+
+// This method will serve the event consumer over stdin & stdout, until the
+// listener shuts the plugin down or closes stdin. Nothing else may be
+// written on stdout; logs go to stderr.
+//
+// Args:
+//    eventConsumer : Event consumer of the plugin.
+// Returns:
+//    error : Read or write error, nil on shutdown.
+func Serve(eventConsumer interfaces.EventConsumer) (error) {
+  return ServeConn(os.Stdin, os.Stdout, eventConsumer)
+}
+
+// This method will serve the event consumer over a connection, e.g. a
+// connection accepted on a unix socket.
+//
+// Args:
+//    reader : Reader of the requests.
+//    writer : Writer of the replies.
+//    eventConsumer : Event consumer of the plugin.
+// Returns:
+//    error : Read or write error, nil on shutdown.
+func ServeConn(reader io.Reader, writer io.Writer,
+  eventConsumer interfaces.EventConsumer) (error) {
+  codec := newCodec(reader, writer)
+  for {
+    data, err := codec.read()
+    if (err == io.EOF) {
+      return nil
+    }
+    if (err != nil) {
+      return err
+    }
+    var request schema.RPCRequest
+    err = json.Unmarshal(data, &request)
+    if (err != nil || request.Method == "") {
+      err = codec.write(schema.RPCResponse{JSONRPC: jsonRPCVersion,
+        Error: &schema.RPCError{Code: CodeInvalidRequest,
+                                Message: "invalid request"}})
+      if (err != nil) {
+        return err
+      }
+      continue
+    }
+    if (request.Method == MethodShutdown) {
+      return nil
+    }
+    result, rpcError := handle(request, eventConsumer)
+    if (request.ID == nil) {
+      continue
+    }
+    reply := schema.RPCResponse{JSONRPC: jsonRPCVersion, ID: request.ID,
+                                Error: rpcError}
+    if (rpcError == nil) {
+      reply.Result, err = json.Marshal(result)
+      if (err != nil) {
+        return err
+      }
+    }
+    err = codec.write(reply)
+    if (err != nil) {
+      return err
+    }
+  }
+}
+
+// This method will handle a request with the event consumer.
+//
+// Args:
+//    request : Request of the listener.
+//    eventConsumer : Event consumer of the plugin.
+// Returns:
+//    interface{} : Result of the request.
+//    RPCError : Error reply, nil on success.
+func handle(request schema.RPCRequest,
+  eventConsumer interfaces.EventConsumer) (interface{}, *schema.RPCError) {
+  invalidParams := func(err error) (*schema.RPCError) {
+    return &schema.RPCError{Code: CodeInvalidParams, Message: err.Error()}
+  }
+  switch request.Method {
+  case MethodHandshake:
+    var params schema.HandshakeParams
+    if err := json.Unmarshal(request.Params, &params); (err != nil) {
+      return nil, invalidParams(err)
+    }
+    if (params.ProtocolVersion != ProtocolVersion) {
+      return nil, invalidParams(fmt.Errorf("unsupported protocol version " +
+        "%d, expected %d", params.ProtocolVersion, ProtocolVersion))
+    }
+    if (len(params.Config) > 0) {
+      if reconfigurable, ok :=
+          eventConsumer.(interfaces.Reconfigurable); ok {
+        err := reconfigurable.Reconfigure(params.Config)
+        if (err != nil) {
+          return nil, consumerError(err)
+        }
+      }
+    }
+    return schema.HandshakeResult{ProtocolVersion: ProtocolVersion,
+                                  Name: lib.ConsumerName(eventConsumer)}, nil
+
+  case MethodOnEvent:
+    var params schema.EventParams
+    if err := json.Unmarshal(request.Params, &params); (err != nil) {
+      return nil, invalidParams(err)
+    }
+    var event schema.Event
+    err := json.Unmarshal(params.Event, &event)
+    if (err == nil) {
+      err = lib.DecodePayload(&event)
+    }
+    if (err != nil) {
+      return nil, invalidParams(fmt.Errorf("malformed event: %s", err))
+    }
+    header := http.Header{}
+    for key, value := range params.TraceContext {
+      header.Set(key, value)
+    }
+    event = event.WithContext(tracing.Extract(header))
+    if err := eventConsumer.OnEvent(event); (err != nil) {
+      return nil, consumerError(err)
+    }
+    return struct{}{}, nil
+
+  case MethodHealth:
+    if healthChecker, ok := eventConsumer.(interfaces.HealthChecker); ok {
+      if err := healthChecker.Health(); (err != nil) {
+        return nil, consumerError(err)
+      }
+    }
+    return struct{}{}, nil
+
+  case MethodReconfigure:
+    reconfigurable, ok := eventConsumer.(interfaces.Reconfigurable)
+    if (!ok) {
+      break
+    }
+    var params schema.ReconfigureParams
+    if err := json.Unmarshal(request.Params, &params); (err != nil) {
+      return nil, invalidParams(err)
+    }
+    if err := reconfigurable.Reconfigure(params.Config); (err != nil) {
+      return nil, consumerError(err)
+    }
+    return struct{}{}, nil
+  }
+  return nil, &schema.RPCError{Code: CodeMethodNotFound,
+    Message: fmt.Sprintf("method %q not found", request.Method)}
+}
+
+// This method will convert an error of the event consumer into an error
+// reply, retryable if the error is.
+//
+// Args:
+//    err : Error of the event consumer.
+// Returns:
+//    RPCError : Error reply.
+func consumerError(err error) (*schema.RPCError) {
+  return &schema.RPCError{Code: CodeConsumerError, Message: err.Error(),
+    Data: &schema.RPCErrorData{Retryable: lib.IsRetryable(err)}}
+}
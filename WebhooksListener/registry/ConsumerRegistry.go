@@ -0,0 +1,81 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+
+// Registry of the event consumers compiled into the listener. Consumer
+// packages register a factory under their type name, e.g. "pafw" or "f5",
+// from their init function. A listener binary then builds the consumers
+// chosen in its configuration file, by type, from their configuration
+// blocks, without a main package per consumer.
+
+package registry
+
+import (
+  "aplos/partners/WebhooksListener/interfaces"
+  "fmt"
+  "sort"
+  "strings"
+  "sync"
+)
+
+// Factory of an event consumer. It receives the configuration block of the
+// consumer, as JSON, empty if the configuration file has none.
+type Factory func(config []byte) (interfaces.EventConsumer, error)
+
+var (
+  registryLock sync.RWMutex
+  factories = map[string]Factory{}
+)
+
+// This method will register the factory of a consumer type, replacing the
+// existing one.
+//
+// Args:
+//    consumerType : Type of the consumer, e.g. "pafw".
+//    factory : Factory of the consumers of the type.
+// Returns:
+//    None.
+func Register(consumerType string, factory Factory) {
+  registryLock.Lock()
+  defer registryLock.Unlock()
+  factories[consumerType] = factory
+}
+
+// This method will return the registered consumer types, sorted.
+//
+// Args:
+//    None.
+// Returns:
+//    []string : Registered consumer types.
+func Types() ([]string) {
+  registryLock.RLock()
+  defer registryLock.RUnlock()
+  consumerTypes := make([]string, 0, len(factories))
+  for consumerType := range factories {
+    consumerTypes = append(consumerTypes, consumerType)
+  }
+  sort.Strings(consumerTypes)
+  return consumerTypes
+}
+
+// This method will build a consumer of the given type.
+//
+// Args:
+//    consumerType : Type of the consumer, e.g. "pafw".
+//    config : Configuration block of the consumer, may be empty.
+// Returns:
+//    EventConsumer : Event consumer.
+//    error : Error if the type is not registered or the factory fails.
+func New(consumerType string, config []byte) (interfaces.EventConsumer,
+  error) {
+  registryLock.RLock()
+  factory, ok := factories[consumerType]
+  registryLock.RUnlock()
+  if (!ok) {
+    return nil, fmt.Errorf("unknown consumer type %q, must be one of: %s",
+                           consumerType, strings.Join(Types(), ", "))
+  }
+  eventConsumer, err := factory(config)
+  if (err != nil) {
+    return nil, fmt.Errorf("consumer %s: %s", consumerType, err)
+  }
+  return eventConsumer, nil
+}
@@ -0,0 +1,307 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+
+// Set of the consumers of a listener configuration, registered with the
+// listener as a single event consumer. Each event is handed to the members
+// subscribed to its type, one after the other. A failure of a member does
+// not keep the event from the others. The retryable failures are retried
+// per member, so that the members which processed the event never get it
+// twice; the remaining failures are reported together, as not retryable.
+
+package registry
+
+import (
+  "aplos/partners/WebhooksListener/interfaces"
+  "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/logger"
+  "aplos/partners/WebhooksListener/middleware"
+  "aplos/partners/WebhooksListener/schemas"
+  "bytes"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "runtime/debug"
+  "sync"
+)
+
+type member struct {
+  // Consumer of the set, with the event types it is subscribed to.
+  name string
+  consumerType string
+  events map[string]bool // All the events if nil.
+  config json.RawMessage // Configuration block last applied.
+  eventConsumer interfaces.EventConsumer
+}
+
+type ConsumerSet struct {
+  RetryPolicy schema.RetryPolicy // Retries of the failed members.
+  lock sync.RWMutex
+  members []*member
+}
+
+// Failures of the members once retried by the set. Not retryable, the
+// listener retrying them would hand the event to all the members again.
+type membersError struct {
+  err error
+}
+
+func (membersError *membersError) Error() (string) {
+  return membersError.err.Error()
+}
+
+func (membersError *membersError) Unwrap() (error) {
+  return membersError.err
+}
+
+func (membersError *membersError) Retryable() (bool) {
+  return false
+}
+
+// This method will build the consumers of a listener configuration.
+//
+// Args:
+//    consumers : Consumers of the listener configuration.
+// Returns:
+//    ConsumerSet : Set of the consumers.
+//    error : Error of the first consumer that cannot be built.
+func NewConsumerSet(consumers []schema.ConsumerConfig) (*ConsumerSet,
+  error) {
+  if (len(consumers) == 0) {
+    return nil, errors.New("no consumers configured")
+  }
+  set := &ConsumerSet{}
+  names := map[string]bool{}
+  for _, consumerConfig := range consumers {
+    name := memberName(consumerConfig)
+    if (names[name]) {
+      return nil, fmt.Errorf("consumer %s is configured twice, set a name",
+                             name)
+    }
+    names[name] = true
+    eventConsumer, err := New(consumerConfig.Type, consumerConfig.Config)
+    if (err != nil) {
+      return nil, err
+    }
+    set.members = append(set.members, &member{name: name,
+      consumerType: consumerConfig.Type,
+      events: eventSet(consumerConfig.Events),
+      config: consumerConfig.Config, eventConsumer: eventConsumer})
+  }
+  return set, nil
+}
+
+// This method will return the name of the set, for the logs & metrics.
+//
+// Args:
+//    None.
+// Returns:
+//    string : Consumer name.
+func (set *ConsumerSet) Name() (string) {
+  return "consumers"
+}
+
+// This method will hand the event to the members subscribed to its type,
+// retrying the retryable failures of each member as per the retry policy.
+//
+// Args:
+//    event : Event object.
+// Returns:
+//    error : Failures of the members, never retryable.
+func (set *ConsumerSet) OnEvent(event schema.Event) (error) {
+  set.lock.RLock()
+  members := set.members
+  set.lock.RUnlock()
+  retry := middleware.Retry(set.RetryPolicy)
+  var errs []error
+  for _, member := range members {
+    if (member.events != nil && !member.events[event.Event_Type]) {
+      continue
+    }
+    err := retry(middleware.ConsumerFunc(member.onEvent)).OnEvent(event)
+    if (err != nil) {
+      logger.Error("Event consumer failed.", logger.ConsumerKey, member.name,
+                   logger.EventTypeKey, event.Event_Type, logger.ErrorKey,
+                   err)
+      errs = append(errs, fmt.Errorf("consumer %s: %w", member.name, err))
+    }
+  }
+  if (len(errs) == 0) {
+    return nil
+  }
+  return &membersError{err: errors.Join(errs...)}
+}
+
+// This method will report the health of the members implementing
+// interfaces.HealthChecker.
+//
+// Args:
+//    None.
+// Returns:
+//    error : Health failures of the members, nil when all are healthy.
+func (set *ConsumerSet) Health() (error) {
+  set.lock.RLock()
+  members := set.members
+  set.lock.RUnlock()
+  var errs []error
+  for _, member := range members {
+    healthChecker, ok := member.eventConsumer.(interfaces.HealthChecker)
+    if (!ok) {
+      continue
+    }
+    if err := healthChecker.Health(); (err != nil) {
+      errs = append(errs, fmt.Errorf("consumer %s: %w", member.name, err))
+    }
+  }
+  return errors.Join(errs...)
+}
+
+// This method will apply the consumers of a reloaded listener
+// configuration: the changed configuration blocks & the event types of the
+// members. Adding or removing consumers, or changing the block of a member
+// not implementing interfaces.Reconfigurable, requires a restart. If a
+// member rejects its block, the members already reconfigured get their
+// previous block back.
+//
+// Args:
+//    config : Consumers of the listener configuration, as a JSON list.
+// Returns:
+//    error : Error if the consumers changed or a member rejects its block.
+func (set *ConsumerSet) Reconfigure(config []byte) (error) {
+  var consumers []schema.ConsumerConfig
+  err := json.Unmarshal(config, &consumers)
+  if (err != nil) {
+    return err
+  }
+  set.lock.Lock()
+  defer set.lock.Unlock()
+  byName := map[string]*member{}
+  for _, member := range set.members {
+    byName[member.name] = member
+  }
+  if (len(consumers) != len(set.members)) {
+    return errors.New("consumers were added or removed, restart to apply")
+  }
+  for _, consumerConfig := range consumers {
+    member, ok := byName[memberName(consumerConfig)]
+    if (!ok || member.consumerType != consumerConfig.Type) {
+      return errors.New("consumers were added or removed, restart to apply")
+    }
+  }
+
+  members := make([]*member, 0, len(consumers))
+  for _, consumerConfig := range consumers {
+    current := byName[memberName(consumerConfig)]
+    if (!sameConfig(current.config, consumerConfig.Config)) {
+      reconfigurable, ok :=
+        current.eventConsumer.(interfaces.Reconfigurable)
+      if (!ok) {
+        set.restore(members)
+        return fmt.Errorf("consumer %s does not support reload, restart " +
+                          "to apply", current.name)
+      }
+      err = reconfigurable.Reconfigure(consumerConfig.Config)
+      if (err != nil) {
+        set.restore(members)
+        return fmt.Errorf("consumer %s: %s", current.name, err)
+      }
+    }
+    updated := *current
+    updated.events = eventSet(consumerConfig.Events)
+    updated.config = consumerConfig.Config
+    members = append(members, &updated)
+  }
+  set.members = members
+  return nil
+}
+
+// This method will give the members reconfigured by a failed reload their
+// previous configuration block back.
+//
+// Args:
+//    reconfigured : Members reconfigured, with their new block.
+// Returns:
+//    None.
+func (set *ConsumerSet) restore(reconfigured []*member) {
+  for _, updated := range reconfigured {
+    reconfigurable, ok := updated.eventConsumer.(interfaces.Reconfigurable)
+    if (!ok) {
+      continue
+    }
+    var previous json.RawMessage
+    for _, member := range set.members {
+      if (member.name == updated.name) {
+        previous = member.config
+      }
+    }
+    if (sameConfig(previous, updated.config)) {
+      continue
+    }
+    if err := reconfigurable.Reconfigure(previous); (err != nil) {
+      logger.Error("Failed to restore the consumer config.",
+                   logger.ConsumerKey, updated.name, logger.ErrorKey, err)
+    }
+  }
+}
+
+// This method will invoke the member's callback, converting a panic into a
+// PanicError for the other members to still get the event.
+//
+// Args:
+//    event : Event object.
+// Returns:
+//    error : Error returned by the member or PanicError.
+func (member *member) onEvent(event schema.Event) (err error) {
+  defer func() {
+    if value := recover(); (value != nil) {
+      err = &lib.PanicError{Value: value, Stack: debug.Stack()}
+    }
+  }()
+  return member.eventConsumer.OnEvent(event)
+}
+
+// This method will return the name of a consumer in the set: its name if
+// configured, otherwise its type.
+//
+// Args:
+//    consumerConfig : Consumer configuration.
+// Returns:
+//    string : Name of the consumer.
+func memberName(consumerConfig schema.ConsumerConfig) (string) {
+  if (consumerConfig.Name != "") {
+    return consumerConfig.Name
+  }
+  return consumerConfig.Type
+}
+
+// This method will check if two configuration blocks are the same, their
+// JSON formatting aside.
+//
+// Args:
+//    config : Configuration block, as JSON.
+//    other : Configuration block to compare it with, as JSON.
+// Returns:
+//    bool : True if the blocks are the same.
+func sameConfig(config []byte, other []byte) (bool) {
+  var compacted, otherCompacted bytes.Buffer
+  if (json.Compact(&compacted, config) != nil ||
+      json.Compact(&otherCompacted, other) != nil) {
+    return bytes.Equal(config, other)
+  }
+  return bytes.Equal(compacted.Bytes(), otherCompacted.Bytes())
+}
+
+// This method will return the event types of a consumer as a set.
+//
+// Args:
+//    events : Event types, all if empty.
+// Returns:
+//    map[string]bool : Set of the event types, nil for all of them.
+func eventSet(events []string) (map[string]bool) {
+  if (len(events) == 0) {
+    return nil
+  }
+  set := map[string]bool{}
+  for _, eventType := range events {
+    set[eventType] = true
+  }
+  return set
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+//
+// This test package apply unit tests on the consumer registry & the
+// consumer sets built from it.
+//
+
+package registry
+
+import (
+  "aplos/partners/WebhooksListener/interfaces"
+  "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/schemas"
+  "encoding/json"
+  "errors"
+  "strings"
+  "testing"
+  "time"
+)
+
+// Consumer recording the events it receives, failing if configured to.
+type recordingConsumer struct {
+  config string
+  events *[]string
+}
+
+func (consumer *recordingConsumer) OnEvent(event schema.Event) (error) {
+  *consumer.events = append(*consumer.events,
+    consumer.config + ":" + event.Event_Type)
+  if (consumer.config == "fail") {
+    return lib.Retryable(errors.New("failed"))
+  }
+  if (consumer.config == "panic") {
+    panic("consumer panicked")
+  }
+  return nil
+}
+
+func (consumer *recordingConsumer) Reconfigure(config []byte) (error) {
+  if (string(config) == `"invalid"`) {
+    return errors.New("invalid config")
+  }
+  consumer.config = string(config)
+  return nil
+}
+
+// This method will register the recording consumer type.
+//
+// Args:
+//    None.
+// Returns:
+//    *[]string : Events received by the consumers of the type.
+func registerRecording() (*[]string) {
+  events := &[]string{}
+  Register("recording", func(config []byte) (interfaces.EventConsumer,
+    error) {
+    if (string(config) == "invalid") {
+      return nil, errors.New("invalid config")
+    }
+    return &recordingConsumer{config: string(config), events: events}, nil
+  })
+  return events
+}
+
+// Test to verify consumers are built by type, with their config blocks.
+func TestNew(t *testing.T) {
+  registerRecording()
+  if _, err := New("unknown", nil); (err == nil ||
+      !strings.Contains(err.Error(), "must be one of: recording")) {
+    t.Errorf("Expected an unknown type error, got %v", err)
+  }
+  if _, err := New("recording", []byte("invalid")); (err == nil) {
+    t.Errorf("Expected the factory error")
+  }
+  _, err := NewConsumerSet([]schema.ConsumerConfig{
+    {Type: "recording"}, {Type: "recording"},
+  })
+  if (err == nil || !strings.Contains(err.Error(), "configured twice")) {
+    t.Errorf("Expected a duplicate consumer error, got %v", err)
+  }
+}
+
+// Test to verify a set hands the events to the subscribed members only, in
+// spite of the failures of the others, & retries the failed members only.
+func TestConsumerSet(t *testing.T) {
+  events := registerRecording()
+  set, err := NewConsumerSet([]schema.ConsumerConfig{
+    {Type: "recording", Name: "a", Config: json.RawMessage("panic"),
+     Events: []string{lib.VM_ON}},
+    {Type: "recording", Name: "b", Config: json.RawMessage("fail")},
+    {Type: "recording", Name: "c", Config: json.RawMessage("ok"),
+     Events: []string{lib.VM_OFF}},
+  })
+  if (err != nil) {
+    t.Fatalf("Failed to build the consumer set: %s", err)
+  }
+  set.RetryPolicy = schema.RetryPolicy{MaxAttempts: 2,
+                                       InitialBackoff: time.Millisecond}
+
+  err = set.OnEvent(schema.Event{Event_Type: lib.VM_ON})
+  var panicErr *lib.PanicError
+  if (!errors.As(err, &panicErr) || lib.IsRetryable(err)) {
+    t.Errorf("Expected a permanent error with the panic, got %v", err)
+  }
+  set.OnEvent(schema.Event{Event_Type: lib.VM_OFF})
+  expected := "panic:VM.ON panic:VM.ON fail:VM.ON fail:VM.ON fail:VM.OFF " +
+    "fail:VM.OFF ok:VM.OFF"
+  if (strings.Join(*events, " ") != expected) {
+    t.Errorf("Expected events %s, got %v", expected, *events)
+  }
+
+  // Reconfiguring keeps the members, with their new blocks & events.
+  consumers, _ := json.Marshal([]schema.ConsumerConfig{
+    {Type: "recording", Name: "a", Config: json.RawMessage(`"ok"`)},
+    {Type: "recording", Name: "b", Config: json.RawMessage(`"ok"`)},
+    {Type: "recording", Name: "c", Config: json.RawMessage(`"ok"`)},
+  })
+  err = set.Reconfigure(consumers)
+  if (err != nil) {
+    t.Fatalf("Failed to reconfigure the consumer set: %s", err)
+  }
+  *events = nil
+  if err = set.OnEvent(schema.Event{Event_Type: lib.VM_ON}); (err != nil) {
+    t.Errorf("Expected no error, got %s", err)
+  }
+  if (len(*events) != 3) {
+    t.Errorf("Expected all members to get the event, got %v", *events)
+  }
+
+  // A rejected block leaves all the members with their previous block.
+  consumers, _ = json.Marshal([]schema.ConsumerConfig{
+    {Type: "recording", Name: "a", Config: json.RawMessage(`"new"`)},
+    {Type: "recording", Name: "b", Config: json.RawMessage(`"invalid"`)},
+    {Type: "recording", Name: "c", Config: json.RawMessage(`"new"`)},
+  })
+  if err = set.Reconfigure(consumers); (err == nil) {
+    t.Errorf("Expected an error for the invalid block")
+  }
+  for _, member := range set.members {
+    config := member.eventConsumer.(*recordingConsumer).config
+    if (config != `"ok"`) {
+      t.Errorf("Expected consumer %s to keep its block, got %s", member.name,
+               config)
+    }
+  }
+
+  consumers, _ = json.Marshal([]schema.ConsumerConfig{
+    {Type: "recording", Name: "a"},
+  })
+  if err = set.Reconfigure(consumers); (err == nil) {
+    t.Errorf("Expected an error for removed consumers")
+  }
+}
+
+// Consumer without reload support.
+type staticConsumer struct{}
+
+func (consumer staticConsumer) OnEvent(event schema.Event) (error) {
+  return nil
+}
+
+// Test to verify a changed block of a member without reload support fails
+// the reload & restores the members already reconfigured.
+func TestConsumerSetReloadNotSupported(t *testing.T) {
+  registerRecording()
+  Register("static", func(config []byte) (interfaces.EventConsumer, error) {
+    return staticConsumer{}, nil
+  })
+  set, err := NewConsumerSet([]schema.ConsumerConfig{
+    {Type: "recording", Config: json.RawMessage(`"ok"`)},
+    {Type: "static", Config: json.RawMessage(`{"a": 1}`)},
+  })
+  if (err != nil) {
+    t.Fatalf("Failed to build the consumer set: %s", err)
+  }
+  recording := set.members[0].eventConsumer.(*recordingConsumer)
+
+  consumers, _ := json.Marshal([]schema.ConsumerConfig{
+    {Type: "recording", Config: json.RawMessage(`"new"`)},
+    {Type: "static", Config: json.RawMessage(`{"a": 2}`)},
+  })
+  err = set.Reconfigure(consumers)
+  if (err == nil || !strings.Contains(err.Error(),
+      "consumer static does not support reload")) {
+    t.Errorf("Expected a reload error, got %v", err)
+  }
+  if (recording.config != `"ok"`) {
+    t.Errorf("Expected the previous block to be restored, got %s",
+             recording.config)
+  }
+
+  // An unchanged block does not need reload support.
+  consumers, _ = json.Marshal([]schema.ConsumerConfig{
+    {Type: "recording", Config: json.RawMessage(`"new"`)},
+    {Type: "static", Config: json.RawMessage(`{"a": 1}`)},
+  })
+  if err = set.Reconfigure(consumers); (err != nil) {
+    t.Errorf("Expected the reload to succeed, got %s", err)
+  }
+  if (recording.config != `"new"`) {
+    t.Errorf("Expected the new block, got %s", recording.config)
+  }
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+//
+// Description:
+//
+// The consumer schema file comprises of the configuration blocks of the
+// consumers built into the listener, registered under the consumer types
+// named below.
+//
+package schema
+
+// Configuration block of a consumer of type "exec", running a command per
+// event with the event JSON on stdin.
+type ExecConsumerConfig struct {
+  // Command & arguments by event type, "*" for the other event types.
+  Commands map[string][]string `json:"commands"`
+  Env []string `json:"env"` // Added to the environment, as KEY=value.
+  Dir string `json:"dir"`
+  Timeout Duration `json:"timeout"`
+  RetryableExitCodes []int `json:"retryable_exit_codes"` // 75 if empty.
+}
+
+// Configuration block of a consumer of type "rest", sending HTTP calls per
+// event. The URLs, headers, bodies, conditions & captures of the steps are
+// Go templates over the event, see consumers/RESTEventConsumer.go.
+type RESTConsumerConfig struct {
+  Username string `json:"username"`
+  Password string `json:"password"`
+  Proxy ProxyConfig `json:"proxy"`
+  Headers map[string]string `json:"headers"` // Sent with every step.
+  Vars map[string]string `json:"vars"` // Available to the templates.
+  Timeout Duration `json:"timeout"` // Of each step.
+  // Steps by event type, "*" for the other event types.
+  Events map[string][]RESTStep `json:"events"`
+}
+
+// HTTP call of a REST consumer.
+type RESTStep struct {
+  Name string `json:"name"`
+  When string `json:"when"` // The step is skipped unless it renders true.
+  Method string `json:"method"`
+  URL string `json:"url"`
+  Headers map[string]string `json:"headers"`
+  Body string `json:"body"`
+  ExpectStatus []int `json:"expect_status"` // Any 2xx if empty.
+  // Values captured from the response into the vars of the next steps.
+  Capture map[string]string `json:"capture"`
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+//
+// Description:
+//
+// The entity schema file comprises of data structures representing the
+// payload of the event notifications for the entities other than VMs:
+// subnets, hosts & clusters. The payload is found under data.metadata of
+// the event, in the same layout as the VM one: status, spec & metadata.
+//
+package schema
+
+// Payload of the SUBNET.* events.
+type SubnetMetadata struct {
+  Status SubnetStatus `json:"status"`
+  Spec SubnetSpec `json:"spec"`
+  APIVersion string `json:"api_version"`
+  SubMetadata EventSubMetadata `json:"metadata"`
+}
+
+type SubnetStatus struct {
+  State string `json:"state"`
+  Name string `json:"name"`
+  Description string `json:"description"`
+  Resources SubnetResources `json:"resources"`
+  ClusterReference ResourceReference `json:"cluster_reference"`
+  MessageList []Message `json:"message_list"`
+}
+
+type SubnetSpec struct {
+  Name string `json:"name"`
+  Description string `json:"description"`
+  Resources SubnetResources `json:"resources"`
+  ClusterReference ResourceReference `json:"cluster_reference"`
+}
+
+type SubnetResources struct {
+  SubnetType string `json:"subnet_type"`
+  VLANID int `json:"vlan_id"`
+  VswitchName string `json:"vswitch_name"`
+  NetworkFunctionChainReference ResourceReference `json:"network_function_chain_reference"`
+  IPConfig SubnetIPConfig `json:"ip_config"`
+}
+
+type SubnetIPConfig struct {
+  SubnetIP string `json:"subnet_ip"`
+  PrefixLength int `json:"prefix_length"`
+  DefaultGatewayIP string `json:"default_gateway_ip"`
+  PoolList []SubnetPool `json:"pool_list"`
+}
+
+type SubnetPool struct {
+  Range string `json:"range"`
+}
+
+// Payload of the HOST.* events.
+type HostMetadata struct {
+  Status HostStatus `json:"status"`
+  APIVersion string `json:"api_version"`
+  SubMetadata EventSubMetadata `json:"metadata"`
+}
+
+type HostStatus struct {
+  State string `json:"state"`
+  Name string `json:"name"`
+  Resources HostResources `json:"resources"`
+  ClusterReference ResourceReference `json:"cluster_reference"`
+}
+
+type HostResources struct {
+  HostType string `json:"host_type"`
+  SerialNumber string `json:"serial_number"`
+  BlockSerialNumber string `json:"block_serial_number"`
+  CPUModel string `json:"cpu_model"`
+  NumCPUSockets int `json:"num_cpu_sockets"`
+  NumCPUCores int `json:"num_cpu_cores"`
+  MemoryCapacityMiB int `json:"memory_capacity_mib"`
+  Hypervisor HostHypervisor `json:"hypervisor"`
+  ControllerVM HostControllerVM `json:"controller_vm"`
+}
+
+type HostHypervisor struct {
+  IP string `json:"ip"`
+  HypervisorFullName string `json:"hypervisor_full_name"`
+  NumVMs int `json:"num_vms"`
+}
+
+type HostControllerVM struct {
+  IP string `json:"ip"`
+}
+
+// Payload of the CLUSTER.* events.
+type ClusterMetadata struct {
+  Status ClusterStatus `json:"status"`
+  APIVersion string `json:"api_version"`
+  SubMetadata EventSubMetadata `json:"metadata"`
+}
+
+type ClusterStatus struct {
+  State string `json:"state"`
+  Name string `json:"name"`
+  Resources ClusterResources `json:"resources"`
+}
+
+type ClusterResources struct {
+  Config ClusterConfig `json:"config"`
+  Network ClusterNetwork `json:"network"`
+}
+
+type ClusterConfig struct {
+  ServiceList []string `json:"service_list"`
+  Build ClusterBuild `json:"build"`
+  Timezone string `json:"timezone"`
+}
+
+type ClusterBuild struct {
+  Version string `json:"version"`
+  FullVersion string `json:"full_version"`
+}
+
+type ClusterNetwork struct {
+  ExternalIP string `json:"external_ip"`
+  ExternalDataServicesIP string `json:"external_data_services_ip"`
+  NameServerIPList []string `json:"name_server_ip_list"`
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+//
+// Description:
+//
+// The listener configuration schema file comprises of the data structures of
+// the listener configuration file: the clusters to listen to, the callback
+// server, the event subscriptions, the event consumers with their own
+// configuration, the event dispatch & the logging. The configuration file is
+// validated against config/listener_config.schema.json before it is decoded
+// into these structures.
+//
+package schema
+
+import (
+  "encoding/json"
+  "fmt"
+  "time"
+)
+
+type ListenerConfig struct {
+  Clusters []NutanixClusterConfig `json:"clusters"`
+  Listener ServerConfig `json:"listener"`
+  Events []string `json:"events"`
+  SubscriptionMode string `json:"subscription_mode"`
+  Consumers []ConsumerConfig `json:"consumers"`
+  Dispatch DispatchConfig `json:"dispatch"`
+  Logging LoggingConfig `json:"logging"`
+}
+
+// Nutanix cluster to listen to. The password is given in the file, in an
+// environment variable or in a file.
+type NutanixClusterConfig struct {
+  Name string `json:"name"`
+  IP string `json:"ip"`
+  Port string `json:"port"`
+  Endpoints []string `json:"endpoints"`
+  Username string `json:"username"`
+  Password string `json:"password"`
+  PasswordEnv string `json:"password_env"`
+  PasswordFile string `json:"password_file"`
+  Proxy ProxyConfig `json:"proxy"`
+  ListenerPort string `json:"listener_port"` // Overrides listener.port.
+  AdvertiseURL string `json:"advertise_url"` // Overrides listener's one.
+}
+
+// Callback server of the listener.
+type ServerConfig struct {
+  Port string `json:"port"`
+  BindAddress string `json:"bind_address"`
+  UnixSocket string `json:"unix_socket"`
+  CallbackPath string `json:"callback_path"`
+  AdvertiseURL string `json:"advertise_url"`
+  ListenerID string `json:"listener_id"`
+  ListenerIDFile string `json:"listener_id_file"`
+  TLS TLSConfig `json:"tls"`
+  Auth CallbackAuthConfig `json:"auth"`
+  Limits ServerLimitsConfig `json:"limits"`
+  SkipReachabilityCheck bool `json:"skip_reachability_check"`
+  ProbeHelperURL string `json:"probe_helper_url"`
+  WatchdogInterval Duration `json:"watchdog_interval"`
+}
+
+type TLSConfig struct {
+  CertFile string `json:"cert_file"`
+  KeyFile string `json:"key_file"`
+}
+
+// Authorization of the event notifications posted by Prism.
+type CallbackAuthConfig struct {
+  Username string `json:"username"`
+  Password string `json:"password"`
+  AllowedSources []string `json:"allowed_sources"`
+}
+
+type ServerLimitsConfig struct {
+  ReadHeaderTimeout Duration `json:"read_header_timeout"`
+  ReadTimeout Duration `json:"read_timeout"`
+  WriteTimeout Duration `json:"write_timeout"`
+  IdleTimeout Duration `json:"idle_timeout"`
+  MaxHeaderBytes int `json:"max_header_bytes"`
+  MaxConnections int `json:"max_connections"`
+}
+
+// Event consumer & its own configuration block, decoded by the consumer.
+type ConsumerConfig struct {
+  Type string `json:"type"`
+  Name string `json:"name"`
+  Events []string `json:"events"` // Subset of the events, all if empty.
+  Config json.RawMessage `json:"config"`
+}
+
+type DispatchConfig struct {
+  QueueSize int `json:"queue_size"`
+  MaxEventSize int64 `json:"max_event_size"`
+  MaxAttempts int `json:"max_attempts"`
+  InitialBackoff Duration `json:"initial_backoff"`
+  MaxBackoff Duration `json:"max_backoff"`
+}
+
+type LoggingConfig struct {
+  Level string `json:"level"` // debug, info, warn or error.
+  Format string `json:"format"` // text, json or glog.
+  // Secrets to mask in the logs, on top of the built-in ones.
+  RedactFields []string `json:"redact_fields"` // JSON fields.
+  RedactParams []string `json:"redact_params"` // URL query parameters.
+  RedactHeaders []string `json:"redact_headers"` // HTTP headers.
+}
+
+// Duration given as a Go duration string, e.g. "30s" or "1m30s".
+type Duration time.Duration
+
+func (duration *Duration) UnmarshalJSON(data []byte) (error) {
+  var text string
+  err := json.Unmarshal(data, &text)
+  if (err != nil) {
+    return fmt.Errorf("duration must be a string, e.g. \"30s\"")
+  }
+  parsed, err := time.ParseDuration(text)
+  if (err != nil) {
+    return err
+  }
+  *duration = Duration(parsed)
+  return nil
+}
+
+func (duration Duration) MarshalJSON() ([]byte, error) {
+  return json.Marshal(time.Duration(duration).String())
+}
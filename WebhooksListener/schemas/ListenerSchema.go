@@ -14,12 +14,79 @@
 //
 package schema
 
+import (
+  "context"
+  "encoding/json"
+  "errors"
+  "strings"
+)
+
 // Schema definition for the webhook event.
 type Event struct {
   EntityReference Reference `json:"entity_reference"`
+  // VM view of the event data. Use Payload for the other entity kinds.
   Data Data `json:"data"`
   Version string `json:"version"`
   Event_Type string `json:"event_type"`
+
+  // Event JSON as received, for the fields not covered by the schema.
+  Raw json.RawMessage `json:"-"`
+
+  // Payload decoded as per the entity kind, e.g. *EventMetadata for VMs,
+  // *SubnetMetadata for subnets. Raw JSON of the data for unknown kinds.
+  Payload interface{} `json:"-"`
+
+  // Context of the event handling, carrying its trace.
+  ctx context.Context
+}
+
+// This method will unmarshal the event JSON, keeping a copy of it in Raw.
+// The data of non-VM events may not fit the VM view: such mismatches are
+// ignored, leaving the mismatching fields of Data unset.
+//
+// Args:
+//    data : Event JSON.
+// Returns:
+//    error : Error, if any.
+func (event *Event) UnmarshalJSON(data []byte) (error) {
+  type plainEvent Event
+  var decoded plainEvent
+  err := json.Unmarshal(data, &decoded)
+  var typeErr *json.UnmarshalTypeError
+  if (err != nil && !(errors.As(err, &typeErr) &&
+      strings.HasPrefix(typeErr.Field, "data.") &&
+      !strings.EqualFold(decoded.EntityReference.KIND, "vm"))) {
+    return err
+  }
+  *event = Event(decoded)
+  event.Raw = append(json.RawMessage(nil), data...)
+  return nil
+}
+
+// This method will return the context of the event handling. Consumers pass
+// it on to their outbound requests so that these are traced as part of the
+// event.
+//
+// Args:
+//    None.
+// Returns:
+//    Context : Event context, never nil.
+func (event Event) Context() (context.Context) {
+  if (event.ctx == nil) {
+    return context.Background()
+  }
+  return event.ctx
+}
+
+// This method will return a copy of the event with the given context.
+//
+// Args:
+//    ctx : Context of the event handling.
+// Returns:
+//    Event : Copy of the event.
+func (event Event) WithContext(ctx context.Context) (Event) {
+  event.ctx = ctx
+  return event
 }
 
 type Reference struct {
@@ -41,12 +108,21 @@ type EventMetadata struct {
 type Status struct {
   State string `json:"state"`
   Name string `json:"name"`
+  Description string `json:"description"`
   Resources EventResources `json:"resources"`
+  ClusterReference ResourceReference `json:"cluster_reference"`
+  MessageList []Message `json:"message_list"`
 }
 
+// Desired state of the VM.
 type EventSpec struct {
+  Name string `json:"name"`
+  Description string `json:"description"`
+  Resources EventResources `json:"resources"`
+  ClusterReference ResourceReference `json:"cluster_reference"`
 }
 
+// Resources of the VM, in both the spec & the status.
 type EventResources struct {
   NICList []NIC `json:"nic_list"`
   HostReference HostReference `json:"host_reference"`
@@ -54,19 +130,88 @@ type EventResources struct {
   NumVCPUsPerSocket int `json:"num_vcpus_per_socket"`
   NumSockets int `json:"num_sockets"`
   MemorySizeMB int `json:"memory_size_mb"`
-  GPUList []string `json:"gpu_list"`
+  MemorySizeMiB int `json:"memory_size_mib"`
+  GPUList []GPU `json:"gpu_list"`
   PowerState string `json:"power_state"`
   DiskList []DiskList `json:"disk_list"`
+  BootConfig BootConfig `json:"boot_config"`
+  GuestTools GuestTools `json:"guest_tools"`
+  HardwareClockTimezone string `json:"hardware_clock_timezone"`
+  VGAConsoleEnabled bool `json:"vga_console_enabled"`
+  MachineType string `json:"machine_type"`
 }
 
 type NIC struct {
+  UUID string `json:"uuid"`
+  NICType string `json:"nic_type"`
+  Model string `json:"model"`
   IPEndPointList []IPEndPointList `json:"ip_endpoint_list"`
   NetworkReference NetworkReference `json:"network_reference"`
+  SubnetReference ResourceReference `json:"subnet_reference"`
   MACAddress string `json:"mac_address"`
+  IsConnected bool `json:"is_connected"`
+  VLANMode string `json:"vlan_mode"`
+  TrunkedVLANs []int `json:"trunked_vlans"`
+  NetworkFunctionNICType string `json:"network_function_nic_type"`
+  NetworkFunctionChainReference ResourceReference `json:"network_function_chain_reference"`
+  NumQueues int `json:"num_queues"`
 }
 
 type IPEndPointList struct {
   IPAddress string `json:"ip"`
+  Type string `json:"type"`
+}
+
+// Reference to another entity, e.g. a subnet or a cluster.
+type ResourceReference struct {
+  Kind string `json:"kind"`
+  UUID string `json:"uuid"`
+  Name string `json:"name"`
+}
+
+type Message struct {
+  Message string `json:"message"`
+  Reason string `json:"reason"`
+  Details map[string]string `json:"details"`
+}
+
+type GPU struct {
+  UUID string `json:"uuid"`
+  Vendor string `json:"vendor"`
+  Mode string `json:"mode"`
+  DeviceID int `json:"device_id"`
+  Name string `json:"name"`
+  PCIAddress string `json:"pci_address"`
+  FrameBufferSizeMiB int `json:"frame_buffer_size_mib"`
+  NumVirtualDisplayHeads int `json:"num_virtual_display_heads"`
+  GuestDriverVersion string `json:"guest_driver_version"`
+  Fraction int `json:"fraction"`
+}
+
+type BootConfig struct {
+  BootType string `json:"boot_type"`
+  BootDeviceOrderList []string `json:"boot_device_order_list"`
+  BootDevice BootDevice `json:"boot_device"`
+}
+
+type BootDevice struct {
+  DiskAddress DiskAddress `json:"disk_address"`
+  MACAddress string `json:"mac_address"`
+}
+
+type GuestTools struct {
+  NutanixGuestTools NutanixGuestTools `json:"nutanix_guest_tools"`
+}
+
+type NutanixGuestTools struct {
+  State string `json:"state"`
+  Version string `json:"version"`
+  AvailableVersion string `json:"available_version"`
+  ISOMountState string `json:"iso_mount_state"`
+  GuestOSVersion string `json:"guest_os_version"`
+  IsReachable bool `json:"is_reachable"`
+  VSSSnapshotCapable bool `json:"vss_snapshot_capable"`
+  EnabledCapabilityList []string `json:"enabled_capability_list"`
 }
 
 type NetworkReference struct {
@@ -80,7 +225,16 @@ type HostReference struct {
 }
 
 type DiskList struct {
+  UUID string `json:"uuid"`
+  DiskSizeMiB int `json:"disk_size_mib"`
+  DiskSizeBytes int64 `json:"disk_size_bytes"`
   DeviceProperties DeviceProperties `json:"device_properties"`
+  DataSourceReference ResourceReference `json:"data_source_reference"`
+  StorageConfig StorageConfig `json:"storage_config"`
+}
+
+type StorageConfig struct {
+  StorageContainerReference ResourceReference `json:"storage_container_reference"`
 }
 
 type DeviceProperties struct {
@@ -98,7 +252,13 @@ type EventSubMetadata struct {
   Kind string `json:"kind"`
   EntityVersion int `json:"entity_version"`
   UUID string `json:"uuid"`
-  Categories Categories `json:"categories"`
+  Categories map[string]string `json:"categories"`
+  CategoriesMapping map[string][]string `json:"categories_mapping"`
+  Name string `json:"name"`
+  SpecVersion int `json:"spec_version"`
+  CreationTime string `json:"creation_time"`
+  LastUpdateTime string `json:"last_update_time"`
+  ProjectReference ResourceReference `json:"project_reference"`
 }
 
 type OwnerReference struct {
@@ -107,6 +267,3 @@ type OwnerReference struct {
   Name string `json:"name"`
 }
 
-type Categories struct {
-  NetworkFunctionProvider string `json:"network_function_provider"`
-}
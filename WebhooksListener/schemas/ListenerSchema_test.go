@@ -0,0 +1,78 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+//
+// This test package apply unit tests on the unmarshalling of the webhook
+// events.
+//
+
+package schema
+
+import (
+  "encoding/json"
+  "testing"
+)
+
+const vmEvent = `{
+  "event_type": "VM.ON",
+  "version": "1.0",
+  "entity_reference": {"kind": "vm", "uuid": "vm-1"},
+  "data": {"metadata": {
+    "status": {
+      "name": "web-1", "state": "COMPLETE",
+      "cluster_reference": {"kind": "cluster", "uuid": "c-1", "name": "pod1"},
+      "resources": {
+        "power_state": "ON",
+        "nic_list": [{"nic_type": "NORMAL_NIC", "vlan_mode": "ACCESS",
+                      "subnet_reference": {"kind": "subnet", "uuid": "s-1",
+                                           "name": "vlan10"},
+                      "ip_endpoint_list": [{"ip": "10.1.1.20",
+                                            "type": "ASSIGNED"}]}],
+        "gpu_list": [{"vendor": "NVIDIA", "mode": "PASSTHROUGH_GRAPHICS"}],
+        "boot_config": {"boot_type": "UEFI"},
+        "guest_tools": {"nutanix_guest_tools": {"state": "ENABLED"}}
+      }
+    },
+    "spec": {"name": "web-1", "resources": {"num_sockets": 2}},
+    "metadata": {
+      "kind": "vm", "uuid": "vm-1",
+      "categories": {"network_function_provider": "pafw", "env": "prod"},
+      "categories_mapping": {"env": ["prod"]}
+    },
+    "unknown_field": {"kept": true}
+  }}
+}`
+
+// Test to verify the VM fields, categories & raw payload of an event.
+func TestUnmarshalEvent(t *testing.T) {
+  var event Event
+  err := json.Unmarshal([]byte(vmEvent), &event)
+  if (err != nil) {
+    t.Fatalf("Failed to unmarshal event: %s", err)
+  }
+  status := event.Data.Metadata.Status
+  nic := status.Resources.NICList[0]
+  if (nic.SubnetReference.Name != "vlan10" || nic.VLANMode != "ACCESS" ||
+      nic.NICType != "NORMAL_NIC" ||
+      nic.IPEndPointList[0].IPAddress != "10.1.1.20") {
+    t.Errorf("Unexpected NIC %+v", nic)
+  }
+  if (status.ClusterReference.Name != "pod1" ||
+      status.Resources.GPUList[0].Vendor != "NVIDIA" ||
+      status.Resources.BootConfig.BootType != "UEFI" ||
+      status.Resources.GuestTools.NutanixGuestTools.State != "ENABLED" ||
+      event.Data.Metadata.Spec.Resources.NumSockets != 2) {
+    t.Errorf("Unexpected status %+v", status)
+  }
+  metadata := event.Data.Metadata.SubMetadata
+  if (metadata.Categories["network_function_provider"] != "pafw" ||
+      metadata.Categories["env"] != "prod" ||
+      metadata.CategoriesMapping["env"][0] != "prod") {
+    t.Errorf("Unexpected categories %+v", metadata)
+  }
+
+  var raw map[string]interface{}
+  err = json.Unmarshal(event.Raw, &raw)
+  data := raw["data"].(map[string]interface{})["metadata"]
+  if (err != nil || data.(map[string]interface{})["unknown_field"] == nil) {
+    t.Errorf("Expected raw payload to keep unknown fields, got %s", event.Raw)
+  }
+}
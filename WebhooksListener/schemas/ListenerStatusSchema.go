@@ -0,0 +1,64 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+//
+// Description:
+//
+// The listener status schema file comprises of data structures representing
+// the health & readiness details reported by the listener on its health
+// endpoints, for the use of operators & orchestration tools, and the
+// responses of the callback endpoint.
+//
+package schema
+
+// Response of the callback endpoint. Error is set when the event is
+// rejected.
+type CallbackResponse struct {
+  Status string `json:"status"`
+  Error string `json:"error,omitempty"`
+  Token string `json:"token,omitempty"` // Token of a reachability probe.
+}
+
+// Request to the external probe helper, asking it to GET the URL with the
+// given headers.
+type ProbeRequest struct {
+  URL string `json:"url"`
+  Headers map[string]string `json:"headers"`
+}
+
+// Response of the external probe helper.
+type ProbeResponse struct {
+  StatusCode int `json:"status_code"`
+  Body string `json:"body"`
+  Error string `json:"error,omitempty"`
+}
+
+// Response of the liveness endpoint.
+type HealthStatus struct {
+  Status string `json:"status"`
+}
+
+// Response of the readiness endpoint.
+type ReadinessStatus struct {
+  Ready bool `json:"ready"`
+  Webhook WebhookReadiness `json:"webhook"`
+  Prism PrismReadiness `json:"prism"`
+  Consumers []ConsumerReadiness `json:"consumers"`
+}
+
+type WebhookReadiness struct {
+  Registered bool `json:"registered"`
+  UUID string `json:"uuid,omitempty"`
+  State string `json:"state,omitempty"`
+}
+
+type PrismReadiness struct {
+  Reachable bool `json:"reachable"`
+  LastContact string `json:"last_contact,omitempty"`
+  Endpoint string `json:"endpoint,omitempty"` // Prism endpoint in use.
+  Error string `json:"error,omitempty"`
+}
+
+type ConsumerReadiness struct {
+  Name string `json:"name"`
+  Healthy bool `json:"healthy"`
+  Error string `json:"error,omitempty"`
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+//
+// Description:
+//
+// The plugin schema file comprises of the data structures of the out of
+// process consumers: the configuration of a plugin & the JSON-RPC 2.0
+// messages exchanged with it. The protocol is described in
+// plugin/PROTOCOL.md.
+//
+package schema
+
+import (
+  "encoding/json"
+)
+
+// Configuration block of a consumer of type "plugin". The plugin is either
+// launched by the listener, or listening on a unix socket.
+type PluginConfig struct {
+  Command []string `json:"command"` // Executable & arguments.
+  Env []string `json:"env"` // Added to the environment, as KEY=value.
+  Dir string `json:"dir"`
+  Socket string `json:"socket"` // Unix socket, instead of Command.
+  CallTimeout Duration `json:"call_timeout"`
+  Config json.RawMessage `json:"config"` // Block of the plugin itself.
+}
+
+type RPCRequest struct {
+  JSONRPC string `json:"jsonrpc"`
+  ID *uint64 `json:"id,omitempty"` // Nil for notifications.
+  Method string `json:"method"`
+  Params json.RawMessage `json:"params,omitempty"`
+}
+
+type RPCResponse struct {
+  JSONRPC string `json:"jsonrpc"`
+  ID *uint64 `json:"id"`
+  Result json.RawMessage `json:"result,omitempty"`
+  Error *RPCError `json:"error,omitempty"`
+}
+
+type RPCError struct {
+  Code int `json:"code"`
+  Message string `json:"message"`
+  Data *RPCErrorData `json:"data,omitempty"`
+}
+
+type RPCErrorData struct {
+  Retryable bool `json:"retryable"`
+}
+
+type HandshakeParams struct {
+  ProtocolVersion int `json:"protocol_version"`
+  Name string `json:"name"`
+  Config json.RawMessage `json:"config,omitempty"`
+}
+
+type HandshakeResult struct {
+  ProtocolVersion int `json:"protocol_version"`
+  Name string `json:"name"`
+}
+
+type EventParams struct {
+  Event json.RawMessage `json:"event"` // Event as received from Prism.
+  TraceContext map[string]string `json:"trace_context,omitempty"`
+}
+
+type ReconfigureParams struct {
+  Config json.RawMessage `json:"config"`
+}
@@ -10,6 +10,8 @@
 //
 package schema
 
+import "time"
+
 // Create Webhook
 type WebhookCreationSpec struct {
   Metadata Metadata `json:"metadata"`
@@ -31,11 +33,20 @@ type Spec struct {
 type Resources struct {
   PostUrl string `json:"post_url"`
   EventsFilterList []string `json:"events_filter_list"`
+  Credentials *WebhookCredentials `json:"credentials,omitempty"`
+}
+
+// Basic auth credentials sent by Prism with every event notification.
+type WebhookCredentials struct {
+  Username string `json:"username"`
+  Password string `json:"password"`
 }
 
 // List webhooks.
 type WebhooksListSpec struct{
   Kind string `json:"kind"`
+  Length int `json:"length,omitempty"`
+  Offset int `json:"offset,omitempty"`
 }
 
 // Used to store details of the existing webhooks created by this listener.
@@ -82,4 +93,36 @@ type WebhookMetadata struct {
   Kind string `json:"kind"`
   UUID string `json:"uuid"`
   SpecVersion int `json:"spec_version"`
+  CreationTime string `json:"creation_time,omitempty"`
+  LastUpdateTime string `json:"last_update_time,omitempty"`
+}
+
+// Cluster details, as reported by the v2.0 cluster API.
+type ClusterInfo struct {
+  Name string `json:"name"`
+  Version string `json:"version"`
+  FullVersion string `json:"full_version"`
+}
+
+// Options of the collection of the orphaned webhooks.
+type WebhookGCOptions struct {
+  NamePattern string // Glob on the webhook names, default all listener ones.
+  MinAge time.Duration // Webhooks created more recently are kept.
+  ProbeTimeout time.Duration // Timeout of the post_url reachability probe.
+  Delete bool // Delete the orphaned webhooks, only report them otherwise.
+  // Asked before deleting each orphaned webhook, which is kept unless it
+  // returns true. The webhooks are deleted without asking if nil.
+  Confirm func(result WebhookGCResult) (bool)
+}
+
+// Outcome of the collection for a webhook.
+type WebhookGCResult struct {
+  UUID string `json:"uuid"`
+  Name string `json:"name"`
+  PostURL string `json:"post_url"`
+  CreationTime string `json:"creation_time,omitempty"`
+  Orphaned bool `json:"orphaned"`
+  Deleted bool `json:"deleted"`
+  Declined bool `json:"declined"` // Deletion declined by Confirm.
+  Reason string `json:"reason"`
 }
@@ -11,7 +11,11 @@
 //
 package schema
 
-import "net/http"
+import (
+  "context"
+  "net/http"
+  "time"
+)
 
 // Generic struct to hold request details.
 type Request struct {
@@ -20,9 +24,47 @@ type Request struct {
   RequestData string
   Credentials Credentials
   Transport *http.Transport
+  Context context.Context // Optional, carries the trace of the request.
+  Header http.Header // Optional, set on the request after the defaults.
+}
+
+// Outbound HTTP proxy settings for a request target. When URL is empty the
+// HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables are honored.
+type ProxyConfig struct {
+  URL string `json:"url"`
+  Username string `json:"username"`
+  Password string `json:"password"`
+  NoProxy string `json:"no_proxy"`
 }
 
 type Credentials struct {
   Username string
   Password string
 }
+
+// Trace export settings. Exporter is one of "otlp", "stdout" or "none".
+type TracingConfig struct {
+  Exporter string `json:"exporter"`
+  Endpoint string `json:"endpoint"`
+  Insecure bool `json:"insecure"`
+  ServiceName string `json:"service_name"`
+  SampleRatio float64 `json:"sample_ratio"`
+}
+
+// Retry settings of the event dispatch to the consumers. Only retryable
+// consumer errors are retried, with the backoff doubling after each attempt.
+type RetryPolicy struct {
+  MaxAttempts int
+  InitialBackoff time.Duration
+  MaxBackoff time.Duration
+}
+
+// Limits of the callback HTTP server. Zero values select the defaults.
+type ServerLimits struct {
+  ReadHeaderTimeout time.Duration
+  ReadTimeout time.Duration
+  WriteTimeout time.Duration
+  IdleTimeout time.Duration
+  MaxHeaderBytes int
+  MaxConnections int // Concurrent connections accepted by the listener.
+}
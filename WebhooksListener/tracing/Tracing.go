@@ -0,0 +1,236 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+
+// OpenTelemetry tracing of the listener. A span is started for every
+// received webhook, with child spans for the dispatch to each consumer and
+// for every outbound request made through the shared request layer.
+//
+// Spans are exported through OTLP/HTTP or printed to stdout depending on
+// the tracing configuration. Without Init, the spans are not recorded.
+
+package tracing
+
+import (
+  "context"
+  "fmt"
+  "aplos/partners/WebhooksListener/schemas"
+  "go.opentelemetry.io/otel"
+  "go.opentelemetry.io/otel/attribute"
+  "go.opentelemetry.io/otel/codes"
+  "go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+  "go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+  "go.opentelemetry.io/otel/propagation"
+  "go.opentelemetry.io/otel/sdk/resource"
+  sdktrace "go.opentelemetry.io/otel/sdk/trace"
+  "go.opentelemetry.io/otel/trace"
+  "net/http"
+  "os"
+  "strconv"
+)
+
+const (
+  // Name of the instrumentation library.
+  tracerName = "aplos/partners/WebhooksListener"
+
+  // Supported exporters.
+  ExporterOTLP = "otlp"
+  ExporterStdout = "stdout"
+  ExporterNone = "none"
+
+  // Default service name reported with the spans.
+  DefaultServiceName = "nutanix-webhooks-listener"
+)
+
+// Span attributes.
+const (
+  EventTypeKey = attribute.Key("nutanix.event_type")
+  VMUUIDKey = attribute.Key("nutanix.vm_uuid")
+  ClusterKey = attribute.Key("nutanix.cluster")
+  ConsumerKey = attribute.Key("nutanix.consumer")
+  WebhookRegisteredKey = attribute.Key("nutanix.webhook_registered")
+)
+
+// This method will set up the process wide tracer provider for the given
+// configuration.
+//
+// Args:
+//    config : Trace export settings.
+// Returns:
+//    func : Function flushing & stopping the export, to be called on exit.
+//    error : Error, if any.
+func Init(config schema.TracingConfig) (func(context.Context) error, error) {
+  var exporter sdktrace.SpanExporter
+  var err error
+  switch config.Exporter {
+  case "", ExporterNone:
+    return func(context.Context) error { return nil }, nil
+  case ExporterOTLP:
+    var options []otlptracehttp.Option
+    if (config.Endpoint != "") {
+      options = append(options, otlptracehttp.WithEndpoint(config.Endpoint))
+    }
+    if (config.Insecure) {
+      options = append(options, otlptracehttp.WithInsecure())
+    }
+    exporter, err = otlptracehttp.New(context.Background(), options...)
+  case ExporterStdout:
+    exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+  default:
+    err = fmt.Errorf("unknown trace exporter %q, must be one of %s, %s, %s",
+                     config.Exporter, ExporterOTLP, ExporterStdout,
+                     ExporterNone)
+  }
+  if (err != nil) {
+    return nil, err
+  }
+
+  serviceName := config.ServiceName
+  if (serviceName == "") {
+    serviceName = DefaultServiceName
+  }
+  sampler := sdktrace.AlwaysSample()
+  if (config.SampleRatio > 0 && config.SampleRatio < 1) {
+    sampler = sdktrace.TraceIDRatioBased(config.SampleRatio)
+  }
+  provider := sdktrace.NewTracerProvider(
+    sdktrace.WithBatcher(exporter),
+    sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+    sdktrace.WithResource(resource.NewSchemaless(
+      attribute.String("service.name", serviceName))),
+  )
+  otel.SetTracerProvider(provider)
+  otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+    propagation.TraceContext{}, propagation.Baggage{}))
+  return provider.Shutdown, nil
+}
+
+// This method will build the trace export settings from the standard
+// OpenTelemetry environment variables: OTEL_TRACES_EXPORTER ("otlp",
+// "console"/"stdout" or "none"), OTEL_EXPORTER_OTLP_ENDPOINT (read by the
+// exporter itself), OTEL_SERVICE_NAME & OTEL_TRACES_SAMPLER_ARG.
+//
+// Args:
+//    None.
+// Returns:
+//    TracingConfig : Trace export settings.
+func ConfigFromEnv() (schema.TracingConfig) {
+  config := schema.TracingConfig{
+    Exporter: os.Getenv("OTEL_TRACES_EXPORTER"),
+    ServiceName: os.Getenv("OTEL_SERVICE_NAME"),
+  }
+  if (config.Exporter == "console") {
+    config.Exporter = ExporterStdout
+  }
+  ratio, err := strconv.ParseFloat(os.Getenv("OTEL_TRACES_SAMPLER_ARG"), 64)
+  if (err == nil) {
+    config.SampleRatio = ratio
+  }
+  return config
+}
+
+// This method will return the tracer used by the listener library.
+//
+// Args:
+//    None.
+// Returns:
+//    Tracer : Tracer of the current tracer provider.
+func Tracer() (trace.Tracer) {
+  return otel.Tracer(tracerName)
+}
+
+// This method will start a span as child of the span in the given context.
+//
+// Args:
+//    ctx : Parent context, nil for a new trace.
+//    name : Span name.
+//    attributes : Span attributes.
+// Returns:
+//    Context : Context carrying the new span.
+//    Span : The new span, to be ended by the caller.
+func StartSpan(ctx context.Context, name string,
+  attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+  if (ctx == nil) {
+    ctx = context.Background()
+  }
+  return Tracer().Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// This method will mark the span as failed with the given error.
+//
+// Args:
+//    span : Span to update.
+//    err : Error, ignored if nil.
+// Returns:
+//    None.
+func RecordError(span trace.Span, err error) {
+  if (err != nil) {
+    span.RecordError(err)
+    span.SetStatus(codes.Error, err.Error())
+  }
+}
+
+// This method will continue the trace propagated in the given HTTP headers,
+// if any.
+//
+// Args:
+//    header : Headers of a received request.
+// Returns:
+//    Context : Context carrying the remote span.
+func Extract(header http.Header) (context.Context) {
+  return otel.GetTextMapPropagator().Extract(context.Background(),
+    propagation.HeaderCarrier(header))
+}
+
+// This method will propagate the trace of the given context in the HTTP
+// headers of an outbound request.
+//
+// Args:
+//    ctx : Context carrying the current span.
+//    header : Headers of the outbound request.
+// Returns:
+//    None.
+func Inject(ctx context.Context, header http.Header) {
+  otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// This method will start the client span of an outbound HTTP request, as
+// child of the span in the request context, & propagate it in the request
+// headers.
+//
+// Args:
+//    request : Outbound HTTP request.
+//    redactedURL : Request URL with its secrets masked.
+// Returns:
+//    Request : Request carrying the new span.
+//    Span : The new span, to be ended through EndRequestSpan.
+func StartRequestSpan(request *http.Request,
+  redactedURL string) (*http.Request, trace.Span) {
+  ctx, span := Tracer().Start(request.Context(), "HTTP " + request.Method,
+    trace.WithSpanKind(trace.SpanKindClient),
+    trace.WithAttributes(
+      attribute.String("http.request.method", request.Method),
+      attribute.String("server.address", request.URL.Host),
+      attribute.String("url.full", redactedURL)))
+  request = request.WithContext(ctx)
+  Inject(ctx, request.Header)
+  return request, span
+}
+
+// This method will end the client span of an outbound HTTP request.
+//
+// Args:
+//    span : Span started by StartRequestSpan.
+//    statusCode : HTTP status code, 0 for transport failures.
+//    err : Error of the request, if any.
+// Returns:
+//    None.
+func EndRequestSpan(span trace.Span, statusCode int, err error) {
+  if (statusCode > 0) {
+    span.SetAttributes(attribute.Int("http.response.status_code", statusCode))
+  }
+  if (err != nil) {
+    RecordError(span, err)
+  } else if (statusCode >= 400) {
+    span.SetStatus(codes.Error, http.StatusText(statusCode))
+  }
+  span.End()
+}
@@ -0,0 +1,210 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+
+// Admission of the event notifications received on the callback URL. A
+// notification is authorized against the allowed sources & the callback
+// credentials, then queued for asynchronous dispatch to the event consumer.
+// The callback responds as soon as the event is queued, so that Prism is
+// not held up by slow consumers & retries rejected notifications.
+
+package WebhooksListener
+
+import (
+  "crypto/subtle"
+  "errors"
+  "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/logger"
+  "aplos/partners/WebhooksListener/metrics"
+  "aplos/partners/WebhooksListener/schemas"
+  "mime"
+  "net"
+  "net/http"
+  "strings"
+)
+
+// Status reported in the callback responses.
+const (
+  acceptedStatus = "accepted"
+  rejectedStatus = "rejected"
+)
+
+// Errors reported when a notification is rejected.
+var (
+  errUnknownSource = errors.New("source address is not allowed")
+  errUnauthorized = errors.New("missing or invalid credentials")
+  errQueueFull = errors.New("event queue is full")
+  errNoConsumer = errors.New("no event consumer is registered")
+  errMethod = errors.New("only POST is allowed")
+  errContentType = errors.New("content type must be application/json")
+)
+
+// This method will check that a notification is a JSON POST request.
+//
+// Args:
+//    request : HTTP Request object as received from the caller.
+// Returns:
+//    int : HTTP status code to reject the notification with, 0 if allowed.
+//    error : Reason of the rejection.
+func checkCallbackRequest(request *http.Request) (int, error) {
+  if (request.Method != http.MethodPost) {
+    return http.StatusMethodNotAllowed, errMethod
+  }
+  mediaType, _, err := mime.ParseMediaType(request.Header.Get("Content-Type"))
+  if (err != nil || mediaType != "application/json") {
+    return http.StatusUnsupportedMediaType, errContentType
+  }
+  return 0, nil
+}
+
+// This method will check the source & credentials of a notification.
+//
+// Args:
+//    request : HTTP Request object as received from the caller.
+// Returns:
+//    int : HTTP status code to reject the notification with, 0 if allowed.
+//    error : Reason of the rejection.
+func (webhooksListener WebhooksListener) authorizeCallback(
+  request *http.Request) (int, error) {
+  if (len(webhooksListener.AllowedSources) > 0) {
+    host, _, err := net.SplitHostPort(request.RemoteAddr)
+    if (err != nil) {
+      host = request.RemoteAddr
+    }
+    if (!sourceAllowed(host, webhooksListener.AllowedSources)) {
+      return http.StatusForbidden, errUnknownSource
+    }
+  }
+
+  credentials := webhooksListener.CallbackCredentials
+  if (credentials.Username != "") {
+    username, password, ok := request.BasicAuth()
+    if (!ok || subtle.ConstantTimeCompare([]byte(username),
+                 []byte(credentials.Username)) != 1 ||
+        subtle.ConstantTimeCompare([]byte(password),
+          []byte(credentials.Password)) != 1) {
+      return http.StatusUnauthorized, errUnauthorized
+    }
+  }
+  return 0, nil
+}
+
+// This method will check if the given address matches one of the allowed
+// sources. A source is either an IP address or a CIDR block.
+//
+// Args:
+//    host : IP address of the caller.
+//    sources : Allowed sources.
+// Returns:
+//    bool : True if the address is allowed.
+func sourceAllowed(host string, sources []string) (bool) {
+  ip := net.ParseIP(host)
+  for _, source := range sources {
+    source = strings.TrimSpace(source)
+    if (strings.Contains(source, "/")) {
+      _, network, err := net.ParseCIDR(source)
+      if (err == nil && ip != nil && network.Contains(ip)) {
+        return true
+      }
+    } else if (ip != nil && ip.Equal(net.ParseIP(source))) {
+      return true
+    } else if (source == host) {
+      return true
+    }
+  }
+  return false
+}
+
+// This method will queue the event for dispatch to the event consumer.
+//
+// Args:
+//    event : Event object to queue, carrying the context of its handling.
+// Returns:
+//    error : errQueueFull or errNoConsumer if the event cannot be queued.
+func (webhooksListener WebhooksListener) enqueueEvent(
+  event schema.Event) (error) {
+  _, queue := webhooksListener.status.consumer()
+  if (queue == nil) {
+    return errNoConsumer
+  }
+  select {
+  case queue <- event:
+    metrics.QueueDepth.WithLabelValues(webhooksListener.clusterIp).Inc()
+    return nil
+  default:
+    return errQueueFull
+  }
+}
+
+// This method will start the dispatcher of the event queue, unless it is
+// running already. The queue is kept across registrations, so a listener
+// runs a single dispatcher.
+//
+// Args:
+//    None.
+// Returns:
+//    None.
+func (webhooksListener WebhooksListener) startDispatcher() {
+  status := webhooksListener.status
+  status.lock.Lock()
+  defer status.lock.Unlock()
+  if (status.dispatching || status.queue == nil) {
+    return
+  }
+  status.dispatching = true
+  go webhooksListener.runDispatcher()
+}
+
+// This method dispatches the queued events to the event consumer, one at a
+// time in the order they were received.
+//
+// Args:
+//    None.
+// Returns:
+//    None.
+func (webhooksListener WebhooksListener) runDispatcher() {
+  _, queue := webhooksListener.status.consumer()
+  queueDepth := metrics.QueueDepth.WithLabelValues(webhooksListener.clusterIp)
+  for event := range queue {
+    queueDepth.Dec()
+    webhooksListener.dispatchEvent(event.Context(), event)
+  }
+}
+
+// This method will return the size of the event queue with defaults
+// applied.
+//
+// Args:
+//    None.
+// Returns:
+//    int : Size of the event queue.
+func (webhooksListener WebhooksListener) queueSize() (int) {
+  if (webhooksListener.QueueSize <= 0) {
+    return lib.DefaultQueueSize
+  }
+  return webhooksListener.QueueSize
+}
+
+// This method will write a rejected callback response.
+//
+// Args:
+//    responseWriter : HTTP ResponseWriter object to write the response.
+//    statusCode : HTTP status code of the response.
+//    err : Reason of the rejection.
+// Returns:
+//    None.
+func (webhooksListener WebhooksListener) rejectCallback(
+  responseWriter http.ResponseWriter, statusCode int, err error) {
+  logger.Warn("Rejected event.", logger.ClusterKey,
+    webhooksListener.clusterIp, logger.StatusCodeKey, statusCode,
+    logger.ErrorKey, err)
+  switch statusCode {
+  case http.StatusUnauthorized:
+    responseWriter.Header().Set("WWW-Authenticate",
+      `Basic realm="` + webhooksListener.callbackPath() + `"`)
+  case http.StatusMethodNotAllowed:
+    responseWriter.Header().Set("Allow", http.MethodPost)
+  case http.StatusServiceUnavailable:
+    responseWriter.Header().Set("Retry-After", "1")
+  }
+  writeJSON(responseWriter, statusCode,
+    schema.CallbackResponse{Status: rejectedStatus, Error: err.Error()})
+}
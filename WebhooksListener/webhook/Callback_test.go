@@ -0,0 +1,98 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+//
+// This test package apply unit tests on the responses of the callback
+// endpoint.
+//
+
+package WebhooksListener
+
+import (
+  "encoding/json"
+  "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/schemas"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+)
+
+// This method will post the given body to the callback endpoint of the
+// listener.
+func postEvent(t *testing.T, webhooksListener WebhooksListener, body string,
+  prepare func(*http.Request)) (int, schema.CallbackResponse) {
+  var response schema.CallbackResponse
+  request := httptest.NewRequest("POST", lib.ListenerCallbackURL,
+    strings.NewReader(body))
+  request.RemoteAddr = "10.1.1.5:41000"
+  request.Header.Set("Content-Type", "application/json; charset=utf-8")
+  if (prepare != nil) {
+    prepare(request)
+  }
+  recorder := httptest.NewRecorder()
+  webhooksListener.onEvent(recorder, request)
+  err := json.Unmarshal(recorder.Body.Bytes(), &response)
+  if (err != nil) {
+    t.Fatalf("Failed to parse response %q: %s", recorder.Body.String(), err)
+  }
+  return recorder.Code, response
+}
+
+// Test to verify the status codes of the callback endpoint.
+func TestCallbackResponses(t *testing.T) {
+  event := `{"event_type": "VM.ON", "entity_reference": {"uuid": "vm-1"}}`
+  webhooksListener := WebhooksListener{
+    status: &listenerStatus{queue: make(chan schema.Event, 1)},
+    MaxEventSize: 128,
+    CallbackCredentials: schema.Credentials{Username: "prism",
+                                            Password: "secret"},
+    AllowedSources: []string{"10.1.1.0/24"},
+  }
+  withAuth := func(request *http.Request) {
+    request.SetBasicAuth("prism", "secret")
+  }
+
+  tests := []struct {
+    name string
+    body string
+    prepare func(*http.Request)
+    code int
+  }{
+    {"unknown source", event, func(request *http.Request) {
+      request.RemoteAddr = "10.2.1.5:41000"
+      withAuth(request)
+    }, http.StatusForbidden},
+    {"missing credentials", event, nil, http.StatusUnauthorized},
+    {"wrong credentials", event, func(request *http.Request) {
+      request.SetBasicAuth("prism", "wrong")
+    }, http.StatusUnauthorized},
+    {"method", event, func(request *http.Request) {
+      request.Method = "GET"
+      withAuth(request)
+    }, http.StatusMethodNotAllowed},
+    {"content type", event, func(request *http.Request) {
+      request.Header.Set("Content-Type", "text/plain")
+      withAuth(request)
+    }, http.StatusUnsupportedMediaType},
+    {"malformed", "{", withAuth, http.StatusBadRequest},
+    {"missing event type", "{}", withAuth, http.StatusBadRequest},
+    {"oversize", event + strings.Repeat(" ", 128), withAuth,
+     http.StatusRequestEntityTooLarge},
+    {"accepted", event, withAuth, http.StatusAccepted},
+    {"queue full", event, withAuth, http.StatusServiceUnavailable},
+  }
+  for _, test := range tests {
+    code, response := postEvent(t, webhooksListener, test.body, test.prepare)
+    if (code != test.code) {
+      t.Errorf("%s: expected %d, got %d %v", test.name, test.code, code,
+        response)
+    }
+    if (code != http.StatusAccepted && response.Error == "") {
+      t.Errorf("%s: expected error body, got %v", test.name, response)
+    }
+  }
+
+  queued := <-webhooksListener.status.queue
+  if (queued.EntityReference.UUID != "vm-1" || queued.Context() == nil) {
+    t.Errorf("Unexpected queued event %v", queued)
+  }
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+
+// Dispatch of the received events to the event consumer. Each consumer call
+// is isolated: a panic in the consumer is recovered & turned into a
+// retryable failure carrying the stack trace. Retryable failures are
+// retried as per the listener's retry policy.
+
+package WebhooksListener
+
+import (
+  "context"
+  "aplos/partners/WebhooksListener/interfaces"
+  "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/logger"
+  "aplos/partners/WebhooksListener/metrics"
+  "aplos/partners/WebhooksListener/schemas"
+  "aplos/partners/WebhooksListener/tracing"
+  "runtime/debug"
+  "time"
+)
+
+// This method will deliver the event to the event consumer, retrying
+// retryable failures.
+//
+// Args:
+//    ctx : Context of the event handling.
+//    event : Event object to deliver.
+// Returns:
+//    error : Error of the last attempt, if any.
+func (webhooksListener WebhooksListener) dispatchEvent(ctx context.Context,
+  event schema.Event) (error) {
+  eventConsumer, _ := webhooksListener.status.consumer()
+  if (eventConsumer == nil) {
+    return nil
+  }
+  consumerName := lib.ConsumerName(eventConsumer)
+  log := logger.With(logger.ClusterKey, webhooksListener.clusterIp,
+    logger.EventTypeKey, event.Event_Type,
+    logger.VMUUIDKey, event.EntityReference.UUID,
+    logger.ConsumerKey, consumerName)
+  policy := webhooksListener.retryPolicy()
+
+  var err error
+  backoff := policy.InitialBackoff
+  for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+    if (attempt > 1) {
+      metrics.Retries.WithLabelValues(consumerName, event.Event_Type).Inc()
+      log.Info("Retrying event.", "attempt", attempt, "backoff", backoff)
+      select {
+      case <-ctx.Done():
+        return err
+      case <-time.After(backoff):
+      }
+      backoff *= 2
+      if (backoff > policy.MaxBackoff) {
+        backoff = policy.MaxBackoff
+      }
+    }
+
+    log.Info("Dispatching event to consumer.", "attempt", attempt)
+    dispatchCtx, span := tracing.StartSpan(ctx, "consumer.dispatch",
+      tracing.ConsumerKey.String(consumerName),
+      tracing.EventTypeKey.String(event.Event_Type),
+      tracing.VMUUIDKey.String(event.EntityReference.UUID))
+    start := time.Now()
+    err = invokeConsumer(eventConsumer, event.WithContext(dispatchCtx))
+    metrics.DispatchDuration.WithLabelValues(consumerName,
+      event.Event_Type).Observe(time.Since(start).Seconds())
+    tracing.RecordError(span, err)
+    span.End()
+    if (err == nil) {
+      return nil
+    }
+
+    metrics.ConsumerErrors.WithLabelValues(consumerName,
+      event.Event_Type).Inc()
+    if panicErr, ok := err.(*lib.PanicError); ok {
+      log.Error("Event consumer panicked.", logger.ErrorKey, err,
+        "attempt", attempt, "stack", string(panicErr.Stack))
+    } else {
+      log.Error("Event consumer failed.", logger.ErrorKey, err,
+        "attempt", attempt)
+    }
+    if (!lib.IsRetryable(err)) {
+      return err
+    }
+  }
+  log.Error("Giving up on event.", "attempts", policy.MaxAttempts)
+  return err
+}
+
+// This method will invoke the event consumer's callback, converting a panic
+// into a PanicError.
+//
+// Args:
+//    eventConsumer : Event consumer to invoke.
+//    event : Event object to pass.
+// Returns:
+//    error : Error returned by the consumer or PanicError.
+func invokeConsumer(eventConsumer interfaces.EventConsumer,
+  event schema.Event) (err error) {
+  defer func() {
+    if value := recover(); (value != nil) {
+      err = &lib.PanicError{Value: value, Stack: debug.Stack()}
+    }
+  }()
+  return eventConsumer.OnEvent(event)
+}
+
+// This method will return the retry policy of the listener with defaults
+// applied.
+//
+// Args:
+//    None.
+// Returns:
+//    RetryPolicy : Retry policy.
+func (webhooksListener WebhooksListener) retryPolicy() (schema.RetryPolicy) {
+  policy := webhooksListener.RetryPolicy
+  if (policy.MaxAttempts <= 0) {
+    policy.MaxAttempts = lib.DefaultMaxAttempts
+  }
+  if (policy.InitialBackoff <= 0) {
+    policy.InitialBackoff = lib.DefaultInitialBackoff
+  }
+  if (policy.MaxBackoff <= 0) {
+    policy.MaxBackoff = lib.DefaultMaxBackoff
+  }
+  return policy
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+//
+// This test package apply unit tests on the dispatch of the events to the
+// event consumer.
+//
+
+package WebhooksListener
+
+import (
+  "context"
+  "errors"
+  "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/schemas"
+  "testing"
+  "time"
+)
+
+type flakyConsumer struct {
+  calls int
+  failures int
+  err error
+}
+
+func (consumer *flakyConsumer) OnEvent(event schema.Event) (error) {
+  consumer.calls++
+  if (consumer.calls > consumer.failures) {
+    return nil
+  }
+  if (consumer.err != nil) {
+    return consumer.err
+  }
+  var nics []schema.NIC
+  _ = nics[0]
+  return nil
+}
+
+// This method will build a listener dispatching to the given consumer
+// without backoff delays.
+func newDispatchListener(consumer *flakyConsumer) (WebhooksListener) {
+  return WebhooksListener{
+    status: &listenerStatus{eventConsumer: consumer},
+    RetryPolicy: schema.RetryPolicy{MaxAttempts: 3,
+                                    InitialBackoff: time.Millisecond,
+                                    MaxBackoff: time.Millisecond},
+  }
+}
+
+// Test to verify a consumer panic is recovered & retried.
+func TestDispatchPanicRecovered(t *testing.T) {
+  consumer := &flakyConsumer{failures: 1}
+  err := newDispatchListener(consumer).dispatchEvent(context.Background(),
+    schema.Event{Event_Type: lib.VM_ON})
+  if (err != nil || consumer.calls != 2) {
+    t.Errorf("Expected success on retry, got %v after %d calls", err,
+      consumer.calls)
+  }
+
+  consumer = &flakyConsumer{failures: 3}
+  err = newDispatchListener(consumer).dispatchEvent(context.Background(),
+    schema.Event{Event_Type: lib.VM_ON})
+  var panicErr *lib.PanicError
+  if (!errors.As(err, &panicErr) || len(panicErr.Stack) == 0 ||
+      consumer.calls != 3) {
+    t.Errorf("Expected PanicError with stack after 3 calls, got %v after %d",
+      err, consumer.calls)
+  }
+}
+
+// Test to verify only retryable errors are retried.
+func TestDispatchRetryable(t *testing.T) {
+  consumer := &flakyConsumer{failures: 3, err: errors.New("bad config")}
+  err := newDispatchListener(consumer).dispatchEvent(context.Background(),
+    schema.Event{Event_Type: lib.VM_ON})
+  if (err == nil || consumer.calls != 1) {
+    t.Errorf("Expected no retry, got %v after %d calls", err, consumer.calls)
+  }
+
+  consumer = &flakyConsumer{failures: 2,
+                            err: lib.Retryable(errors.New("unreachable"))}
+  err = newDispatchListener(consumer).dispatchEvent(context.Background(),
+    schema.Event{Event_Type: lib.VM_ON})
+  if (err != nil || consumer.calls != 3) {
+    t.Errorf("Expected success on retry, got %v after %d calls", err,
+      consumer.calls)
+  }
+}
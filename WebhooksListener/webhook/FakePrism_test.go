@@ -0,0 +1,173 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+//
+// Fake Prism serving the webhook & cluster APIs used by the listener, for
+// the unit tests of the webhook operations.
+//
+
+package WebhooksListener
+
+import (
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/schemas"
+  "net/http"
+  "net/http/httptest"
+  "net/url"
+  "strings"
+  "sync"
+  "testing"
+)
+
+type fakePrism struct {
+  lock sync.Mutex
+  server *httptest.Server
+  version string
+  webhooks map[string]*schema.Webhook
+  nextUUID int
+  requests []string
+  // Answer to the webhook creations & updates: accepted & COMPLETE if
+  // empty, else one of the modes below.
+  putMode string
+  listStatus int // Status code of the webhook listing, 200 if zero.
+}
+
+// Modes of the answer to the webhook creations & updates.
+const (
+  // Accepted & PENDING, COMPLETE once read back.
+  putPending = "pending"
+  // Applied, answered with 200.
+  putOK = "ok"
+  // Refused, answered with 500.
+  putError = "error"
+)
+
+// This method will start a fake Prism, stopped at the end of the test.
+func newFakePrism(t *testing.T) (*fakePrism) {
+  prism := &fakePrism{version: "6.5", webhooks: map[string]*schema.Webhook{}}
+  prism.server = httptest.NewTLSServer(http.HandlerFunc(prism.serve))
+  t.Cleanup(prism.server.Close)
+  return prism
+}
+
+// This method will return a listener initialized against the fake Prism.
+func (prism *fakePrism) listener(t *testing.T) (WebhooksListener) {
+  serverURL, _ := url.Parse(prism.server.URL)
+  webhooksListener, err := WebhooksListener{
+    ListenerID: "test-listener",
+  }.Initialize(serverURL.Hostname(), serverURL.Port(), "admin", "secret")
+  if (err != nil) {
+    t.Fatalf("Failed to initialize listener: %s", err)
+  }
+  return webhooksListener
+}
+
+// This method will add a webhook to the fake Prism.
+func (prism *fakePrism) addWebhook(name string, description string,
+  postURL string, events []string) (string) {
+  prism.lock.Lock()
+  defer prism.lock.Unlock()
+  prism.nextUUID++
+  webhook := &schema.Webhook{}
+  webhook.Metadata.UUID = fmt.Sprintf("webhook-%d", prism.nextUUID)
+  webhook.Metadata.Kind = lib.WebhookKind
+  webhook.Spec.Name = name
+  webhook.Spec.Description = description
+  webhook.Spec.Resources.PostURL = postURL
+  webhook.Spec.Resources.EventsFilterList = events
+  webhook.Status.State = completeStatus
+  prism.webhooks[webhook.Metadata.UUID] = webhook
+  return webhook.Metadata.UUID
+}
+
+// This method will return a copy of the webhook with the given UUID.
+func (prism *fakePrism) webhook(uuid string) (*schema.Webhook) {
+  prism.lock.Lock()
+  defer prism.lock.Unlock()
+  webhook, ok := prism.webhooks[uuid]
+  if (!ok) {
+    return nil
+  }
+  copied := *webhook
+  return &copied
+}
+
+// This method will serve the requests of the listener.
+func (prism *fakePrism) serve(responseWriter http.ResponseWriter,
+  request *http.Request) {
+  prism.lock.Lock()
+  defer prism.lock.Unlock()
+  prism.requests = append(prism.requests, request.Method + " " +
+    request.URL.Path)
+  uuid := strings.TrimPrefix(request.URL.Path, lib.UpdateWebhook)
+  switch {
+  case request.URL.Path == lib.GetCurrentUser:
+    writeJSON(responseWriter, http.StatusOK, map[string]string{})
+  case request.URL.Path == lib.GetClusterInfo:
+    writeJSON(responseWriter, http.StatusOK,
+      schema.ClusterInfo{Name: "fake", Version: prism.version})
+  case request.URL.Path == lib.ListWebhooks:
+    if (prism.listStatus != 0 && prism.listStatus != http.StatusOK) {
+      writeJSON(responseWriter, prism.listStatus,
+        map[string]string{"message": "listing failed"})
+      return
+    }
+    var list schema.CurrentWebhooks
+    for _, webhook := range prism.webhooks {
+      list.Entities = append(list.Entities, *webhook)
+    }
+    list.Metadata.TotalMatches = len(list.Entities)
+    writeJSON(responseWriter, http.StatusOK, list)
+  case request.URL.Path == lib.CreateWebhook || request.Method == "PUT":
+    if (prism.putMode == putError) {
+      writeJSON(responseWriter, http.StatusInternalServerError,
+        map[string]string{"message": "webhook operation failed"})
+      return
+    }
+    var spec schema.WebhookCreationSpec
+    body, _ := ioutil.ReadAll(request.Body)
+    json.Unmarshal(body, &spec)
+    webhook, ok := prism.webhooks[uuid]
+    if (request.Method == "POST") {
+      prism.nextUUID++
+      webhook = &schema.Webhook{}
+      webhook.Metadata.UUID = fmt.Sprintf("webhook-%d", prism.nextUUID)
+      prism.webhooks[webhook.Metadata.UUID] = webhook
+    } else if (!ok) {
+      writeJSON(responseWriter, http.StatusNotFound, map[string]string{})
+      return
+    }
+    webhook.Metadata.SpecVersion = spec.Metadata.SpecVersion + 1
+    webhook.Spec.Name = spec.Spec.Name
+    webhook.Spec.Description = spec.Spec.Description
+    webhook.Spec.Resources.PostURL = spec.Spec.Resources.PostUrl
+    webhook.Spec.Resources.EventsFilterList =
+      spec.Spec.Resources.EventsFilterList
+    webhook.Status.State = completeStatus
+    switch prism.putMode {
+    case putPending:
+      webhook.Status.State = pendingStatus
+      writeJSON(responseWriter, pendingStatusCode, webhook)
+    case putOK:
+      writeJSON(responseWriter, http.StatusOK, webhook)
+    default:
+      writeJSON(responseWriter, pendingStatusCode, webhook)
+    }
+  case request.Method == "GET" || request.Method == "DELETE":
+    webhook, ok := prism.webhooks[uuid]
+    if (!ok) {
+      writeJSON(responseWriter, http.StatusNotFound, map[string]string{})
+      return
+    }
+    if (request.Method == "DELETE") {
+      delete(prism.webhooks, uuid)
+      writeJSON(responseWriter, http.StatusAccepted, map[string]string{})
+      return
+    }
+    webhook.Status.State = completeStatus
+    writeJSON(responseWriter, http.StatusOK, webhook)
+  default:
+    writeJSON(responseWriter, http.StatusNotFound, map[string]string{})
+  }
+}
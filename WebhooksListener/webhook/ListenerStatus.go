@@ -0,0 +1,390 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+
+// Health & readiness tracking of the WebhooksListener: the webhook
+// registration state, the outcome of the last contact with Prism and the
+// health reported by the event consumer. A watchdog periodically refreshes
+// the webhook state from Prism & re-registers the webhook if it is gone.
+
+package WebhooksListener
+
+import (
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "aplos/partners/WebhooksListener/interfaces"
+  "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/logger"
+  "aplos/partners/WebhooksListener/metrics"
+  "aplos/partners/WebhooksListener/schemas"
+  "net/http"
+  "strings"
+  "sync"
+  "time"
+)
+
+type listenerStatus struct {
+  // Status shared by all the copies of a WebhooksListener.
+  lock sync.RWMutex
+  eventConsumer interfaces.EventConsumer
+  queue chan schema.Event
+  dispatching bool // Whether the dispatcher of the queue is running.
+  events []string
+  webhookUUID string
+  webhookState string
+  webhookRegistered bool
+  lastPrismContact time.Time
+  lastPrismError error
+  probeToken string
+  prism *prismClient
+  watchdogStop chan struct{} // Stops the running watchdog, nil if none.
+}
+
+// This method will record the event consumer & the queue of its events.
+//
+// Args:
+//    eventConsumer : Event consumer registered for the events.
+//    queue : Queue of the events to dispatch to the consumer.
+// Returns:
+//    None.
+func (status *listenerStatus) setConsumer(
+  eventConsumer interfaces.EventConsumer, queue chan schema.Event) {
+  status.lock.Lock()
+  defer status.lock.Unlock()
+  status.eventConsumer = eventConsumer
+  status.queue = queue
+}
+
+// This method will return the event consumer & the queue of its events.
+//
+// Args:
+//    None.
+// Returns:
+//    EventConsumer : Registered event consumer, nil if none.
+//    chan : Queue of the events, nil if no consumer is registered.
+func (status *listenerStatus) consumer() (interfaces.EventConsumer,
+  chan schema.Event) {
+  if (status == nil) {
+    return nil, nil
+  }
+  status.lock.RLock()
+  defer status.lock.RUnlock()
+  return status.eventConsumer, status.queue
+}
+
+// This method will return the token answered to the reachability probes,
+// generated on first use.
+//
+// Args:
+//    None.
+// Returns:
+//    string : Probe token, empty if no status is tracked.
+func (status *listenerStatus) probe() (string) {
+  if (status == nil) {
+    return ""
+  }
+  status.lock.Lock()
+  defer status.lock.Unlock()
+  if (status.probeToken == "") {
+    status.probeToken, _ = lib.NewListenerID()
+  }
+  return status.probeToken
+}
+
+// This method will record the webhook registration state.
+//
+// Args:
+//    uuid : UUID of the webhook.
+//    state : State of the webhook as reported by Prism.
+//    registered : Whether the webhook is registered and COMPLETE.
+// Returns:
+//    None.
+func (status *listenerStatus) setWebhook(uuid string, state string,
+  registered bool) {
+  status.lock.Lock()
+  defer status.lock.Unlock()
+  if (uuid != "") {
+    status.webhookUUID = uuid
+  }
+  status.webhookState = state
+  status.webhookRegistered = registered
+}
+
+// This method will forget the webhook, once deleted.
+//
+// Args:
+//    None.
+// Returns:
+//    None.
+func (status *listenerStatus) clearWebhook() {
+  status.lock.Lock()
+  defer status.lock.Unlock()
+  status.webhookUUID = ""
+  status.webhookState = ""
+  status.webhookRegistered = false
+}
+
+// This method will record the events of the webhook.
+//
+// Args:
+//    events : Events of the webhook, nil once it is deleted.
+// Returns:
+//    None.
+func (status *listenerStatus) setEvents(events []string) {
+  status.lock.Lock()
+  defer status.lock.Unlock()
+  status.events = events
+}
+
+// This method will record the outcome of a request made to Prism.
+//
+// Args:
+//    err : Error of the request, nil if Prism handled it.
+// Returns:
+//    None.
+func (status *listenerStatus) setPrismContact(err error) {
+  status.lock.Lock()
+  defer status.lock.Unlock()
+  status.lastPrismContact = time.Now()
+  status.lastPrismError = err
+}
+
+// This method will perform a request to Prism & record its outcome. Server
+// errors count as failed contacts, client errors (e.g. an unknown webhook
+// UUID) do not.
+//
+// Args:
+//    request : Object with details required to perform the given request.
+// Returns:
+//    Response : HTTP response for the request.
+//    error : Error, if any.
+func (webhooksListener WebhooksListener) doPrismRequest(
+  request schema.Request) (*http.Response, error) {
+  response, err := webhooksListener.doFailoverRequest(request)
+  if (webhooksListener.status != nil) {
+    contactErr := err
+    if (contactErr == nil && response.StatusCode >= 500) {
+      contactErr = fmt.Errorf("Prism returned HTTP status code %d",
+                              response.StatusCode)
+    }
+    webhooksListener.status.setPrismContact(contactErr)
+  }
+  return response, err
+}
+
+// This method will build the readiness report of the listener. The listener
+// is ready when its webhook is registered & COMPLETE, the last contact with
+// Prism succeeded and the event consumer reports itself healthy.
+//
+// Args:
+//    None.
+// Returns:
+//    ReadinessStatus : Readiness report.
+func (webhooksListener WebhooksListener) readiness() (schema.ReadinessStatus) {
+  var readiness schema.ReadinessStatus
+  status := webhooksListener.status
+  if (status != nil) {
+    status.lock.RLock()
+    readiness.Webhook.Registered = status.webhookRegistered
+    readiness.Webhook.UUID = status.webhookUUID
+    readiness.Webhook.State = status.webhookState
+    if (!status.lastPrismContact.IsZero()) {
+      readiness.Prism.LastContact = status.lastPrismContact.Format(
+        time.RFC3339)
+      readiness.Prism.Reachable = (status.lastPrismError == nil)
+      if (status.lastPrismError != nil) {
+        readiness.Prism.Error = status.lastPrismError.Error()
+      }
+    }
+    prism := status.prism
+    status.lock.RUnlock()
+    readiness.Prism.Endpoint = prism.currentHost()
+  }
+  readiness.Ready = readiness.Webhook.Registered && readiness.Prism.Reachable
+
+  readiness.Consumers = []schema.ConsumerReadiness{}
+  eventConsumer, _ := status.consumer()
+  if (eventConsumer != nil) {
+    consumerReadiness := schema.ConsumerReadiness{
+      Name: lib.ConsumerName(eventConsumer),
+      Healthy: true,
+    }
+    if checker, ok := eventConsumer.(
+      interfaces.HealthChecker); ok {
+      if err := checker.Health(); (err != nil) {
+        consumerReadiness.Healthy = false
+        consumerReadiness.Error = err.Error()
+      }
+    }
+    readiness.Ready = readiness.Ready && consumerReadiness.Healthy
+    readiness.Consumers = append(readiness.Consumers, consumerReadiness)
+  }
+  return readiness
+}
+
+// This method serves the liveness endpoint. It succeeds as long as the
+// process is able to serve HTTP requests.
+//
+// Args:
+//    responseWriter : HTTP ResponseWriter object to write the response.
+//    request : HTTP Request object as received from the caller.
+// Returns:
+//    None.
+func (webhooksListener WebhooksListener) onHealthz(
+  responseWriter http.ResponseWriter, request *http.Request) {
+  writeJSON(responseWriter, http.StatusOK, schema.HealthStatus{Status: "ok"})
+}
+
+// This method serves the readiness endpoint with the readiness report as
+// JSON. The status code is 200 when ready, 503 otherwise.
+//
+// Args:
+//    responseWriter : HTTP ResponseWriter object to write the response.
+//    request : HTTP Request object as received from the caller.
+// Returns:
+//    None.
+func (webhooksListener WebhooksListener) onReadyz(
+  responseWriter http.ResponseWriter, request *http.Request) {
+  readiness := webhooksListener.readiness()
+  statusCode := http.StatusOK
+  if (!readiness.Ready) {
+    statusCode = http.StatusServiceUnavailable
+  }
+  writeJSON(responseWriter, statusCode, readiness)
+}
+
+// This method will write the given value as JSON response.
+//
+// Args:
+//    responseWriter : HTTP ResponseWriter object to write the response.
+//    statusCode : HTTP status code of the response.
+//    value : Value to write.
+// Returns:
+//    None.
+func writeJSON(responseWriter http.ResponseWriter, statusCode int,
+  value interface{}) {
+  responseWriter.Header().Set("Content-Type", "application/json")
+  responseWriter.WriteHeader(statusCode)
+  json.NewEncoder(responseWriter).Encode(value)
+}
+
+// This method will start the webhook watchdog, unless it is running already.
+// A listener runs a single watchdog however many times it registers.
+//
+// Args:
+//    None.
+// Returns:
+//    None.
+func (webhooksListener WebhooksListener) startWatchdog() {
+  status := webhooksListener.status
+  status.lock.Lock()
+  defer status.lock.Unlock()
+  if (status.watchdogStop != nil) {
+    return
+  }
+  status.watchdogStop = make(chan struct{})
+  go webhooksListener.runWatchdog(status.watchdogStop)
+}
+
+// This method will stop the webhook watchdog, if running.
+//
+// Args:
+//    None.
+// Returns:
+//    None.
+func (status *listenerStatus) stopWatchdog() {
+  status.lock.Lock()
+  defer status.lock.Unlock()
+  if (status.watchdogStop != nil) {
+    close(status.watchdogStop)
+    status.watchdogStop = nil
+  }
+}
+
+// This method periodically refreshes the webhook state from Prism. If the
+// webhook has disappeared, it is registered again for the subscribed events.
+//
+// Args:
+//    stop : Channel closed to stop the watchdog.
+// Returns:
+//    None.
+func (webhooksListener WebhooksListener) runWatchdog(stop chan struct{}) {
+  interval := webhooksListener.WatchdogInterval
+  if (interval <= 0) {
+    interval = lib.DefaultWatchdogInterval
+  }
+  log := logger.With(logger.ClusterKey, webhooksListener.clusterIp)
+  ticker := time.NewTicker(interval)
+  defer ticker.Stop()
+  for {
+    select {
+    case <-stop:
+      return
+    case <-ticker.C:
+    }
+    registered, err := webhooksListener.refreshWebhookState()
+    if (err != nil) {
+      log.Warn("Failed to refresh webhook state.", logger.ErrorKey, err)
+      continue
+    }
+    webhooksListener.status.lock.RLock()
+    events := webhooksListener.status.events
+    webhooksListener.status.lock.RUnlock()
+    if (!registered && len(events) > 0) {
+      log.Warn("Webhook is not registered. Registering again.",
+        "events", events)
+      err = webhooksListener.createOrUpdateWebhook(events)
+      if (err != nil) {
+        log.Error("Failed to register webhook again.", logger.ErrorKey, err)
+      }
+    }
+  }
+}
+
+// This method will get the state of the listener's webhook from Prism.
+//
+// Args:
+//    None.
+// Returns:
+//    bool : Whether the webhook exists, in which case a PENDING webhook
+//           counts as registered until it completes.
+//    error : Error, if any.
+func (webhooksListener WebhooksListener) refreshWebhookState() (bool, error) {
+  webhooksListener.status.lock.RLock()
+  uuid := webhooksListener.status.webhookUUID
+  webhooksListener.status.lock.RUnlock()
+  if (uuid == "") {
+    return false, nil
+  }
+
+  requestURL := webhooksListener.prismURL(lib.GetWebhook)
+  requestURL = strings.Replace(requestURL, "{uuid}", uuid, 1)
+  request := lib.PrepareRequestWithProxy(requestURL,
+    webhooksListener.clusterUsername, webhooksListener.clusterPassword,
+    "GET", webhooksListener.ClusterProxy)
+  response, err := webhooksListener.doPrismRequest(request)
+  if (err != nil) {
+    return true, err
+  }
+  defer response.Body.Close()
+  if (response.StatusCode == http.StatusNotFound) {
+    webhooksListener.status.setWebhook(uuid, "", false)
+    metrics.SetWebhookRegistered(webhooksListener.clusterIp, false)
+    return false, nil
+  }
+  if (response.StatusCode != http.StatusOK) {
+    return true, fmt.Errorf("Unexpected HTTP status code %d",
+                            response.StatusCode)
+  }
+  var webhook schema.Webhook
+  respBytes, err := ioutil.ReadAll(response.Body)
+  if (err == nil) {
+    err = json.Unmarshal(respBytes, &webhook)
+  }
+  if (err != nil) {
+    return true, err
+  }
+  registered := (webhook.Status.State == completeStatus)
+  webhooksListener.status.setWebhook(uuid, webhook.Status.State, registered)
+  metrics.SetWebhookRegistered(webhooksListener.clusterIp, registered)
+  return (registered || webhook.Status.State == pendingStatus), nil
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+//
+// This test package apply unit tests on the health & readiness endpoints
+// of the listener.
+//
+
+package WebhooksListener
+
+import (
+  "encoding/json"
+  "errors"
+  "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/schemas"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+  "time"
+)
+
+type testConsumer struct {
+  healthErr error
+}
+
+func (consumer testConsumer) OnEvent(event schema.Event) (error) {
+  return nil
+}
+
+func (consumer testConsumer) Health() (error) {
+  return consumer.healthErr
+}
+
+// This method will query the readiness endpoint of the given listener.
+func getReadiness(t *testing.T,
+  webhooksListener WebhooksListener) (int, schema.ReadinessStatus) {
+  var readiness schema.ReadinessStatus
+  recorder := httptest.NewRecorder()
+  webhooksListener.onReadyz(recorder,
+    httptest.NewRequest("GET", "/readyz", nil))
+  err := json.Unmarshal(recorder.Body.Bytes(), &readiness)
+  if (err != nil) {
+    t.Fatalf("Failed to parse readiness %q: %s", recorder.Body.String(), err)
+  }
+  return recorder.Code, readiness
+}
+
+// Test to verify readiness follows webhook, Prism & consumer health.
+func TestReadiness(t *testing.T) {
+  consumer := &testConsumer{}
+  webhooksListener := WebhooksListener{
+    status: &listenerStatus{eventConsumer: consumer},
+  }
+  code, readiness := getReadiness(t, webhooksListener)
+  if (code != http.StatusServiceUnavailable || readiness.Ready) {
+    t.Errorf("Expected not ready before registration, got %d %v", code,
+      readiness)
+  }
+
+  webhooksListener.status.setPrismContact(nil)
+  webhooksListener.status.setWebhook("uuid-1", completeStatus, true)
+  code, readiness = getReadiness(t, webhooksListener)
+  if (code != http.StatusOK || !readiness.Ready ||
+      readiness.Webhook.UUID != "uuid-1" || !readiness.Prism.Reachable ||
+      len(readiness.Consumers) != 1 || !readiness.Consumers[0].Healthy) {
+    t.Errorf("Expected ready, got %d %v", code, readiness)
+  }
+
+  consumer.healthErr = errors.New("firewall unreachable")
+  code, readiness = getReadiness(t, webhooksListener)
+  if (code != http.StatusServiceUnavailable ||
+      readiness.Consumers[0].Error != "firewall unreachable") {
+    t.Errorf("Expected unhealthy consumer, got %d %v", code, readiness)
+  }
+
+  consumer.healthErr = nil
+  webhooksListener.status.setPrismContact(errors.New("timeout"))
+  code, readiness = getReadiness(t, webhooksListener)
+  if (code != http.StatusServiceUnavailable || readiness.Prism.Reachable ||
+      readiness.Prism.Error != "timeout") {
+    t.Errorf("Expected unreachable Prism, got %d %v", code, readiness)
+  }
+}
+
+// Test to verify the liveness endpoint.
+func TestHealthz(t *testing.T) {
+  recorder := httptest.NewRecorder()
+  WebhooksListener{}.onHealthz(recorder,
+    httptest.NewRequest("GET", "/healthz", nil))
+  if (recorder.Code != http.StatusOK) {
+    t.Errorf("Unexpected liveness status code %d", recorder.Code)
+  }
+}
+
+// This method will return the number of webhooks of the fake Prism.
+func (prism *fakePrism) webhookCount() (int) {
+  prism.lock.Lock()
+  defer prism.lock.Unlock()
+  return len(prism.webhooks)
+}
+
+// Test to verify a single watchdog re-registers a deleted webhook, and that
+// it stops once the listener unregisters.
+func TestWatchdog(t *testing.T) {
+  prism := newFakePrism(t)
+  webhooksListener := prism.listener(t)
+  webhooksListener.WatchdogInterval = 10 * time.Millisecond
+  err := webhooksListener.createOrUpdateWebhook([]string{lib.VM_ON})
+  if (err != nil) {
+    t.Fatalf("Failed to register: %s", err)
+  }
+  webhooksListener.startWatchdog()
+  stop := webhooksListener.status.watchdogStop
+  webhooksListener.startWatchdog()
+  if (webhooksListener.status.watchdogStop != stop) {
+    t.Errorf("Expected a single watchdog")
+  }
+
+  prism.lock.Lock()
+  prism.webhooks = map[string]*schema.Webhook{}
+  prism.lock.Unlock()
+  deadline := time.Now().Add(5 * time.Second)
+  for (prism.webhookCount() == 0 && time.Now().Before(deadline)) {
+    time.Sleep(10 * time.Millisecond)
+  }
+  if (prism.webhookCount() != 1) {
+    t.Fatalf("Expected the webhook to be registered again")
+  }
+
+  err = webhooksListener.UnregisterForEvents([]string{lib.VM_ON})
+  if (err != nil || webhooksListener.status.watchdogStop != nil) {
+    t.Fatalf("Expected the watchdog to stop, got %v", err)
+  }
+  time.Sleep(50 * time.Millisecond)
+  if (prism.webhookCount() != 0) {
+    t.Errorf("Expected no webhook after unregistering")
+  }
+}
@@ -0,0 +1,298 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+
+// Failover across the Prism endpoints of a cluster. A cluster is reached
+// through its virtual IP and optionally the IPs of its CVMs, which remain
+// reachable while the virtual IP moves during maintenance.
+//
+// The requests stick to the endpoint that last answered. An endpoint that
+// cannot be connected to, or answers with a gateway error, is marked
+// unhealthy for lib.EndpointRetryInterval & the request is retried on the
+// next endpoint. Unhealthy endpoints are tried last. Requests that are not
+// idempotent, e.g. a webhook creation, are only retried if they could not
+// be sent at all: a timeout or a gateway error may come after Prism applied
+// them.
+
+package WebhooksListener
+
+import (
+  "errors"
+  "fmt"
+  "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/logger"
+  "aplos/partners/WebhooksListener/schemas"
+  "net"
+  "net/http"
+  "net/url"
+  "strings"
+  "sync"
+  "time"
+)
+
+type prismEndpoint struct {
+  // Prism endpoint of the cluster & its health.
+  host string
+  unhealthyUntil time.Time
+  lastError error
+}
+
+type prismClient struct {
+  // Endpoints of the cluster, the first one being the configured cluster IP.
+  lock sync.Mutex
+  endpoints []*prismEndpoint
+  current int
+}
+
+// This method will return the Prism client of the given cluster endpoints.
+//
+// Args:
+//    ip : IP address of the cluster.
+//    port : Prism port of the cluster.
+//    endpoints : Other addresses of the cluster, e.g. the CVM IPs, as "ip"
+//                or "ip:port".
+// Returns:
+//    prismClient : Prism client.
+//    error : Error if no endpoint is given.
+func newPrismClient(ip string, port string,
+  endpoints []string) (*prismClient, error) {
+  client := &prismClient{}
+  seen := map[string]bool{}
+  for _, endpoint := range append([]string{ip}, endpoints...) {
+    host, endpointPort, err := net.SplitHostPort(endpoint)
+    if (err != nil) {
+      host, endpointPort = endpoint, port
+    }
+    if (host == "") {
+      continue
+    }
+    address := net.JoinHostPort(host, endpointPort)
+    if (!seen[address]) {
+      seen[address] = true
+      client.endpoints = append(client.endpoints,
+                                &prismEndpoint{host: address})
+    }
+  }
+  if (len(client.endpoints) == 0) {
+    return nil, errors.New("no Prism endpoint, the cluster IP is required")
+  }
+  return client, nil
+}
+
+// This method will return the endpoints in the order they are tried: the
+// current endpoint if healthy, then the healthy endpoints, then the
+// unhealthy ones.
+//
+// Args:
+//    None.
+// Returns:
+//    []int : Indexes of the endpoints.
+func (client *prismClient) order() ([]int) {
+  client.lock.Lock()
+  defer client.lock.Unlock()
+  now := time.Now()
+  var healthy, unhealthy []int
+  for i := range client.endpoints {
+    index := (client.current + i) % len(client.endpoints)
+    if (now.Before(client.endpoints[index].unhealthyUntil)) {
+      unhealthy = append(unhealthy, index)
+    } else {
+      healthy = append(healthy, index)
+    }
+  }
+  return append(healthy, unhealthy...)
+}
+
+// This method will return the address of an endpoint.
+//
+// Args:
+//    index : Index of the endpoint.
+// Returns:
+//    string : Address of the endpoint, as "ip:port".
+func (client *prismClient) host(index int) (string) {
+  client.lock.Lock()
+  defer client.lock.Unlock()
+  return client.endpoints[index].host
+}
+
+// This method will record the outcome of a request to an endpoint. A
+// successful endpoint becomes the current one.
+//
+// Args:
+//    index : Index of the endpoint.
+//    err : Error of the endpoint, nil if it answered.
+// Returns:
+//    None.
+func (client *prismClient) record(index int, err error) {
+  client.lock.Lock()
+  defer client.lock.Unlock()
+  endpoint := client.endpoints[index]
+  endpoint.lastError = err
+  if (err != nil) {
+    endpoint.unhealthyUntil = time.Now().Add(lib.EndpointRetryInterval)
+    return
+  }
+  endpoint.unhealthyUntil = time.Time{}
+  client.current = index
+}
+
+// This method will return the address of the current endpoint.
+//
+// Args:
+//    None.
+// Returns:
+//    string : Address of the current endpoint, empty without endpoint.
+func (client *prismClient) currentHost() (string) {
+  if (client == nil) {
+    return ""
+  }
+  client.lock.Lock()
+  defer client.lock.Unlock()
+  if (len(client.endpoints) == 0) {
+    return ""
+  }
+  return client.endpoints[client.current].host
+}
+
+// This method will check the network connectivity with the cluster, or with
+// the proxy when the cluster is reached through one. Without proxy, the
+// endpoints are tried in order & the first reachable one becomes current.
+//
+// Args:
+//    requestURL : Prism URL of the cluster.
+// Returns:
+//    string : Local IP address of the network interface used for outbound
+//             communication.
+//    error : Error of the last endpoint, if none is reachable.
+func (webhooksListener WebhooksListener) checkPrismConnectivity(
+  requestURL string) (string, error) {
+  log := logger.With(logger.ClusterKey, webhooksListener.clusterIp)
+  proxyURL, err := lib.ResolveProxy(webhooksListener.ClusterProxy, requestURL)
+  if (err != nil) {
+    log.Error("Failed to resolve proxy for cluster.", logger.ErrorKey, err)
+    return "", err
+  }
+  if (proxyURL != nil) {
+    log.Info("Cluster is reached through proxy.", "proxy", proxyURL.Host)
+    connectPort := proxyURL.Port()
+    if (connectPort == "") {
+      connectPort = "80"
+      if (proxyURL.Scheme == "https") {
+        connectPort = "443"
+      }
+    }
+    return lib.CheckOutboundConnectivity(proxyURL.Hostname(), connectPort)
+  }
+
+  client := webhooksListener.status.prism
+  localIp := ""
+  err = errors.New("no Prism endpoint to connect to")
+  for _, index := range client.order() {
+    host, port, _ := net.SplitHostPort(client.host(index))
+    localIp, err = lib.CheckOutboundConnectivity(host, port)
+    client.record(index, err)
+    if (err == nil) {
+      return localIp, nil
+    }
+    log.Warn("Prism endpoint is not reachable.", "endpoint",
+      client.host(index), logger.ErrorKey, err)
+  }
+  return "", err
+}
+
+// This method will return the Prism URL of the given API path, on the
+// configured cluster IP. The requests are sent to the current endpoint.
+//
+// Args:
+//    path : API path, e.g. lib.ListWebhooks.
+// Returns:
+//    string : Prism URL.
+func (webhooksListener WebhooksListener) prismURL(path string) (string) {
+  return "https://" + net.JoinHostPort(webhooksListener.clusterIp,
+    webhooksListener.clusterPort) + path
+}
+
+// This method will perform a request to Prism, failing over across the
+// endpoints of the cluster.
+//
+// Args:
+//    request : Object with details required to perform the given request.
+// Returns:
+//    Response : HTTP response for the request.
+//    error : Error, if any.
+func (webhooksListener WebhooksListener) doFailoverRequest(
+  request schema.Request) (*http.Response, error) {
+  var client *prismClient
+  if (webhooksListener.status != nil) {
+    client = webhooksListener.status.prism
+  }
+  requestURL, err := url.Parse(request.URL)
+  if (client == nil || len(client.endpoints) < 2 || err != nil) {
+    return lib.DoRequest(request)
+  }
+
+  var response *http.Response
+  order := client.order()
+  for i, index := range order {
+    requestURL.Host = client.host(index)
+    request.URL = requestURL.String()
+    response, err = lib.DoRequest(request)
+    endpointErr := endpointError(response, err)
+    client.record(index, endpointErr)
+    if (endpointErr == nil || i == len(order) - 1 ||
+        (request.Context != nil && request.Context.Err() != nil) ||
+        !canFailOver(request, err)) {
+      break
+    }
+    logger.Warn("Prism endpoint failed, failing over.", logger.ClusterKey,
+      webhooksListener.clusterIp, "endpoint", requestURL.Host,
+      "next", client.host(order[i + 1]), logger.ErrorKey, endpointErr)
+    if (response != nil) {
+      response.Body.Close()
+    }
+  }
+  return response, err
+}
+
+// This method will return the error of the endpoint that answered a
+// request, if the request should be retried on another endpoint.
+//
+// Args:
+//    response : HTTP response for the request.
+//    err : Error of the request.
+// Returns:
+//    error : Error of the endpoint, nil if it answered.
+func endpointError(response *http.Response, err error) (error) {
+  if (err != nil) {
+    return err
+  }
+  switch response.StatusCode {
+  case http.StatusBadGateway, http.StatusServiceUnavailable,
+       http.StatusGatewayTimeout:
+    return fmt.Errorf("Prism returned HTTP status code %d",
+                      response.StatusCode)
+  }
+  return nil
+}
+
+// This method will check if a failed request may be sent again to another
+// endpoint: always if it is idempotent, i.e. a read, an update or a
+// deletion, otherwise only if no connection was established.
+//
+// Args:
+//    request : Failed request.
+//    err : Error of the request, nil if Prism answered.
+// Returns:
+//    bool : True if the request may be sent to another endpoint.
+func canFailOver(request schema.Request, err error) (bool) {
+  switch request.Method {
+  case "GET", "PUT", "DELETE":
+    return true
+  case "POST":
+    // The v3 list APIs are reads, posted for their filters.
+    requestURL, parseErr := url.Parse(request.URL)
+    if (parseErr == nil && strings.HasSuffix(requestURL.Path, "/list")) {
+      return true
+    }
+  }
+  var opErr *net.OpError
+  return (errors.As(err, &opErr) && opErr.Op == "dial")
+}
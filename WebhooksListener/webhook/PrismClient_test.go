@@ -0,0 +1,118 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+//
+// This test package apply unit tests on the failover across the Prism
+// endpoints of a cluster.
+//
+
+package WebhooksListener
+
+import (
+  "context"
+  "errors"
+  "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/schemas"
+  "net"
+  "net/http"
+  "net/http/httptest"
+  "net/url"
+  "reflect"
+  "testing"
+)
+
+// Test to verify the endpoints order is sticky & tries failed ones last.
+func TestPrismClientOrder(t *testing.T) {
+  client, err := newPrismClient("10.0.0.1", "9440",
+    []string{"10.0.0.2", "10.0.0.3:9441", "10.0.0.1:9440"})
+  if (err != nil) {
+    t.Fatalf("Failed to create the Prism client: %s", err)
+  }
+  if (len(client.endpoints) != 3 || client.host(2) != "10.0.0.3:9441") {
+    t.Fatalf("Unexpected endpoints %v", client.endpoints)
+  }
+  client.record(1, nil)
+  if order := client.order(); !reflect.DeepEqual(order, []int{1, 2, 0}) {
+    t.Errorf("Expected the current endpoint first, got %v", order)
+  }
+  client.record(1, errors.New("connection refused"))
+  if order := client.order(); !reflect.DeepEqual(order, []int{2, 0, 1}) {
+    t.Errorf("Expected the failed endpoint last, got %v", order)
+  }
+
+  if _, err = newPrismClient("", "9440", nil); (err == nil) {
+    t.Errorf("Expected an error without endpoint")
+  }
+  if host := (&prismClient{}).currentHost(); (host != "") {
+    t.Errorf("Expected no current endpoint, got %s", host)
+  }
+}
+
+// Test to verify the listener fails over from an unavailable endpoint.
+func TestPrismFailover(t *testing.T) {
+  prism := newFakePrism(t)
+  prismURL, _ := url.Parse(prism.server.URL)
+  unavailable := httptest.NewTLSServer(http.HandlerFunc(
+    func(responseWriter http.ResponseWriter, request *http.Request) {
+      writeJSON(responseWriter, http.StatusServiceUnavailable,
+        map[string]string{})
+    }))
+  defer unavailable.Close()
+  unavailableURL, _ := url.Parse(unavailable.URL)
+
+  // The cluster IP answers 503, the CVM endpoint takes over.
+  webhooksListener, err := WebhooksListener{
+    ListenerID: "test-listener",
+    ClusterEndpoints: []string{prismURL.Host},
+  }.Initialize(unavailableURL.Hostname(), unavailableURL.Port(), "admin",
+               "secret")
+  if (err != nil) {
+    t.Fatalf("Failed to initialize listener: %s", err)
+  }
+  err = webhooksListener.createOrUpdateWebhook([]string{lib.VM_ON})
+  if (err != nil || len(prism.webhooks) != 1) {
+    t.Errorf("Expected webhook created through the failover: %v", err)
+  }
+  endpoint := webhooksListener.readiness().Prism.Endpoint
+  if (endpoint != prismURL.Host) {
+    t.Errorf("Expected current endpoint %s, got %s", prismURL.Host, endpoint)
+  }
+
+  // The cluster IP is not even reachable.
+  unavailable.Close()
+  webhooksListener, err = WebhooksListener{
+    ListenerID: "test-listener",
+    ClusterEndpoints: []string{prismURL.Host},
+  }.Initialize(unavailableURL.Hostname(), unavailableURL.Port(), "admin",
+               "secret")
+  if (err != nil || webhooksListener.ClusterVersion() != prism.version) {
+    t.Errorf("Expected initialization through the CVM endpoint: %v", err)
+  }
+}
+
+// Test to verify the webhook creation only fails over when it could not be
+// sent.
+func TestCanFailOver(t *testing.T) {
+  dialErr := &url.Error{Op: "Post", Err: &net.OpError{Op: "dial",
+                        Err: errors.New("connection refused")}}
+  timeoutErr := &url.Error{Op: "Post", Err: context.DeadlineExceeded}
+  tests := []struct {
+    method string
+    path string
+    err error
+    expected bool
+  }{
+    {"GET", lib.GetClusterInfo, timeoutErr, true},
+    {"PUT", lib.UpdateWebhook + "uuid", nil, true},
+    {"POST", lib.ListWebhooks, timeoutErr, true},
+    {"POST", lib.CreateWebhook, dialErr, true},
+    {"POST", lib.CreateWebhook, timeoutErr, false},
+    {"POST", lib.CreateWebhook, nil, false},
+  }
+  for _, test := range tests {
+    request := schema.Request{Method: test.method,
+                              URL: "https://10.0.0.1:9440" + test.path}
+    if (canFailOver(request, test.err) != test.expected) {
+      t.Errorf("Expected failover %t for %s %s: %v", test.expected,
+        test.method, test.path, test.err)
+    }
+  }
+}
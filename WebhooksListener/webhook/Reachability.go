@@ -0,0 +1,165 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+
+// Reachability check of the callback URL. Before the webhook is registered,
+// the listener probes its advertised callback URL, from the process itself &
+// optionally from an external probe helper, so that a listener Prism cannot
+// reach fails to register instead of waiting for events that never come.
+//
+// A probe is a request carrying the probe header. The callback answers it
+// with the probe token of the listener, so that the probe proves the URL
+// reaches this listener & not another service on the same address.
+
+package WebhooksListener
+
+import (
+  "bytes"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io/ioutil"
+  "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/logger"
+  "aplos/partners/WebhooksListener/schemas"
+  "net/http"
+  "strings"
+)
+
+// Status reported in the probe responses.
+const probeStatus = "probe"
+
+// This method will answer a reachability probe with the probe token.
+//
+// Args:
+//    responseWriter : HTTP ResponseWriter object to write the response.
+//    request : HTTP Request object of the probe.
+// Returns:
+//    None.
+func (webhooksListener WebhooksListener) onProbe(
+  responseWriter http.ResponseWriter, request *http.Request) {
+  token := webhooksListener.status.probe()
+  logger.Debug("Received reachability probe.", logger.ClusterKey,
+    webhooksListener.clusterIp)
+  responseWriter.Header().Set(lib.ProbeHeader, token)
+  writeJSON(responseWriter, http.StatusOK,
+    schema.CallbackResponse{Status: probeStatus, Token: token})
+}
+
+// This method will check that the callback URL reaches this listener, from
+// the process & from the probe helper if one is configured.
+//
+// Args:
+//    None.
+// Returns:
+//    error : ReachabilityError if a probe fails.
+func (webhooksListener WebhooksListener) checkReachability() (error) {
+  callbackURL := webhooksListener.callbackURL()
+  token := webhooksListener.status.probe()
+  err := probeCallback(callbackURL, token)
+  if (err != nil) {
+    return &lib.ReachabilityError{URL: callbackURL, Probe: "loopback",
+                                  Err: err}
+  }
+  if (webhooksListener.ProbeHelperURL != "") {
+    err = webhooksListener.probeFromHelper(callbackURL, token)
+    if (err != nil) {
+      return &lib.ReachabilityError{URL: callbackURL, Probe: "external",
+                                    Err: err}
+    }
+  }
+  logger.Info("Callback URL is reachable.", logger.ClusterKey,
+    webhooksListener.clusterIp, "url", callbackURL)
+  return nil
+}
+
+// This method will send a reachability probe to the callback URL. The probe
+// is sent directly, the callback URL being reached by Prism without proxy.
+//
+// Args:
+//    callbackURL : Callback URL to probe.
+//    token : Probe token expected in the response.
+// Returns:
+//    error : Error if the probe fails or reaches another service.
+func probeCallback(callbackURL string, token string) (error) {
+  request, err := http.NewRequest(http.MethodGet, callbackURL, nil)
+  if (err != nil) {
+    return err
+  }
+  request.Header.Set(lib.ProbeHeader, "1")
+  client := &http.Client{
+    Transport: lib.NewTransport(schema.ProxyConfig{URL: lib.ProxyDirect}),
+    Timeout: lib.DefaultProbeTimeout,
+  }
+  response, err := client.Do(request)
+  if (err != nil) {
+    return err
+  }
+  defer response.Body.Close()
+  body, err := ioutil.ReadAll(response.Body)
+  if (err != nil) {
+    return err
+  }
+  return checkProbeResponse(response.StatusCode, body, token)
+}
+
+// This method will ask the probe helper to send a reachability probe to the
+// callback URL from outside the listener host.
+//
+// Args:
+//    callbackURL : Callback URL to probe.
+//    token : Probe token expected in the response.
+// Returns:
+//    error : Error if the helper cannot be used or the probe fails.
+func (webhooksListener WebhooksListener) probeFromHelper(callbackURL string,
+  token string) (error) {
+  probeRequest, _ := json.Marshal(schema.ProbeRequest{
+    URL: callbackURL,
+    Headers: map[string]string{lib.ProbeHeader: "1"},
+  })
+  client := &http.Client{Transport: lib.NewTransport(schema.ProxyConfig{}),
+                         Timeout: 2 * lib.DefaultProbeTimeout}
+  response, err := client.Post(webhooksListener.ProbeHelperURL,
+    "application/json", bytes.NewReader(probeRequest))
+  if (err != nil) {
+    return fmt.Errorf("probe helper %s failed: %s",
+                      webhooksListener.ProbeHelperURL, err)
+  }
+  defer response.Body.Close()
+  if (response.StatusCode != http.StatusOK) {
+    return fmt.Errorf("probe helper %s returned status %d",
+                      webhooksListener.ProbeHelperURL, response.StatusCode)
+  }
+  var probeResponse schema.ProbeResponse
+  err = json.NewDecoder(response.Body).Decode(&probeResponse)
+  if (err != nil) {
+    return fmt.Errorf("invalid probe helper response: %s", err)
+  }
+  if (probeResponse.Error != "") {
+    return errors.New(probeResponse.Error)
+  }
+  return checkProbeResponse(probeResponse.StatusCode,
+    []byte(probeResponse.Body), token)
+}
+
+// This method will check that a probe response comes from this listener.
+//
+// Args:
+//    statusCode : HTTP status code of the probe response.
+//    body : Body of the probe response.
+//    token : Expected probe token.
+// Returns:
+//    error : Error if the response is not the answer of this listener.
+func checkProbeResponse(statusCode int, body []byte, token string) (error) {
+  if (statusCode != http.StatusOK) {
+    return fmt.Errorf("probe returned status %d: %s", statusCode,
+                      strings.TrimSpace(string(body)))
+  }
+  var probeResponse schema.CallbackResponse
+  err := json.Unmarshal(body, &probeResponse)
+  if (err != nil || probeResponse.Status != probeStatus) {
+    return errors.New("the URL is served by another service")
+  }
+  if (probeResponse.Token != token) {
+    return errors.New("the URL is served by another listener")
+  }
+  return nil
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+//
+// This test package apply unit tests on the reachability check of the
+// callback URL during registration.
+//
+
+package WebhooksListener
+
+import (
+  "encoding/json"
+  "errors"
+  "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/schemas"
+  "net"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+  "time"
+)
+
+// This method will return a free local port.
+func freePort(t *testing.T) (string) {
+  listener, err := net.Listen("tcp", "127.0.0.1:0")
+  if (err != nil) {
+    t.Fatalf("Failed to find a free port: %s", err)
+  }
+  defer listener.Close()
+  _, port, _ := net.SplitHostPort(listener.Addr().String())
+  return port
+}
+
+// Test to verify the webhook is registered only if the callback URL is
+// reachable.
+func TestRegisterReachability(t *testing.T) {
+  prism := newFakePrism(t)
+
+  // Unreachable callback URL: no webhook is created.
+  webhooksListener := prism.listener(t)
+  webhooksListener.BindAddress = "127.0.0.1"
+  webhooksListener.ListenerPort = freePort(t)
+  webhooksListener.AdvertiseURL = "http://127.0.0.1:" + freePort(t) +
+    "/events"
+  webhooksListener.ListenerState = make(chan string)
+  err := webhooksListener.RegisterForEvents([]string{lib.VM_ON},
+                                            testConsumer{})
+  var reachabilityError *lib.ReachabilityError
+  if (!errors.As(err, &reachabilityError) ||
+      reachabilityError.Probe != "loopback") {
+    t.Errorf("Expected a loopback reachability error, got %v", err)
+  }
+  if (len(prism.webhooks) != 0) {
+    t.Errorf("Expected no webhook, got %d", len(prism.webhooks))
+  }
+  if eventConsumer, queue := webhooksListener.status.consumer(); (
+      eventConsumer != nil || queue != nil ||
+      webhooksListener.status.dispatching) {
+    t.Errorf("Expected no consumer nor dispatcher after a failure")
+  }
+  time.Sleep(50 * time.Millisecond)
+  select {
+  case _, ok := <-webhooksListener.ListenerState:
+    if (!ok) {
+      t.Errorf("Expected the caller's state channel left open")
+    }
+  default:
+  }
+
+  // A Unix socket is not reachable by Prism without an advertised URL.
+  webhooksListener = prism.listener(t)
+  webhooksListener.UnixSocket = t.TempDir() + "/listener.sock"
+  err = webhooksListener.RegisterForEvents([]string{lib.VM_ON},
+                                           testConsumer{})
+  if (err == nil || !strings.Contains(err.Error(), "advertise URL")) {
+    t.Errorf("Expected an advertise URL error, got %v", err)
+  }
+
+  // Reachable callback URL, also probed by the helper.
+  helperCalled := false
+  helper := httptest.NewServer(http.HandlerFunc(
+    func(responseWriter http.ResponseWriter, request *http.Request) {
+      var probeRequest schema.ProbeRequest
+      json.NewDecoder(request.Body).Decode(&probeRequest)
+      helperCalled = (probeRequest.Headers[lib.ProbeHeader] != "")
+      probeResponse := schema.ProbeResponse{StatusCode: http.StatusOK}
+      probe, _ := http.NewRequest(http.MethodGet, probeRequest.URL, nil)
+      probe.Header.Set(lib.ProbeHeader, "1")
+      response, err := http.DefaultClient.Do(probe)
+      if (err != nil) {
+        probeResponse.Error = err.Error()
+      } else {
+        var body schema.CallbackResponse
+        json.NewDecoder(response.Body).Decode(&body)
+        response.Body.Close()
+        encoded, _ := json.Marshal(body)
+        probeResponse.Body = string(encoded)
+      }
+      writeJSON(responseWriter, http.StatusOK, probeResponse)
+    }))
+  defer helper.Close()
+  webhooksListener = prism.listener(t)
+  webhooksListener.BindAddress = "127.0.0.1"
+  webhooksListener.ListenerPort = freePort(t)
+  webhooksListener.AdvertiseURL = "http://127.0.0.1:" +
+    webhooksListener.ListenerPort + webhooksListener.callbackPath()
+  webhooksListener.ProbeHelperURL = helper.URL
+  err = webhooksListener.RegisterForEvents([]string{lib.VM_ON},
+                                           testConsumer{})
+  if (err != nil || !helperCalled) {
+    t.Errorf("Expected registration probed by the helper, got %v", err)
+  }
+  if (len(webhookEvents(t, prism, webhooksListener)) != 1 ||
+      !webhooksListener.status.dispatching) {
+    t.Errorf("Expected the webhook to be registered & dispatched")
+  }
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+
+// Callback HTTP server of the WebhooksListener. The server bounds the time
+// & resources a peer may hold: request read/write timeouts, idle keep-alive
+// timeout, header size & the number of concurrent connections. It listens
+// on a TCP port or a Unix socket, over TLS if a certificate is set, with the
+// callback URL advertised to Prism derived from the listener's address
+// unless set explicitly.
+
+package WebhooksListener
+
+import (
+  "crypto/tls"
+  "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/schemas"
+  "golang.org/x/net/netutil"
+  "fmt"
+  "net"
+  "net/http"
+  "os"
+)
+
+// This method will return the server limits of the listener with defaults
+// applied.
+//
+// Args:
+//    None.
+// Returns:
+//    ServerLimits : Server limits.
+func (webhooksListener WebhooksListener) serverLimits() (schema.ServerLimits) {
+  limits := webhooksListener.ServerLimits
+  if (limits.ReadHeaderTimeout <= 0) {
+    limits.ReadHeaderTimeout = lib.DefaultReadHeaderTimeout
+  }
+  if (limits.ReadTimeout <= 0) {
+    limits.ReadTimeout = lib.DefaultReadTimeout
+  }
+  if (limits.WriteTimeout <= 0) {
+    limits.WriteTimeout = lib.DefaultWriteTimeout
+  }
+  if (limits.IdleTimeout <= 0) {
+    limits.IdleTimeout = lib.DefaultIdleTimeout
+  }
+  if (limits.MaxHeaderBytes <= 0) {
+    limits.MaxHeaderBytes = lib.DefaultMaxHeaderBytes
+  }
+  if (limits.MaxConnections <= 0) {
+    limits.MaxConnections = lib.DefaultMaxConnections
+  }
+  return limits
+}
+
+// This method will build the callback HTTP server for the given handler.
+//
+// Args:
+//    handler : Handler of the requests.
+// Returns:
+//    Server : HTTP server with the listener's limits.
+func (webhooksListener WebhooksListener) newServer(
+  handler http.Handler) (*http.Server) {
+  limits := webhooksListener.serverLimits()
+  return &http.Server{
+    Handler: handler,
+    ReadHeaderTimeout: limits.ReadHeaderTimeout,
+    ReadTimeout: limits.ReadTimeout,
+    WriteTimeout: limits.WriteTimeout,
+    IdleTimeout: limits.IdleTimeout,
+    MaxHeaderBytes: limits.MaxHeaderBytes,
+  }
+}
+
+// This method will open the socket of the callback server, accepting at
+// most the configured number of concurrent connections. The server listens
+// on the Unix socket if one is set, on the bind address & listener port
+// otherwise, & terminates TLS if a certificate is set.
+//
+// Args:
+//    None.
+// Returns:
+//    Listener : Network listener.
+//    error : Error, if any.
+func (webhooksListener WebhooksListener) listen() (net.Listener, error) {
+  var listener net.Listener
+  var err error
+  if (webhooksListener.UnixSocket != "") {
+    // Remove the socket left behind by a previous run.
+    info, statErr := os.Stat(webhooksListener.UnixSocket)
+    if (statErr == nil && info.Mode() & os.ModeSocket != 0) {
+      os.Remove(webhooksListener.UnixSocket)
+    }
+    listener, err = net.Listen("unix", webhooksListener.UnixSocket)
+  } else {
+    port := webhooksListener.ListenerPort
+    if (port == "") {
+      port = lib.DefaultListenerPort
+    }
+    listener, err = net.Listen("tcp",
+      net.JoinHostPort(webhooksListener.BindAddress, port))
+  }
+  if (err != nil) {
+    return nil, err
+  }
+  listener = netutil.LimitListener(listener,
+    webhooksListener.serverLimits().MaxConnections)
+  if (webhooksListener.TLSCertFile != "") {
+    certificate, err := tls.LoadX509KeyPair(webhooksListener.TLSCertFile,
+      webhooksListener.TLSKeyFile)
+    if (err != nil) {
+      listener.Close()
+      return nil, fmt.Errorf("failed to load TLS certificate: %s", err)
+    }
+    listener = tls.NewListener(listener, &tls.Config{
+      Certificates: []tls.Certificate{certificate},
+      MinVersion: tls.VersionTLS12,
+    })
+  }
+  return listener, nil
+}
+
+// This method will return the path of the callback URL.
+//
+// Args:
+//    None.
+// Returns:
+//    string : Callback path.
+func (webhooksListener WebhooksListener) callbackPath() (string) {
+  if (webhooksListener.CallbackPath == "") {
+    return lib.ListenerCallbackURL
+  }
+  return webhooksListener.CallbackPath
+}
+
+// This method will return the callback URL registered with Prism: the
+// advertised URL if set, the listener's address & port otherwise.
+//
+// Args:
+//    None.
+// Returns:
+//    string : Callback URL.
+func (webhooksListener WebhooksListener) callbackURL() (string) {
+  if (webhooksListener.AdvertiseURL != "") {
+    return webhooksListener.AdvertiseURL
+  }
+  port := webhooksListener.ListenerPort
+  if (port == "") {
+    port = lib.DefaultListenerPort
+  }
+  scheme := "http"
+  if (webhooksListener.TLSCertFile != "") {
+    scheme = "https"
+  }
+  return fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(
+    webhooksListener.listenerIp, port), webhooksListener.callbackPath())
+}
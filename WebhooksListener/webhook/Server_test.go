@@ -0,0 +1,54 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+//
+// This test package apply unit tests on the handler & callback URL of the
+// listener.
+//
+
+package WebhooksListener
+
+import (
+  "aplos/partners/WebhooksListener/lib"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+)
+
+// Test to verify several listeners expose their own handlers.
+func TestHandler(t *testing.T) {
+  first := WebhooksListener{status: &listenerStatus{}}
+  second := WebhooksListener{status: &listenerStatus{},
+                             CallbackPath: "/nutanix/events"}
+  first.Handler()
+  handler := second.Handler()
+
+  recorder := httptest.NewRecorder()
+  request := httptest.NewRequest("POST", "/nutanix/events",
+    strings.NewReader("{}"))
+  request.Header.Set("Content-Type", "application/json")
+  handler.ServeHTTP(recorder, request)
+  if (recorder.Code != http.StatusBadRequest) {
+    t.Errorf("Expected callback to reject empty event, got %d",
+      recorder.Code)
+  }
+
+  recorder = httptest.NewRecorder()
+  handler.ServeHTTP(recorder, httptest.NewRequest("GET", lib.HealthzURL, nil))
+  if (recorder.Code != http.StatusOK) {
+    t.Errorf("Unexpected liveness status code %d", recorder.Code)
+  }
+}
+
+// Test to verify the callback URL registered with Prism.
+func TestCallbackURL(t *testing.T) {
+  webhooksListener := WebhooksListener{listenerIp: "10.1.1.5"}
+  if url := webhooksListener.callbackURL();
+     (url != "http://10.1.1.5:8080/listener/callback") {
+    t.Errorf("Unexpected default callback URL %s", url)
+  }
+  webhooksListener.AdvertiseURL = "https://lb.example.com/nutanix/events"
+  if url := webhooksListener.callbackURL();
+     (url != webhooksListener.AdvertiseURL) {
+    t.Errorf("Unexpected advertised callback URL %s", url)
+  }
+}
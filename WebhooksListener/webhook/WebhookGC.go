@@ -0,0 +1,163 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+
+// Collection of the orphaned webhooks, i.e. the listener webhooks whose
+// post_url no longer accepts connections, e.g. those left behind by test
+// listeners. The webhooks are only reported unless deletion is requested,
+// so that the orphans can be reviewed first. The post_url is probed from
+// the host running the collection: a live listener only reachable from the
+// cluster network looks orphaned, hence each deletion may be confirmed.
+
+package WebhooksListener
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/logger"
+  "aplos/partners/WebhooksListener/schemas"
+  "aplos/partners/WebhooksListener/tracing"
+  "net"
+  "net/url"
+  "path"
+  "time"
+)
+
+// This method will find the listener webhooks of the cluster whose
+// post_url is unreachable & delete them if requested. The webhook of this
+// listener is never collected.
+//
+// Args:
+//    options : Filters of the webhooks & whether to delete them.
+// Returns:
+//    []WebhookGCResult : Outcome for every webhook matching the name filter.
+//    error : Error, if the webhooks cannot be listed or deleted.
+func (webhooksListener WebhooksListener) CollectOrphanedWebhooks(
+  options schema.WebhookGCOptions) ([]schema.WebhookGCResult, error) {
+  log := logger.With(logger.ClusterKey, webhooksListener.clusterIp)
+  ctx, span := tracing.StartSpan(nil, "webhook.gc",
+    tracing.ClusterKey.String(webhooksListener.clusterIp))
+  defer span.End()
+
+  namePattern := options.NamePattern
+  if (namePattern == "") {
+    namePattern = lib.WebhookNamePrefix + "*"
+  }
+  if _, err := path.Match(namePattern, ""); (err != nil) {
+    return nil, fmt.Errorf("invalid name pattern %q: %s", namePattern, err)
+  }
+  probeTimeout := options.ProbeTimeout
+  if (probeTimeout <= 0) {
+    probeTimeout = lib.DefaultProbeTimeout
+  }
+
+  webhooks, err := webhooksListener.listWebhooks(ctx)
+  if (err != nil) {
+    tracing.RecordError(span, err)
+    return nil, err
+  }
+  var results []schema.WebhookGCResult
+  var errs []error
+  for _, webhook := range webhooks {
+    if matched, _ := path.Match(namePattern, webhook.Spec.Name); !matched {
+      continue
+    }
+    result := schema.WebhookGCResult{
+      UUID: webhook.Metadata.UUID,
+      Name: webhook.Spec.Name,
+      PostURL: webhook.Spec.Resources.PostURL,
+      CreationTime: webhook.Metadata.CreationTime,
+    }
+    result.Orphaned, result.Reason = webhooksListener.isOrphaned(webhook,
+      options.MinAge, probeTimeout)
+    if (result.Orphaned && options.Delete && options.Confirm != nil &&
+        !options.Confirm(result)) {
+      log.Info("Kept orphaned webhook, deletion declined.", "uuid",
+        result.UUID)
+      result.Declined = true
+    } else if (result.Orphaned && options.Delete) {
+      err = webhooksListener.deleteWebhook(ctx, webhook.Metadata.UUID)
+      if (err != nil) {
+        log.Error("Failed to delete orphaned webhook.", "uuid", result.UUID,
+          logger.ErrorKey, err)
+        errs = append(errs, err)
+        result.Reason = fmt.Sprintf("%s; deletion failed: %s", result.Reason,
+                                    err)
+      } else {
+        log.Info("Deleted orphaned webhook.", "uuid", result.UUID,
+          "post_url", result.PostURL)
+        result.Deleted = true
+      }
+    }
+    results = append(results, result)
+  }
+  err = errors.Join(errs...)
+  tracing.RecordError(span, err)
+  return results, err
+}
+
+// This method will check if a webhook is orphaned.
+//
+// Args:
+//    webhook : Webhook to check.
+//    minAge : Webhooks created more recently are kept.
+//    probeTimeout : Timeout of the post_url reachability probe.
+// Returns:
+//    bool : True if the webhook is orphaned.
+//    string : Reason of the decision.
+func (webhooksListener WebhooksListener) isOrphaned(webhook schema.Webhook,
+  minAge time.Duration, probeTimeout time.Duration) (bool, string) {
+  listenerID, err := lib.ListenerIDFromDescription(webhook.Spec.Description)
+  if (err == nil && listenerID == webhooksListener.ListenerID) {
+    return false, "webhook of this listener"
+  }
+  if (minAge > 0) {
+    created, err := time.Parse(time.RFC3339, webhook.Metadata.CreationTime)
+    if (err != nil) {
+      return false, "unknown age"
+    }
+    if (time.Since(created) < minAge) {
+      return false, fmt.Sprintf("younger than %s", minAge)
+    }
+  }
+  err = probeURL(webhook.Spec.Resources.PostURL, probeTimeout)
+  if (err == nil) {
+    return false, "post_url reachable"
+  }
+  return true, fmt.Sprintf("post_url unreachable from this host: %s", err)
+}
+
+// This method will check that the server of the given URL accepts
+// connections.
+//
+// Args:
+//    postURL : URL to probe.
+//    timeout : Connection timeout.
+// Returns:
+//    error : Error if the server cannot be reached.
+func probeURL(postURL string, timeout time.Duration) (error) {
+  parsed, err := url.Parse(postURL)
+  if (err != nil) {
+    return err
+  }
+  port := parsed.Port()
+  if (port == "") {
+    port = "80"
+    if (parsed.Scheme == "https") {
+      port = "443"
+    }
+  }
+  if (parsed.Hostname() == "") {
+    return errors.New("no host in URL")
+  }
+  ctx, cancel := context.WithTimeout(context.Background(), timeout)
+  defer cancel()
+  var dialer net.Dialer
+  conn, err := dialer.DialContext(ctx, "tcp",
+    net.JoinHostPort(parsed.Hostname(), port))
+  if (err != nil) {
+    return err
+  }
+  conn.Close()
+  return nil
+}
@@ -0,0 +1,219 @@
+// Copyright (c) 2017 Nutanix Inc. All rights reserved.
+//
+// This test package apply unit tests on the creation, update & deletion of
+// the listener's webhook.
+//
+
+package WebhooksListener
+
+import (
+  "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/schemas"
+  "net/http"
+  "net/http/httptest"
+  "reflect"
+  "strings"
+  "testing"
+  "time"
+)
+
+// This method will return the events of the listener's webhook.
+func webhookEvents(t *testing.T, prism *fakePrism,
+  webhooksListener WebhooksListener) ([]string) {
+  webhooksListener.status.lock.RLock()
+  uuid := webhooksListener.status.webhookUUID
+  webhooksListener.status.lock.RUnlock()
+  webhook := prism.webhook(uuid)
+  if (webhook == nil) {
+    return nil
+  }
+  return webhook.Spec.Resources.EventsFilterList
+}
+
+// Test to verify the merge & replace subscription modes.
+func TestSubscriptionModes(t *testing.T) {
+  prism := newFakePrism(t)
+  webhooksListener := prism.listener(t)
+
+  err := webhooksListener.createOrUpdateWebhook([]string{lib.VM_ON})
+  if (err == nil) {
+    err = webhooksListener.createOrUpdateWebhook([]string{lib.VM_OFF})
+  }
+  events := webhookEvents(t, prism, webhooksListener)
+  if (err != nil || !reflect.DeepEqual(events,
+                                       []string{lib.VM_ON, lib.VM_OFF})) {
+    t.Errorf("Expected merged events, got %v: %v", events, err)
+  }
+
+  webhooksListener.SubscriptionMode = lib.SubscriptionReplace
+  err = webhooksListener.createOrUpdateWebhook([]string{lib.VM_CREATE})
+  events = webhookEvents(t, prism, webhooksListener)
+  if (err != nil || !reflect.DeepEqual(events, []string{lib.VM_CREATE})) {
+    t.Errorf("Expected replaced events, got %v: %v", events, err)
+  }
+}
+
+// Test to verify unregistering narrows then deletes the webhook.
+func TestUnregisterForEvents(t *testing.T) {
+  prism := newFakePrism(t)
+  webhooksListener := prism.listener(t)
+  err := webhooksListener.createOrUpdateWebhook([]string{lib.VM_ON,
+                                                         lib.VM_OFF})
+  if (err != nil) {
+    t.Fatalf("Failed to register: %s", err)
+  }
+  webhooksListener.status.lock.RLock()
+  uuid := webhooksListener.status.webhookUUID
+  webhooksListener.status.lock.RUnlock()
+
+  err = webhooksListener.UnregisterForEvents([]string{lib.VM_ON})
+  events := webhookEvents(t, prism, webhooksListener)
+  if (err != nil || !reflect.DeepEqual(events, []string{lib.VM_OFF})) {
+    t.Errorf("Expected narrowed events, got %v: %v", events, err)
+  }
+
+  err = webhooksListener.UnregisterForEvents([]string{lib.VM_OFF})
+  readiness := webhooksListener.readiness()
+  if (err != nil || prism.webhook(uuid) != nil ||
+      readiness.Webhook.Registered || readiness.Webhook.UUID != "") {
+    t.Errorf("Expected webhook deleted, got %v: %v", readiness, err)
+  }
+}
+
+// Test to verify the answers of Prism to the webhook operations: pending
+// then read back, applied with 200, refused, and a failed listing.
+func TestWebhookOperationAnswers(t *testing.T) {
+  prism := newFakePrism(t)
+  webhooksListener := prism.listener(t)
+
+  prism.putMode = putPending
+  err := webhooksListener.createOrUpdateWebhook([]string{lib.VM_ON})
+  readiness := webhooksListener.readiness()
+  if (err != nil || !readiness.Webhook.Registered ||
+      readiness.Webhook.State != completeStatus ||
+      prism.requests[len(prism.requests) - 1] !=
+        "GET " + lib.UpdateWebhook + readiness.Webhook.UUID) {
+    t.Errorf("Expected the pending webhook read back, got %v: %v",
+      readiness, err)
+  }
+
+  prism.webhooks = map[string]*schema.Webhook{}
+  prism.putMode = putOK
+  err = webhooksListener.createOrUpdateWebhook([]string{lib.VM_ON,
+                                                        lib.VM_OFF})
+  readiness = webhooksListener.readiness()
+  if (err != nil || !readiness.Webhook.Registered ||
+      prism.webhook(readiness.Webhook.UUID) == nil) {
+    t.Errorf("Expected the created webhook UUID, got %v: %v", readiness,
+      err)
+  }
+
+  prism.putMode = putError
+  err = webhooksListener.UpdateSubscriptions([]string{lib.VM_CREATE})
+  if (err == nil || !strings.Contains(err.Error(), "500") ||
+      !strings.Contains(err.Error(), "webhook operation failed")) {
+    t.Errorf("Expected the refused update reported, got %v", err)
+  }
+  err = webhooksListener.UnregisterForEvents([]string{lib.VM_OFF})
+  events := webhookEvents(t, prism, webhooksListener)
+  if (err == nil || len(events) != 2) {
+    t.Errorf("Expected the refused unregistration reported, got %v: %v",
+      events, err)
+  }
+
+  prism.listStatus = http.StatusUnauthorized
+  prism.webhooks = map[string]*schema.Webhook{}
+  err = webhooksListener.createOrUpdateWebhook([]string{lib.VM_ON})
+  if (err == nil || len(prism.webhooks) != 0) {
+    t.Errorf("Expected a listing error & no webhook created, got %v", err)
+  }
+}
+
+// Test to verify the listener takes over its webhook after an address
+// change, leaving the webhooks of other listeners alone.
+func TestWebhookTakeover(t *testing.T) {
+  prism := newFakePrism(t)
+  ownUUID := prism.addWebhook(lib.WebhookName("test-listener"),
+    lib.WebhookDescription("test-listener"),
+    "http://10.1.1.5:8080/listener/callback", []string{lib.VM_ON})
+  otherUUID := prism.addWebhook(lib.WebhookName("other-listener"),
+    lib.WebhookDescription("other-listener"),
+    "http://10.1.1.6:8080/listener/callback", []string{lib.VM_ON})
+  webhooksListener := prism.listener(t)
+  webhooksListener.AdvertiseURL = "http://10.1.2.7:9090/listener/callback"
+
+  err := webhooksListener.createOrUpdateWebhook([]string{lib.VM_OFF})
+  own := prism.webhook(ownUUID)
+  if (err != nil || len(prism.webhooks) != 2 ||
+      own.Spec.Resources.PostURL != webhooksListener.AdvertiseURL ||
+      !reflect.DeepEqual(own.Spec.Resources.EventsFilterList,
+                         []string{lib.VM_ON, lib.VM_OFF})) {
+    t.Errorf("Expected own webhook taken over, got %+v: %v", own, err)
+  }
+  other := prism.webhook(otherUUID)
+  if (other.Spec.Resources.PostURL != "http://10.1.1.6:8080/listener/callback") {
+    t.Errorf("Expected other webhook untouched, got %+v", other)
+  }
+}
+
+// Test to verify only the unreachable listener webhooks are collected.
+func TestCollectOrphanedWebhooks(t *testing.T) {
+  prism := newFakePrism(t)
+  reachable := httptest.NewServer(http.NotFoundHandler())
+  defer reachable.Close()
+  unreachable := httptest.NewServer(http.NotFoundHandler())
+  unreachable.Close()
+
+  liveUUID := prism.addWebhook(lib.WebhookName("live"), "",
+    reachable.URL + lib.ListenerCallbackURL, []string{lib.VM_ON})
+  deadUUID := prism.addWebhook(lib.WebhookName("dead"), "",
+    unreachable.URL + lib.ListenerCallbackURL, []string{lib.VM_ON})
+  ownUUID := prism.addWebhook(lib.WebhookName("test-listener"),
+    lib.WebhookDescription("test-listener"),
+    unreachable.URL + lib.ListenerCallbackURL, []string{lib.VM_ON})
+  otherUUID := prism.addWebhook("Backup_Webhook", "",
+    unreachable.URL + "/backup", []string{lib.VM_ON})
+  webhooksListener := prism.listener(t)
+
+  results, err := webhooksListener.CollectOrphanedWebhooks(
+    schema.WebhookGCOptions{})
+  if (err != nil || len(results) != 3 || prism.webhook(deadUUID) == nil) {
+    t.Fatalf("Expected 3 webhooks listed & none deleted, got %v: %v",
+      results, err)
+  }
+  for _, result := range results {
+    if (result.Orphaned != (result.UUID == deadUUID)) {
+      t.Errorf("Unexpected outcome %+v", result)
+    }
+  }
+
+  results, err = webhooksListener.CollectOrphanedWebhooks(
+    schema.WebhookGCOptions{Delete: true,
+      Confirm: func(result schema.WebhookGCResult) (bool) {
+        return false
+      }})
+  for _, result := range results {
+    if (result.Declined != (result.UUID == deadUUID) || result.Deleted) {
+      t.Errorf("Expected the deletion declined, got %+v", result)
+    }
+  }
+  if (err != nil || prism.webhook(deadUUID) == nil) {
+    t.Errorf("Expected the declined webhook kept: %v", err)
+  }
+
+  _, err = webhooksListener.CollectOrphanedWebhooks(
+    schema.WebhookGCOptions{Delete: true})
+  if (err != nil || prism.webhook(deadUUID) != nil ||
+      prism.webhook(liveUUID) == nil || prism.webhook(ownUUID) == nil ||
+      prism.webhook(otherUUID) == nil) {
+    t.Errorf("Expected only the orphaned webhook deleted: %v", err)
+  }
+
+  results, err = webhooksListener.CollectOrphanedWebhooks(
+    schema.WebhookGCOptions{MinAge: time.Hour, Delete: true})
+  if (err != nil || len(results) != 2 || results[0].Orphaned ||
+      results[1].Orphaned) {
+    t.Errorf("Expected webhooks of unknown age kept, got %v: %v", results,
+      err)
+  }
+}
@@ -4,17 +4,21 @@
 package WebhooksListener
 
 import (
+  "context"
   "fmt"
   "errors"
   "encoding/json"
-  "github.com/golang/glog"
   "io/ioutil"
-  "reflect"
+  "net"
   "strings"
   "aplos/partners/WebhooksListener/schemas"
   "aplos/partners/WebhooksListener/lib"
+  "aplos/partners/WebhooksListener/logger"
+  "aplos/partners/WebhooksListener/metrics"
+  "aplos/partners/WebhooksListener/tracing"
   "aplos/partners/WebhooksListener/interfaces"
   "net/http"
+  "time"
 )
 
 type WebhooksListener struct {
@@ -23,14 +27,39 @@ type WebhooksListener struct {
   // Public properties of the WebhooksListener.
   ListenerPort string // Allows the event consumer to define the local port.
   ListenerState chan string // Message channel to communcate WebhooksListener status.
+                            // Owned by the caller, never closed by the
+                            // listener. Messages are dropped when not read.
+  ClusterProxy schema.ProxyConfig // Proxy used to reach the Nutanix cluster.
+  ClusterEndpoints []string // Other Prism addresses of the cluster, e.g. CVMs.
+  WatchdogInterval time.Duration // Interval of the webhook state refresh.
+  RetryPolicy schema.RetryPolicy // Retries of failed consumer calls.
+  QueueSize int // Number of received events buffered for dispatch.
+  MaxEventSize int64 // Maximum size in bytes of a received event.
+  CallbackCredentials schema.Credentials // Basic auth required from Prism.
+  AllowedSources []string // IPs or CIDRs allowed to post events, any if empty.
+  ServerLimits schema.ServerLimits // Timeouts & limits of the HTTP server.
+  BindAddress string // Local address to listen on, all addresses if empty.
+  UnixSocket string // Unix socket to listen on instead of the TCP port.
+  DisableServer bool // Serve the callback only through Handler().
+  CallbackPath string // Path of the callback URL.
+  AdvertiseURL string // Callback URL given to Prism, when not reached directly.
+  SubscriptionMode string // lib.SubscriptionMerge (default) or Replace.
+  ListenerID string // Persistent identity, loaded from ListenerIDFile if empty.
+  ListenerIDFile string // File storing the generated listener ID. Defaults
+                        // to a file per port or socket in the state dir.
+  SkipReachabilityCheck bool // Register without probing the callback URL.
+  ProbeHelperURL string // Service probing the callback URL from outside.
+  TLSCertFile string // Certificate of the callback server, served over TLS.
+  TLSKeyFile string // Private key of the TLS certificate.
 
   // Private properties of the WebhooksListener.
   clusterIp string
   clusterPort string
   clusterUsername string
   clusterPassword string
-  eventConsumer interface{}
+  clusterVersion string
   listenerIp string
+  status *listenerStatus
 
 }
 
@@ -63,46 +92,137 @@ const (
 func (webhooksListener WebhooksListener) Initialize(ip string, port string,
   username string, password string) (WebhooksListener, error) {
   var err error
-  glog.Info("Initializing listener..")
+  log := logger.With(logger.ClusterKey, ip)
+  log.Info("Initializing listener..")
   webhooksListener.clusterIp = ip
   webhooksListener.clusterPort = port
   webhooksListener.clusterUsername = username
   webhooksListener.clusterPassword = password
+  prism, err := newPrismClient(ip, port, webhooksListener.ClusterEndpoints)
+  webhooksListener.status = &listenerStatus{prism: prism}
+  if (err != nil) {
+    log.Error("Invalid cluster endpoints.", logger.ErrorKey, err)
+    return webhooksListener, err
+  }
 
   if (webhooksListener.ListenerPort == "") {
     webhooksListener.ListenerPort = lib.DefaultListenerPort
   }
 
-  // Check network connectivity with the cluster.
-  glog.Info("Verifying connectivity with cluster.")
-  localIp, err := lib.CheckOutboundConnectivity(webhooksListener.clusterIp,
-    webhooksListener.clusterPort)
+  // Load the listener ID identifying the listener's webhook. Listeners
+  // sharing a port, e.g. mounted with DisableServer, must set their own ID
+  // or ID file.
+  if (webhooksListener.ListenerID == "") {
+    listenerIDFile := webhooksListener.ListenerIDFile
+    if (listenerIDFile == "" && webhooksListener.UnixSocket != "") {
+      listenerIDFile = lib.DefaultListenerIDFile(webhooksListener.UnixSocket)
+    } else if (listenerIDFile == "") {
+      listenerIDFile = lib.DefaultListenerIDFile(
+        webhooksListener.ListenerPort)
+    }
+    webhooksListener.ListenerID, err = lib.LoadOrCreateListenerID(
+      listenerIDFile)
+    if (err != nil) {
+      log.Error("Failed to load listener ID.", logger.ErrorKey, err)
+      return webhooksListener, err
+    }
+  } else if (!lib.ValidListenerID(webhooksListener.ListenerID)) {
+    err = fmt.Errorf("invalid listener ID %q", webhooksListener.ListenerID)
+    log.Error("Cannot use listener ID.", logger.ErrorKey, err)
+    return webhooksListener, err
+  }
+  log.Info("Using listener ID.", "listener_id", webhooksListener.ListenerID)
+
+  // Check network connectivity with the cluster, or with the proxy when the
+  // cluster is reached through one.
+  log.Info("Verifying connectivity with cluster.")
+  requestURL := webhooksListener.prismURL(lib.GetCurrentUser)
+  localIp, err := webhooksListener.checkPrismConnectivity(requestURL)
   if (err != nil) {
-    glog.Error("Failed to verify connectivity with cluster.", err)
+    log.Error("Failed to verify connectivity with cluster.",
+      logger.ErrorKey, err)
     return webhooksListener, err
   }
   webhooksListener.listenerIp = localIp
 
   // Check if given credentials are valid.
-  glog.Info("Authenticating cluster credentials.")
-  requestURL := fmt.Sprintf("https://%s:%s%s", webhooksListener.clusterIp,
-    webhooksListener.clusterPort, lib.GetCurrentUser)
-  request := lib.PrepareRequest(requestURL, webhooksListener.clusterUsername,
-                                  webhooksListener.clusterPassword, "GET")
-  glog.Info("Making http request : %v", requestURL)
-  response, err := lib.DoRequest(request)
+  log.Info("Authenticating cluster credentials.")
+  request := lib.PrepareRequestWithProxy(requestURL,
+    webhooksListener.clusterUsername, webhooksListener.clusterPassword, "GET",
+    webhooksListener.ClusterProxy)
+  log.Info("Making http request.", logger.URLKey, requestURL)
+  response, err := webhooksListener.doPrismRequest(request)
   if (err != nil) {
-    glog.Error("Unable to login cluster with given credentials. Error: ", err)
+    log.Error("Unable to login cluster with given credentials.",
+      logger.ErrorKey, err)
     return webhooksListener, err
   }
+  defer response.Body.Close()
   if (response.StatusCode != 200) {
     msg := fmt.Sprintf("Error verifying cluster credentials. HTTP status " +
       "code : %v", response.StatusCode)
-    glog.Error(msg)
+    log.Error(msg)
     err = errors.New(msg)
     return webhooksListener, err
   }
-  return webhooksListener, err
+
+  // Detect the AOS version, to check the cluster emits the subscribed
+  // events. The check is skipped if the version cannot be detected.
+  version, err := webhooksListener.getClusterVersion()
+  if (err != nil) {
+    log.Warn("Failed to detect AOS version. Events are not checked " +
+      "against it.", logger.ErrorKey, err)
+    return webhooksListener, nil
+  }
+  log.Info("Detected AOS version.", "version", version)
+  webhooksListener.clusterVersion = version
+  return webhooksListener, nil
+}
+
+// This method will return the AOS version of the cluster, as detected by
+// Initialize.
+//
+// Args:
+//    None.
+// Returns:
+//    string : AOS version, empty if unknown.
+func (webhooksListener WebhooksListener) ClusterVersion() (string) {
+  return webhooksListener.clusterVersion
+}
+
+// This method will get the AOS version of the cluster from Prism.
+//
+// Args:
+//    None.
+// Returns:
+//    string : AOS version, e.g. "5.10.2".
+//    error : Error, if any.
+func (webhooksListener WebhooksListener) getClusterVersion() (string, error) {
+  requestURL := webhooksListener.prismURL(lib.GetClusterInfo)
+  request := lib.PrepareRequestWithProxy(requestURL,
+    webhooksListener.clusterUsername, webhooksListener.clusterPassword,
+    "GET", webhooksListener.ClusterProxy)
+  response, err := webhooksListener.doPrismRequest(request)
+  if (err != nil) {
+    return "", err
+  }
+  defer response.Body.Close()
+  if (response.StatusCode != http.StatusOK) {
+    return "", fmt.Errorf("Unexpected HTTP status code %d",
+                          response.StatusCode)
+  }
+  var clusterInfo schema.ClusterInfo
+  respBytes, err := ioutil.ReadAll(response.Body)
+  if (err == nil) {
+    err = json.Unmarshal(respBytes, &clusterInfo)
+  }
+  if (err != nil) {
+    return "", err
+  }
+  if (clusterInfo.Version == "") {
+    return "", errors.New("cluster reported no version")
+  }
+  return clusterInfo.Version, nil
 }
 
 // This method allows the event consumer to register itself with the Nutanix
@@ -112,10 +232,12 @@ func (webhooksListener WebhooksListener) Initialize(ip string, port string,
 //
 // Args:
 //    events : The list of events that the caller is interested in.
-//             Must be one of:
+//             Must be registered in lib, by default one of:
 //             [
 //               "VM.CREATE", "VM.DELETE", "VM.ON", "VM.OFF", "VM.UPDATE",
-//               "VM.MIGRATE", "VM.NIC_PLUG", "VM.NIC_UNPLUG"
+//               "VM.MIGRATE", "VM.NIC_PLUG", "VM.NIC_UNPLUG",
+//               "SUBNET.CREATE", "SUBNET.UPDATE", "SUBNET.DELETE",
+//               "HOST.CREATE", "HOST.UPDATE", "HOST.DELETE", "CLUSTER.UPDATE"
 //             ]
 //    eventConsumer :
 //             Interface reference to the event consumer. This interface will
@@ -127,57 +249,171 @@ func (webhooksListener WebhooksListener) Initialize(ip string, port string,
 func (webhooksListener WebhooksListener) RegisterForEvents(events []string,
   eventConsumer interfaces.EventConsumer) (error) {
   var err error
-  glog.Infof("Registering for events %v", events)
+  log := logger.With(logger.ClusterKey, webhooksListener.clusterIp)
+  log.Info("Registering for events.", "events", events)
+  if (webhooksListener.status == nil) {
+    webhooksListener.status = &listenerStatus{}
+  }
 
-  err = lib.CheckPortAvailability(webhooksListener.ListenerPort)
+  err = lib.ValidateEventTypes(events, webhooksListener.clusterVersion)
   if (err != nil) {
-    glog.Errorf("Port %s cannot be used. %s.", webhooksListener.ListenerPort,
-      err)
+    log.Error("Cannot subscribe to events.", logger.ErrorKey, err)
+    return err
+  }
+
+  if (webhooksListener.UnixSocket != "" &&
+      webhooksListener.AdvertiseURL == "") {
+    err = errors.New("an advertise URL is required with a Unix socket, " +
+                     "Prism cannot reach it directly")
+    log.Error("Cannot register for events.", logger.ErrorKey, err)
     return err
   }
+  if (!webhooksListener.DisableServer &&
+      webhooksListener.UnixSocket == "") {
+    err = lib.CheckPortAvailability(webhooksListener.ListenerPort)
+    if (err != nil) {
+      log.Error("Port cannot be used.", "port", webhooksListener.ListenerPort,
+        logger.ErrorKey, err)
+      return err
+    }
+  }
+
+  // Queue the events of the consumer & start the HTTP WebhooksListener,
+  // then check that the callback URL is reachable before registering it
+  // with Prism. The previous consumer is restored if the registration fails.
+  previousConsumer, queue := webhooksListener.status.consumer()
+  previousQueue := queue
+  if (queue == nil) {
+    queue = make(chan schema.Event, webhooksListener.queueSize())
+  }
+  webhooksListener.status.setConsumer(eventConsumer, queue)
+  var server *http.Server
+  fail := func(msg string, err error) (error) {
+    log.Error(msg, logger.ErrorKey, err)
+    if (server != nil) {
+      server.Close()
+    }
+    webhooksListener.status.setConsumer(previousConsumer, previousQueue)
+    return err
+  }
+  if (!webhooksListener.DisableServer) {
+    server, err = webhooksListener.startListener()
+    if (err != nil) {
+      return fail("Failed to start listener.", err)
+    }
+    if (!webhooksListener.SkipReachabilityCheck) {
+      err = webhooksListener.checkReachability()
+      if (err != nil) {
+        return fail("Callback URL is not reachable.", err)
+      }
+    }
+  }
 
   // Create/update webhook for the given events.
   err = webhooksListener.createOrUpdateWebhook(events)
   if (err != nil) {
-    glog.Error("Failed to register.", err)
-    return err
+    return fail("Failed to register.", err)
   }
 
-  // Start HTTP WebhooksListener
-  webhooksListener.eventConsumer = eventConsumer
-  go webhooksListener.startListener()
-  return err
+  // Start the event dispatch & the webhook watchdog.
+  webhooksListener.startDispatcher()
+  webhooksListener.startWatchdog()
+  return nil
 }
 
-// This method opens a HTTP socket on the listener's port & listens for event
-// notifications from webhooks on the listener's callback URL.
+// This method will return the handler serving the listener's callback URL,
+// metrics & health endpoints on a mux of its own. It allows mounting the
+// listener in an existing HTTP server, along with DisableServer &
+// AdvertiseURL. To be called once RegisterForEvents succeeded.
 //
 // Args:
 //    None.
 // Returns:
+//    Handler : HTTP handler of the listener.
+func (webhooksListener WebhooksListener) Handler() (http.Handler) {
+  mux := http.NewServeMux()
+  mux.HandleFunc(webhooksListener.callbackPath(), webhooksListener.onEvent)
+  mux.Handle(lib.MetricsURL, metrics.Handler())
+  mux.HandleFunc(lib.HealthzURL, webhooksListener.onHealthz)
+  mux.HandleFunc(lib.ReadyzURL, webhooksListener.onReadyz)
+  return mux
+}
+
+// This method opens a HTTP socket on the listener's port, or Unix socket, &
+// starts serving the event notifications from webhooks on the listener's
+// callback URL.
+//
+// Args:
 //    None.
-func (webhooksListener WebhooksListener) startListener() (error){
-  var err error
+// Returns:
+//    Server : The running HTTP server.
+//    error : Error if the socket cannot be opened.
+func (webhooksListener WebhooksListener) startListener() (*http.Server,
+  error) {
   if(webhooksListener.ListenerPort == "") {
     webhooksListener.ListenerPort = lib.DefaultListenerPort
   }
-  webhooksListener.ListenerState <- "Starting HTTP Listener .."
-  webhooksListener.ListenerPort = ":" + webhooksListener.ListenerPort
-  http.HandleFunc(lib.ListenerCallbackURL, webhooksListener.onEvent)
-  err = http.ListenAndServe(webhooksListener.ListenerPort, nil)
-  if err != nil {
-    webhooksListener.ListenerState <- fmt.Sprintf("Error occured: %s",
-                                                   err.Error())
-    glog.Error("Listener error: ", err)
+  listener, err := webhooksListener.listen()
+  if (err != nil) {
+    return nil, err
+  }
+  server := webhooksListener.newServer(webhooksListener.Handler())
+  go webhooksListener.serve(server, listener)
+  return server, nil
+}
+
+// This method serves the HTTP requests until the server is closed,
+// reporting the listener state on the ListenerState channel if any. The
+// last state reported is lib.ListenerClosedState.
+//
+// Args:
+//    server : HTTP server.
+//    listener : Opened socket of the server.
+// Returns:
+//    None.
+func (webhooksListener WebhooksListener) serve(server *http.Server,
+  listener net.Listener) {
+  served := make(chan error, 1)
+  go func() {
+    served <- server.Serve(listener)
+  }()
+  webhooksListener.reportState("Starting HTTP Listener ..")
+  err := <-served
+  if (err != nil && err != http.ErrServerClosed) {
+    webhooksListener.reportState(fmt.Sprintf("Error occured: %s",
+                                             err.Error()))
+    logger.Error("Listener error.", logger.ClusterKey,
+      webhooksListener.clusterIp, logger.ErrorKey, err)
+  }
+  webhooksListener.reportState(lib.ListenerClosedState)
+}
+
+// This method will send the listener state on the ListenerState channel,
+// if the event consumer provided one. The state is dropped if the channel
+// is not ready, so that the listener never blocks on it.
+//
+// Args:
+//    state : State message.
+// Returns:
+//    None.
+func (webhooksListener WebhooksListener) reportState(state string) {
+  if (webhooksListener.ListenerState == nil) {
+    return
+  }
+  select {
+  case webhooksListener.ListenerState <- state:
+  default:
+    logger.Debug("Dropped listener state, channel not ready.", "state",
+      state)
   }
-  webhooksListener.ListenerState <- "Listener Closed"
-  close(webhooksListener.ListenerState)
-  return err
 }
 
 // This method will be invoked when the WebhooksListener receives an event. It will
-// invoke the event consumer's callback method & pass the received event to
-// the event consumer.
+// validate the event & queue it for the event consumer's OnEvent method. The
+// caller gets 202 once the event is queued, 400 for a malformed event, 401
+// or 403 for a rejected caller, 405 for other methods than POST, 413 for an
+// oversize event, 415 for other content than JSON and 503 when the event
+// cannot be queued. Rejections carry a JSON error body.
 //
 // Args:
 //    Note : Both these args are required in the method signature in order to
@@ -191,35 +427,83 @@ func (webhooksListener WebhooksListener) onEvent(
   responseWriter http.ResponseWriter, request *http.Request) {
   var event schema.Event
 
-  glog.Info("Received event.")
+  if (request.Header.Get(lib.ProbeHeader) != "") {
+    webhooksListener.onProbe(responseWriter, request)
+    return
+  }
+  log := logger.With(logger.ClusterKey, webhooksListener.clusterIp)
+  log.Info("Received event.")
+  ctx, span := tracing.StartSpan(tracing.Extract(request.Header),
+    "webhook.receive", tracing.ClusterKey.String(webhooksListener.clusterIp))
+  defer span.End()
+  reject := func(statusCode int, err error) {
+    tracing.RecordError(span, err)
+    webhooksListener.rejectCallback(responseWriter, statusCode, err)
+  }
+
+  statusCode, err := checkCallbackRequest(request)
+  if (err == nil) {
+    statusCode, err = webhooksListener.authorizeCallback(request)
+  }
+  if (err != nil) {
+    reject(statusCode, err)
+    return
+  }
 
   // Read event JSON from request.
-  body, err := ioutil.ReadAll(request.Body)
+  maxEventSize := webhooksListener.MaxEventSize
+  if (maxEventSize <= 0) {
+    maxEventSize = lib.DefaultMaxEventSize
+  }
+  body, err := ioutil.ReadAll(http.MaxBytesReader(responseWriter,
+    request.Body, maxEventSize))
   if err != nil {
-    glog.Error("Error reading input request body. Cannot proceed.", err)
+    var maxBytesErr *http.MaxBytesError
+    if (errors.As(err, &maxBytesErr)) {
+      reject(http.StatusRequestEntityTooLarge,
+        fmt.Errorf("event exceeds %d bytes", maxEventSize))
+      return
+    }
+    reject(http.StatusBadRequest,
+      fmt.Errorf("failed to read request body: %s", err))
     return
   }
   eventData := string(body)
-  glog.Info("Event data : " + eventData)
+  log.Debug("Event data.", "data", lib.RedactBody(eventData))
   err = json.Unmarshal([]byte(eventData), &event)
   if err != nil {
-    glog.Error("Failed to unmarshal event. Cannot proceed.", err)
+    reject(http.StatusBadRequest, fmt.Errorf("malformed event: %s", err))
     return
   }
+  if (event.Event_Type == "") {
+    reject(http.StatusBadRequest, errors.New("malformed event: " +
+      "missing event_type"))
+    return
+  }
+  err = lib.DecodePayload(&event)
+  if (err != nil) {
+    reject(http.StatusBadRequest, fmt.Errorf("malformed event: %s", err))
+    return
+  }
+  span.SetAttributes(tracing.EventTypeKey.String(event.Event_Type),
+    tracing.VMUUIDKey.String(event.EntityReference.UUID))
+  metrics.EventsReceived.WithLabelValues(event.Event_Type,
+    webhooksListener.clusterIp).Inc()
 
-  // Get the event consumer's callback method & invoke.
-  method := reflect.ValueOf(webhooksListener.eventConsumer).MethodByName(
-    lib.EventConsumerCallbackMethod)
-  if (method.IsValid()) {
-    glog.Info("Got event consumer method.")
-    methodArgs := make([]reflect.Value, method.Type().NumIn())
-    methodArgs[0] = reflect.ValueOf(event)
-    method.Call(methodArgs)
+  // Queue the event for delivery to the event consumer.
+  err = webhooksListener.enqueueEvent(event.WithContext(ctx))
+  if (err != nil) {
+    reject(http.StatusServiceUnavailable, err)
+    return
   }
+  writeJSON(responseWriter, http.StatusAccepted,
+    schema.CallbackResponse{Status: acceptedStatus})
 }
 
 // This method will create a webhook or update an existing webhook for the
-// given events.
+// given events. In the merge subscription mode, the events are added to the
+// events of the existing webhook. In the replace mode, the webhook gets
+// exactly the given events.
 //
 // Args:
 //    events : List of events for which to create or update webhook.
@@ -228,130 +512,390 @@ func (webhooksListener WebhooksListener) onEvent(
 func (webhooksListener WebhooksListener) createOrUpdateWebhook(
   events []string) (error) {
 
-  glog.Info("Getting existing webhooks..")
-  webhookName := fmt.Sprintf("%s%s",
-    lib.WebhookNamePrefix, webhooksListener.listenerIp)
-  var webhookListSpec schema.WebhooksListSpec
-  webhookListSpec.Kind = lib.WebhookKind
+  log := logger.With(logger.ClusterKey, webhooksListener.clusterIp)
+  ctx, span := tracing.StartSpan(nil, "webhook.register",
+    tracing.ClusterKey.String(webhooksListener.clusterIp))
+  defer span.End()
+  registered := false
+  var webhookUUID, webhookState string
+  defer func() {
+    span.SetAttributes(tracing.WebhookRegisteredKey.Bool(registered))
+    metrics.SetWebhookRegistered(webhooksListener.clusterIp, registered)
+    if (webhooksListener.status != nil) {
+      webhooksListener.status.setWebhook(webhookUUID, webhookState, registered)
+    }
+  }()
 
-  requestURL := fmt.Sprintf("https://%s:%s%s", webhooksListener.clusterIp,
-    webhooksListener.clusterPort, lib.ListWebhooks)
-  request := lib.PrepareRequest(requestURL, webhooksListener.clusterUsername,
-                                  webhooksListener.clusterPassword, "POST")
+  webhookToUpdate, err := webhooksListener.findWebhook(ctx)
+  if (err != nil) {
+    return err
+  }
+  eventList := events
+  if (webhookToUpdate.Metadata.UUID != "" &&
+      webhooksListener.SubscriptionMode != lib.SubscriptionReplace) {
+    eventList = append(webhookToUpdate.Spec.Resources.EventsFilterList,
+      events...)
+  }
+  eventList = lib.RemoveDuplicates(eventList)
 
-  requestData, err := json.Marshal(webhookListSpec)
+  webhookUUID, webhookState, registered, err = webhooksListener.putWebhook(
+    ctx, webhookToUpdate, eventList)
   if (err != nil) {
-    glog.Error("Failed to convert request spec into JSON. ", err)
     return err
   }
-  request.RequestData = string(requestData)
-  glog.Info("Request data : " + string(requestData[:]))
-  glog.Info("Request URL : " + requestURL)
+  log.Info("Successfully completed webhook operation.", "events", eventList)
+  if (webhooksListener.status != nil) {
+    webhooksListener.status.setEvents(eventList)
+  }
+  return nil
+}
 
-  response, err := lib.DoRequest(request)
+// This method allows the event consumer to replace the events it is
+// subscribed to, e.g. when its configuration is reloaded. The webhook is
+// updated with exactly the given events, whatever the SubscriptionMode.
+//
+// Args:
+//    events : The list of events that the caller is interested in.
+// Returns:
+//    error : Error, if any.
+func (webhooksListener WebhooksListener) UpdateSubscriptions(
+  events []string) (error) {
+  log := logger.With(logger.ClusterKey, webhooksListener.clusterIp)
+  log.Info("Updating subscriptions.", "events", events)
+  err := lib.ValidateEventTypes(events, webhooksListener.clusterVersion)
   if (err != nil) {
-    glog.Error("Failed to get webhooks.", err)
+    log.Error("Cannot subscribe to events.", logger.ErrorKey, err)
     return err
   }
+  webhooksListener.SubscriptionMode = lib.SubscriptionReplace
+  return webhooksListener.createOrUpdateWebhook(events)
+}
 
-  var currentWebhooks schema.CurrentWebhooks
-  respBytes, err := ioutil.ReadAll(response.Body)
-  err = json.Unmarshal(respBytes, &currentWebhooks)
+// This method allows the event consumer to unsubscribe from some of its
+// events. The webhook is deleted when no event remains.
+//
+// Args:
+//    events : The list of events that the caller is no longer interested in.
+// Returns:
+//    error : Error, if any.
+func (webhooksListener WebhooksListener) UnregisterForEvents(
+  events []string) (error) {
+  log := logger.With(logger.ClusterKey, webhooksListener.clusterIp)
+  log.Info("Unregistering from events.", "events", events)
+  ctx, span := tracing.StartSpan(nil, "webhook.unregister",
+    tracing.ClusterKey.String(webhooksListener.clusterIp))
+  defer span.End()
+
+  webhook, err := webhooksListener.findWebhook(ctx)
   if (err != nil) {
-    glog.Error("Failed to parse current webhooks.", err)
+    tracing.RecordError(span, err)
     return err
   }
+  if (webhook.Metadata.UUID == "") {
+    log.Info("No webhook registered. Nothing to unregister.")
+    if (webhooksListener.status != nil) {
+      webhooksListener.status.stopWatchdog()
+      webhooksListener.status.setEvents(nil)
+    }
+    return nil
+  }
+
+  eventList := lib.RemoveItems(webhook.Spec.Resources.EventsFilterList,
+    events)
+  if (len(eventList) == 0) {
+    err = webhooksListener.deleteWebhook(ctx, webhook.Metadata.UUID)
+    if (err != nil) {
+      tracing.RecordError(span, err)
+      return err
+    }
+    log.Info("Deleted webhook.", "uuid", webhook.Metadata.UUID)
+    metrics.SetWebhookRegistered(webhooksListener.clusterIp, false)
+    if (webhooksListener.status != nil) {
+      webhooksListener.status.stopWatchdog()
+      webhooksListener.status.setEvents(nil)
+      webhooksListener.status.clearWebhook()
+    }
+    return nil
+  }
 
-  glog.Info("Total existing webhooks:",
-    currentWebhooks.Metadata.TotalMatches)
-  postUrl := fmt.Sprintf("http://%s:%s%s", webhooksListener.listenerIp,
-    webhooksListener.ListenerPort,
-    lib.ListenerCallbackURL)
-  glog.Info("Looking for webhook with url :", postUrl)
+  uuid, state, registered, err := webhooksListener.putWebhook(ctx, webhook,
+    eventList)
+  if (err != nil) {
+    tracing.RecordError(span, err)
+    return err
+  }
+  log.Info("Updated webhook events.", "events", eventList)
+  metrics.SetWebhookRegistered(webhooksListener.clusterIp, registered)
+  if (webhooksListener.status != nil) {
+    webhooksListener.status.setEvents(eventList)
+    webhooksListener.status.setWebhook(uuid, state, registered)
+  }
+  return nil
+}
+
+// This method will look up the webhook of the listener, i.e. the webhook
+// carrying the listener ID in its description. Webhooks created before the
+// listener IDs are found through the listener's callback URL.
+//
+// Args:
+//    ctx : Context of the webhook operation.
+// Returns:
+//    Webhook : Webhook of the listener, with an empty UUID if none.
+//    error : Error, if any.
+func (webhooksListener WebhooksListener) findWebhook(
+  ctx context.Context) (schema.Webhook, error) {
   var webhookToUpdate schema.Webhook
-  for _, webhook := range currentWebhooks.Entities {
-    glog.Info("Webhook url :", webhook.Spec.Resources.PostURL)
-    if (webhook.Spec.Resources.PostURL == postUrl) {
-      glog.Info("Found matching webhook.")
+  log := logger.With(logger.ClusterKey, webhooksListener.clusterIp)
+  webhooks, err := webhooksListener.listWebhooks(ctx)
+  if (err != nil) {
+    return webhookToUpdate, err
+  }
+
+  postUrl := webhooksListener.callbackURL()
+  log.Info("Looking for webhook.", "listener_id",
+    webhooksListener.ListenerID, "post_url", postUrl)
+  for _, webhook := range webhooks {
+    log.Debug("Checking webhook.", "post_url", webhook.Spec.Resources.PostURL)
+    listenerID, err := lib.ListenerIDFromDescription(webhook.Spec.Description)
+    if (err == nil && listenerID == webhooksListener.ListenerID) {
+      log.Info("Found matching webhook.", "uuid", webhook.Metadata.UUID)
+      if (webhook.Spec.Resources.PostURL != postUrl) {
+        log.Info("Taking over webhook of previous listener address.",
+          "previous_post_url", webhook.Spec.Resources.PostURL)
+      }
+      return webhook, nil
+    }
+    if (err != nil && webhookToUpdate.Metadata.UUID == "" &&
+        webhook.Spec.Resources.PostURL == postUrl) {
       webhookToUpdate = webhook
+    }
+  }
+  if (webhookToUpdate.Metadata.UUID != "") {
+    log.Info("Found matching webhook by post URL.", "uuid",
+      webhookToUpdate.Metadata.UUID)
+  }
+  return webhookToUpdate, nil
+}
+
+// This method will list the webhooks of the cluster.
+//
+// Args:
+//    ctx : Context of the webhook operation.
+// Returns:
+//    []Webhook : Webhooks of the cluster.
+//    error : Error, if any.
+func (webhooksListener WebhooksListener) listWebhooks(
+  ctx context.Context) ([]schema.Webhook, error) {
+  log := logger.With(logger.ClusterKey, webhooksListener.clusterIp)
+  log.Info("Getting existing webhooks..")
+  var webhooks []schema.Webhook
+  var webhookListSpec schema.WebhooksListSpec
+  webhookListSpec.Kind = lib.WebhookKind
+  webhookListSpec.Length = lib.WebhookListPageSize
+
+  requestURL := webhooksListener.prismURL(lib.ListWebhooks)
+  // Fetch the webhooks page by page.
+  for {
+    request := lib.PrepareRequestWithProxy(requestURL,
+      webhooksListener.clusterUsername, webhooksListener.clusterPassword,
+      "POST", webhooksListener.ClusterProxy)
+    request.Context = ctx
+
+    requestData, err := json.Marshal(webhookListSpec)
+    if (err != nil) {
+      log.Error("Failed to convert request spec into JSON.",
+        logger.ErrorKey, err)
+      return nil, err
+    }
+    request.RequestData = string(requestData)
+    log.Debug("Listing webhooks.", logger.URLKey, requestURL,
+      "data", lib.RedactBody(string(requestData[:])))
+
+    response, err := webhooksListener.doPrismRequest(request)
+    if (err != nil) {
+      log.Error("Failed to get webhooks.", logger.ErrorKey, err)
+      return nil, err
+    }
+
+    var currentWebhooks schema.CurrentWebhooks
+    respBytes, err := ioutil.ReadAll(response.Body)
+    response.Body.Close()
+    if (err == nil && response.StatusCode != http.StatusOK) {
+      err = fmt.Errorf("Failed to list webhooks. HTTP status code : %d, " +
+                       "response : %s", response.StatusCode,
+                       lib.RedactBody(string(respBytes)))
+    }
+    if (err != nil) {
+      log.Error("Failed to get webhooks.", logger.ErrorKey, err)
+      return nil, err
+    }
+    err = json.Unmarshal(respBytes, &currentWebhooks)
+    if (err != nil) {
+      log.Error("Failed to parse current webhooks.", logger.ErrorKey, err)
+      return nil, err
+    }
+    webhooks = append(webhooks, currentWebhooks.Entities...)
+    webhookListSpec.Offset += len(currentWebhooks.Entities)
+    if (len(currentWebhooks.Entities) == 0 ||
+        webhookListSpec.Offset >= currentWebhooks.Metadata.TotalMatches) {
       break
     }
   }
 
+  log.Info("Got existing webhooks.", "total", len(webhooks))
+  return webhooks, nil
+}
+
+// This method will create the webhook of the listener, or update the given
+// existing webhook, with the given events.
+//
+// Args:
+//    ctx : Context of the webhook operation.
+//    webhookToUpdate : Existing webhook, with an empty UUID to create one.
+//    eventList : Events of the webhook.
+// Returns:
+//    string : UUID of the webhook.
+//    string : State of the webhook as reported by Prism.
+//    bool : Whether the webhook is registered and COMPLETE.
+//    error : Error, if any.
+func (webhooksListener WebhooksListener) putWebhook(ctx context.Context,
+  webhookToUpdate schema.Webhook, eventList []string) (string, string, bool,
+  error) {
+  var webhookState string
+  registered := false
+  log := logger.With(logger.ClusterKey, webhooksListener.clusterIp)
+  postUrl := webhooksListener.callbackURL()
+
+  var requestURL string
   var requestMethod string
-  var eventList []string
   var specVersion int
   if (webhookToUpdate.Metadata.UUID == "") {
-    glog.Info("No existing webhook found. Creating new webhook.")
-    requestURL = fmt.Sprintf("https://%s:%s%s", webhooksListener.clusterIp,
-      webhooksListener.clusterPort, lib.CreateWebhook)
+    log.Info("No existing webhook found. Creating new webhook.")
+    requestURL = webhooksListener.prismURL(lib.CreateWebhook)
     requestMethod = "POST"
-    eventList = events
     specVersion = 0
   } else {
-    glog.Info("Updating existing webhook.")
-    requestURL = fmt.Sprintf("https://%s:%s%s%s", webhooksListener.clusterIp,
-      webhooksListener.clusterPort, lib.UpdateWebhook,
+    log.Info("Updating existing webhook.")
+    requestURL = webhooksListener.prismURL(lib.UpdateWebhook +
       webhookToUpdate.Metadata.UUID)
     requestMethod = "PUT"
-    eventList = append(webhookToUpdate.Spec.Resources.EventsFilterList,
-      events...)
-    eventList = lib.RemoveDuplicates(eventList)
     specVersion = webhookToUpdate.Metadata.SpecVersion
   }
 
   var webhookCreationSpec schema.WebhookCreationSpec
   webhookCreationSpec.Metadata.Kind = lib.WebhookKind
   webhookCreationSpec.Metadata.SpecVersion = specVersion
-  webhookCreationSpec.Spec.Name = webhookName
+  webhookCreationSpec.Spec.Name = lib.WebhookName(webhooksListener.ListenerID)
+  webhookCreationSpec.Spec.Description = lib.WebhookDescription(
+    webhooksListener.ListenerID)
   webhookCreationSpec.Spec.Resources.PostUrl = string(postUrl)
   webhookCreationSpec.ApiVersion = "3.0"
   webhookCreationSpec.Spec.Resources.EventsFilterList = eventList
+  if (webhooksListener.CallbackCredentials.Username != "") {
+    webhookCreationSpec.Spec.Resources.Credentials = &schema.WebhookCredentials{
+      Username: webhooksListener.CallbackCredentials.Username,
+      Password: webhooksListener.CallbackCredentials.Password,
+    }
+  }
 
-  request = lib.PrepareRequest(requestURL, webhooksListener.clusterUsername,
-                                  webhooksListener.clusterPassword, requestMethod)
+  request := lib.PrepareRequestWithProxy(requestURL,
+    webhooksListener.clusterUsername, webhooksListener.clusterPassword,
+    requestMethod, webhooksListener.ClusterProxy)
+  request.Context = ctx
 
-  requestData, err = json.Marshal(webhookCreationSpec)
+  requestData, err := json.Marshal(webhookCreationSpec)
   if (err != nil) {
-    glog.Error("Failed to convert request spec into JSON. ", err)
-    return err
+    log.Error("Failed to convert request spec into JSON.", logger.ErrorKey, err)
+    return "", "", false, err
   }
-  glog.Info("Request URL : " + requestURL)
-  glog.Info("Request data : " + string(requestData[:]))
+  log.Debug("Sending webhook spec.", logger.URLKey, requestURL,
+    "data", lib.RedactBody(string(requestData[:])))
   request.RequestData = string(requestData)
 
-  response, err = lib.DoRequest(request)
+  response, err := webhooksListener.doPrismRequest(request)
   if (err != nil) {
-    glog.Error("Failed to perform webhook operation.", err)
-    return err
+    log.Error("Failed to perform webhook operation.", logger.ErrorKey, err)
+    return "", "", false, err
   }
-  if (response.StatusCode == pendingStatusCode) { // Webhook request is accepted and processing.
-    respBytes, err = ioutil.ReadAll(response.Body)
-    var webhook schema.Webhook
-    err = json.Unmarshal(respBytes, &webhook)
+  webhookUUID := webhookToUpdate.Metadata.UUID
+  respBytes, err := ioutil.ReadAll(response.Body)
+  response.Body.Close()
+  if (err == nil && (response.StatusCode < 200 ||
+                     response.StatusCode > 299)) {
+    err = fmt.Errorf("Webhook operation failed. HTTP status code : %d, " +
+                     "response : %s", response.StatusCode,
+                     lib.RedactBody(string(respBytes)))
+  }
+  if (err != nil) {
+    log.Error("Failed to perform webhook operation.", logger.ErrorKey, err)
+    return webhookUUID, "", false, err
+  }
+
+  // Prism answers 200 or 202 (accepted & processing) with the webhook.
+  var webhook schema.Webhook
+  err = json.Unmarshal(respBytes, &webhook)
+  if (err != nil) {
+    log.Error("Failed to parse webhook.", logger.ErrorKey, err)
+    return webhookUUID, "", false, err
+  }
+  if (webhook.Metadata.UUID != "") {
+    webhookUUID = webhook.Metadata.UUID
+  }
+  registered = (webhook.Status.State == completeStatus)
+  webhookState = webhook.Status.State
+  if (webhook.Status.State == pendingStatus && webhookUUID != "") {
+    requestURL = webhooksListener.prismURL(lib.GetWebhook)
+    requestURL = strings.Replace(requestURL, "{uuid}", webhookUUID, 1)
+    request = lib.PrepareRequestWithProxy(requestURL,
+      webhooksListener.clusterUsername, webhooksListener.clusterPassword,
+      "GET", webhooksListener.ClusterProxy)
+    request.Context = ctx
+    response, err = webhooksListener.doPrismRequest(request)
     if (err != nil) {
-      glog.Error("Failed to parse current webhooks.", err)
-      return err
+      log.Error("Failed to perform webhook operation.", logger.ErrorKey, err)
+      return webhookUUID, webhookState, false, err
     }
-    if (webhook.Status.State == pendingStatus) {
-      requestURL = fmt.Sprintf("https://%s:%s%s", webhooksListener.clusterIp,
-      webhooksListener.clusterPort, lib.GetWebhook)
-      requestURL = strings.Replace(requestURL, "{uuid}", webhook.Metadata.UUID, 1)
-      request = lib.PrepareRequest(requestURL, webhooksListener.clusterUsername,
-                                  webhooksListener.clusterPassword, "GET")
-      response, err = lib.DoRequest(request)
-      if (err != nil) {
-        glog.Error("Failed to perform webhook operation.", err)
-        return err
-      }
-      respBytes, err = ioutil.ReadAll(response.Body)
+    respBytes, err = ioutil.ReadAll(response.Body)
+    response.Body.Close()
+    if (err == nil && response.StatusCode == http.StatusOK) {
       err = json.Unmarshal(respBytes, &webhook)
-      if(response.StatusCode == 200 && webhook.Status.State == completeStatus) {
-        glog.Info("Webhook registration complete.")
+      if (err == nil) {
+        webhookState = webhook.Status.State
       }
     }
+    if (webhookState == completeStatus) {
+      log.Info("Webhook registration complete.")
+      registered = true
+    }
   }
-  glog.Info("Successfully completed webhook operation.")
+  return webhookUUID, webhookState, registered, nil
+}
 
-  return err
+// This method will delete the webhook with the given UUID.
+//
+// Args:
+//    ctx : Context of the webhook operation.
+//    uuid : UUID of the webhook.
+// Returns:
+//    error : Error, if any.
+func (webhooksListener WebhooksListener) deleteWebhook(ctx context.Context,
+  uuid string) (error) {
+  requestURL := webhooksListener.prismURL(lib.DeleteWebhook)
+  requestURL = strings.Replace(requestURL, "{uuid}", uuid, 1)
+  request := lib.PrepareRequestWithProxy(requestURL,
+    webhooksListener.clusterUsername, webhooksListener.clusterPassword,
+    "DELETE", webhooksListener.ClusterProxy)
+  request.Context = ctx
+  response, err := webhooksListener.doPrismRequest(request)
+  if (err != nil) {
+    return err
+  }
+  defer response.Body.Close()
+  switch response.StatusCode {
+  case http.StatusOK, http.StatusAccepted, http.StatusNoContent,
+       http.StatusNotFound:
+    return nil
+  }
+  return fmt.Errorf("Failed to delete webhook %s. HTTP status code : %d",
+                    uuid, response.StatusCode)
 }